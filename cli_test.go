@@ -1,11 +1,18 @@
 package main_test
 
 import (
+	"encoding/json"
+	"errors"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"runtime"
+	"strconv"
 	"strings"
 	"testing"
+	"time"
+
+	"github.com/mattn/go-runewidth"
 )
 
 // TestCLI_SquashTwoCommits tests squashing 2 commits into 1
@@ -56,6 +63,22 @@ func TestCLI_UsesOldestMessageByDefault(t *testing.T) {
 	}
 }
 
+// TestCLI_MessageFromNewest tests that -message-from newest uses the newest commit's message
+func TestCLI_MessageFromNewest(t *testing.T) {
+	tr := newTestRepo(t)
+	tr.createCommitsWithMessages("base", "wip 1", "wip 2", "add the feature")
+
+	tr.runCLISuccess("-n", "3", "-yes", "-message-from", "newest")
+	if msg := tr.lastCommitMessage(); msg != "add the feature" {
+		t.Errorf("expected 'add the feature', got %q", msg)
+	}
+
+	out, code := tr.runCLIExitCode("-n", "2", "-yes", "-message-from", "middle")
+	if code != 2 || !strings.Contains(out, "invalid -message-from") {
+		t.Errorf("expected usage error for an unknown -message-from, got exit %d: %s", code, out)
+	}
+}
+
 // TestCLI_CreatesBackupBranch tests that a backup branch is created
 func TestCLI_CreatesBackupBranch(t *testing.T) {
 	tr := newTestRepo(t)
@@ -174,6 +197,241 @@ func TestCLI_FailsWithSingleCommitRepo(t *testing.T) {
 	}
 }
 
+// TestCLI_FailsWithNoCommits tests that a repository without commits gets a clear error instead of a git one
+func TestCLI_FailsWithNoCommits(t *testing.T) {
+	tr := newTestRepo(t)
+
+	for _, args := range [][]string{{"-n", "2"}, {"-to", "HEAD~1", "-dry-run"}, {"-reword", "-m", "x"}} {
+		out := tr.runCLIFailure(args...)
+		if !strings.Contains(out, "the repository has no commits yet") || strings.Contains(out, "fatal:") {
+			t.Errorf("%v: expected the no-commits error, got: %s", args, out)
+		}
+	}
+}
+
+// TestCLI_WorkdirSquashesAnotherRepo tests that -C and -workdir operate on a repository other than the current directory
+func TestCLI_WorkdirSquashesAnotherRepo(t *testing.T) {
+	tr := newTestRepo(t)
+	tr.createCommitsWithMessages("a", "b", "c", "d")
+	elsewhere := t.TempDir()
+	if err := os.WriteFile(filepath.Join(tr.Dir, "msg.txt"), []byte("from file\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	tr.git(t.Context(), "add", "msg.txt")
+	tr.git(t.Context(), "commit", "-q", "-m", "e")
+
+	if out, err := tr.runCLIFrom(elsewhere, "-C", tr.Dir, "-n", "2", "-F", "msg.txt", "-y"); err != nil {
+		t.Fatalf("-C failed: %v\n%s", err, out)
+	}
+	if count := tr.commitCount(); count != 4 {
+		t.Errorf("expected 4 commits after squashing 2 via -C, got %d", count)
+	}
+	if msg := tr.lastCommitMessage(); msg != "from file" {
+		t.Errorf("expected -F to be read relative to -C, got %q", msg)
+	}
+
+	if out, err := tr.runCLIFrom(elsewhere, "-workdir", filepath.Join(tr.Dir, ".git", ".."), "-n", "2", "-m", "again", "-y"); err != nil {
+		t.Fatalf("-workdir failed: %v\n%s", err, out)
+	}
+	if count := tr.commitCount(); count != 3 {
+		t.Errorf("expected 3 commits after squashing via -workdir, got %d", count)
+	}
+
+	out, err := tr.runCLIFrom(elsewhere, "-C", elsewhere, "-n", "2", "-y")
+	if err == nil || !strings.Contains(out, "not a git repository") {
+		t.Errorf("expected a directory outside any repository to be refused, got %v: %s", err, out)
+	}
+	out, err = tr.runCLIFrom(elsewhere, "-C", filepath.Join(elsewhere, "missing"), "-n", "2", "-y")
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) || exitErr.ExitCode() != 2 || !strings.Contains(out, "is not a directory") {
+		t.Errorf("expected a usage error for a missing directory, got %v: %s", err, out)
+	}
+}
+
+// TestCLI_YesIfClean tests that -yes-if-clean skips the prompt for a clean tree but not for a dirty one
+func TestCLI_YesIfClean(t *testing.T) {
+	tr := newTestRepo(t)
+	tr.createCommitsWithMessages("a", "b", "c", "d", "e")
+
+	tr.runCLISuccess("-n", "2", "-m", "clean", "-yes-if-clean")
+	if count := tr.commitCount(); count != 4 {
+		t.Errorf("expected the clean squash to go ahead without a prompt, got %d commits", count)
+	}
+
+	tr.writeFile("wip.txt", "work in progress")
+	out := tr.runCLIFailure("-n", "2", "-m", "dirty", "-stash", "-yes-if-clean")
+	if !strings.Contains(out, "stdin is not a terminal") {
+		t.Errorf("expected a dirty tree to fall back to the prompt, got: %s", out)
+	}
+	if count := tr.commitCount(); count != 4 {
+		t.Errorf("expected nothing to change without confirmation, got %d commits", count)
+	}
+
+	tr.runCLISuccess("-n", "2", "-m", "dirty", "-stash", "-yes-if-clean", "-y")
+	if count := tr.commitCount(); count != 3 {
+		t.Errorf("expected -yes to win over -yes-if-clean, got %d commits", count)
+	}
+}
+
+// TestCLI_WarnThresholdNamesTheOldestCommit tests that squashing more commits than -warn-threshold needs -yes
+func TestCLI_WarnThresholdNamesTheOldestCommit(t *testing.T) {
+	tr := newTestRepo(t)
+	tr.createCommitsWithMessages("base", "start of feature", "b", "c")
+
+	out := tr.runCLIFailure("-n", "3", "-m", "squashed", "-warn-threshold", "2", "-yes-if-clean")
+	if !strings.Contains(out, "squash 3 commits, more than -warn-threshold 2") || !strings.Contains(out, `"start of feature"`) {
+		t.Errorf("expected a warning with the count and oldest subject, got: %s", out)
+	}
+	if !strings.Contains(out, "stdin is not a terminal") {
+		t.Errorf("expected -yes-if-clean to still need confirmation, got: %s", out)
+	}
+
+	out = tr.runCLISuccess("-n", "3", "-m", "squashed", "-warn-threshold", "2", "-y")
+	if strings.Contains(out, "-warn-threshold") {
+		t.Errorf("expected -yes to skip the warning, got: %s", out)
+	}
+	if count := tr.commitCount(); count != 2 {
+		t.Errorf("expected 2 commits after squashing, got %d", count)
+	}
+}
+
+// TestCLI_DumpPlanRoundTrips tests that -dump-plan writes a complete plan without changing anything
+func TestCLI_DumpPlanRoundTrips(t *testing.T) {
+	tr := newTestRepo(t)
+	tr.createCommitsWithMessages("base", "one", "two", "three")
+	head := tr.git(t.Context(), "rev-parse", "HEAD")
+	path := filepath.Join(t.TempDir(), "plan.json")
+
+	tr.runCLISuccess("-n", "3", "-m", "feature", "-trailer", "Refs: #1", "-dump-plan", path)
+	if got := tr.git(t.Context(), "rev-parse", "HEAD"); got != head {
+		t.Fatalf("expected -dump-plan not to change HEAD")
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("expected a plan file: %v", err)
+	}
+
+	var plan struct {
+		Version       int    `json:"version"`
+		Mode          string `json:"mode"`
+		TopRef        string `json:"top_ref"`
+		ResetRef      string `json:"reset_ref"`
+		SquashCount   int    `json:"squash_count"`
+		BackupName    string `json:"backup_name"`
+		BackupKind    string `json:"backup_kind"`
+		CommitMessage string `json:"commit_message"`
+		Author        string `json:"author"`
+		CommitDate    string `json:"commit_date"`
+		Commits       []struct {
+			Hash      string `json:"hash"`
+			Subject   string `json:"subject"`
+			Additions int    `json:"additions"`
+		} `json:"commits"`
+	}
+	if err = json.Unmarshal(data, &plan); err != nil {
+		t.Fatalf("invalid plan JSON: %v\n%s", err, data)
+	}
+	if plan.Version != 1 || plan.Mode != "squash" || plan.TopRef != "HEAD" || plan.ResetRef != "HEAD~3" || plan.SquashCount != 3 {
+		t.Errorf("unexpected plan header: %+v", plan)
+	}
+	if !strings.HasPrefix(plan.BackupName, "locsquash/backup-") || plan.BackupKind != "branch" {
+		t.Errorf("expected the backup branch in the plan, got %q (%q)", plan.BackupName, plan.BackupKind)
+	}
+	if plan.CommitMessage != "feature\n\nRefs: #1" || plan.Author != "Test User <test@test.local>" || plan.CommitDate == "" {
+		t.Errorf("expected the final message, author and date, got %+v", plan)
+	}
+	if len(plan.Commits) != 3 || plan.Commits[0].Subject != "three" || plan.Commits[2].Subject != "one" || plan.Commits[0].Additions == 0 {
+		t.Errorf("expected the three commits newest first with stats, got %+v", plan.Commits)
+	}
+}
+
+// TestCLI_PlanExecutesDumpedPlan tests that -plan carries out exactly the squash recorded by -dump-plan
+func TestCLI_PlanExecutesDumpedPlan(t *testing.T) {
+	tr := newTestRepo(t)
+	tr.createCommitsWithMessages("base")
+	tr.createCommitAs("Alice <alice@example.com>", "one")
+	tr.createCommitsWithMessages("two", "three")
+	tree := tr.git(t.Context(), "rev-parse", "HEAD^{tree}")
+	path := filepath.Join(t.TempDir(), "plan.json")
+
+	tr.runCLISuccess("-n", "3", "-m", "Squash {count}", "-s", "-dump-plan", path)
+
+	out := tr.runCLIFailure("-plan", path, "-n", "2", "-y")
+	if !strings.Contains(out, "cannot be combined") {
+		t.Errorf("expected -plan with -n to be refused, got: %s", out)
+	}
+
+	tr.runCLISuccess("-plan", path, "-y")
+	if count := tr.commitCount(); count != 2 {
+		t.Errorf("expected 2 commits after executing the plan, got %d", count)
+	}
+	want := "Squash 3\n\nSigned-off-by: Test User <test@test.local>"
+	if msg := tr.git(t.Context(), "log", "-1", "--format=%B"); msg != want {
+		t.Errorf("expected the planned message %q, got %q", want, msg)
+	}
+	if author := tr.git(t.Context(), "log", "-1", "--format=%an <%ae>"); author != "Alice <alice@example.com>" {
+		t.Errorf("expected the planned author, got %q", author)
+	}
+	if got := tr.git(t.Context(), "rev-parse", "HEAD^{tree}"); got != tree {
+		t.Errorf("expected the tree to be unchanged")
+	}
+}
+
+// TestCLI_PlanKeepsTheMessageAsPlanned tests that -plan commits the planned message without
+// expanding placeholders or wrapping it again, and that -C resolves relative plan paths
+func TestCLI_PlanKeepsTheMessageAsPlanned(t *testing.T) {
+	tr := newTestRepo(t)
+	tr.createCommitsWithMessages("base", "print {count} items", "more")
+	elsewhere := t.TempDir()
+	// Inside .git the plan does not count as an uncommitted change
+	const path = ".git/plan.json"
+
+	if out, err := tr.runCLIFrom(elsewhere, "-C", tr.Dir, "-n", "2", "-concat-messages", "-wrap", "12", "-dump-plan", path); err != nil {
+		t.Fatalf("-dump-plan failed: %v\n%s", err, out)
+	}
+	data, err := os.ReadFile(filepath.Join(tr.Dir, path))
+	if err != nil {
+		t.Fatalf("expected the plan to be written relative to -C: %v", err)
+	}
+	var p struct {
+		CommitMessage string `json:"commit_message"`
+	}
+	if err = json.Unmarshal(data, &p); err != nil {
+		t.Fatalf("invalid plan: %v", err)
+	}
+
+	if out, err := tr.runCLIFrom(elsewhere, "-C", tr.Dir, "-plan", path, "-y"); err != nil {
+		t.Fatalf("-plan failed: %v\n%s", err, out)
+	}
+	if msg := tr.git(t.Context(), "log", "-1", "--format=%B"); msg != p.CommitMessage || !strings.Contains(msg, "{count}") {
+		t.Errorf("expected the planned message %q, got %q", p.CommitMessage, msg)
+	}
+}
+
+// TestCLI_PlanRejectsStalePlan tests that -plan refuses to run once the commits it was made for changed
+func TestCLI_PlanRejectsStalePlan(t *testing.T) {
+	tr := newTestRepo(t)
+	tr.createCommitsWithMessages("base", "one", "two")
+	path := filepath.Join(t.TempDir(), "plan.json")
+	tr.runCLISuccess("-n", "2", "-m", "squashed", "-dump-plan", path)
+
+	tr.createCommit("three")
+	head := tr.git(t.Context(), "rev-parse", "HEAD")
+	out := tr.runCLIFailure("-plan", path, "-y")
+	if !strings.Contains(out, "the plan no longer matches the repository") || !strings.Contains(out, "-dump-plan") {
+		t.Errorf("expected a stale-plan error, got: %s", out)
+	}
+	if got := tr.git(t.Context(), "rev-parse", "HEAD"); got != head {
+		t.Errorf("expected a stale plan to change nothing")
+	}
+
+	tr.writeFile("bad.json", `{"version": 99}`)
+	out = tr.runCLIFailure("-plan", filepath.Join(tr.Dir, "bad.json"), "-y")
+	if !strings.Contains(out, "unsupported plan version 99") {
+		t.Errorf("expected an unsupported version error, got: %s", out)
+	}
+}
+
 // TestCLI_FailsWithUncommittedChanges tests dirty working directory handling
 func TestCLI_FailsWithUncommittedChanges(t *testing.T) {
 	tr := newTestRepo(t)
@@ -290,7 +548,7 @@ func TestCLI_MultipleSquashesCreateUniqueBackups(t *testing.T) {
 }
 
 // TestCLI_BackupBranchCollision tests that backup branches get unique suffixes
-// when the base name already exists (tests branchExists using git show-ref).
+// when the base name already exists (tests refExists using git show-ref).
 // This runs multiple squashes in rapid succession to force collision handling
 func TestCLI_BackupBranchCollision(t *testing.T) {
 	tr := newTestRepo(t)
@@ -345,6 +603,46 @@ func TestCLI_EmptySquashFailsWithoutAllowEmpty(t *testing.T) {
 	}
 }
 
+// TestCLI_IntermediateEmptyCommitsNeedNoAllowEmpty ensures empty commits inside the range are simply folded away
+func TestCLI_IntermediateEmptyCommitsNeedNoAllowEmpty(t *testing.T) {
+	tr := newTestRepo(t)
+	tr.createCommitsWithMessages("base", "a")
+	tr.git(t.Context(), "commit", "-q", "--allow-empty", "-m", "empty")
+	tr.createCommit("b")
+
+	tr.runCLISuccess("-n", "3", "-m", "squashed", "-yes")
+
+	if count := tr.commitCount(); count != 2 {
+		t.Errorf("expected 2 commits after squash, got %d", count)
+	}
+	if files := tr.git(t.Context(), "show", "--name-only", "--format=", "HEAD"); files != "file.txt" {
+		t.Errorf("expected squashed commit to contain the range's changes, got %q", files)
+	}
+}
+
+// TestCLI_EmptySquashRejectedBeforeRewrite ensures an empty squash is refused before any backup or reset
+func TestCLI_EmptySquashRejectedBeforeRewrite(t *testing.T) {
+	tr := newTestRepo(t)
+	tr.createCommitsWithMessages("base")
+	tr.writeFile("temp.txt", "temp")
+	tr.git(t.Context(), "add", "temp.txt")
+	tr.git(t.Context(), "commit", "-q", "-m", "add temp")
+	tr.git(t.Context(), "rm", "-q", "temp.txt")
+	tr.git(t.Context(), "commit", "-q", "-m", "remove temp")
+	head := tr.git(t.Context(), "rev-parse", "HEAD")
+
+	out := tr.runCLIFailure("-n", "2", "-yes")
+	if !strings.Contains(out, "no net changes") {
+		t.Errorf("expected error about no net changes, got: %s", out)
+	}
+	if got := tr.git(t.Context(), "rev-parse", "HEAD"); got != head {
+		t.Errorf("expected HEAD to be unchanged, got %s", got)
+	}
+	if refs := tr.git(t.Context(), "for-each-ref", "refs/heads/locsquash/"); refs != "" {
+		t.Errorf("expected no backup branch, got: %s", refs)
+	}
+}
+
 // TestCLI_EmptySquashSucceedsWithAllowEmpty ensures empty squashes succeed with -allow-empty
 func TestCLI_EmptySquashSucceedsWithAllowEmpty(t *testing.T) {
 	tr := newTestRepo(t)
@@ -452,6 +750,41 @@ func TestCLI_DryRunShowsCommitList(t *testing.T) {
 	}
 }
 
+// TestCLI_DryRunCommitListShowsAuthorAndDate tests that the commit list includes the
+// relative date and author name, with the subjects aligned in one column
+func TestCLI_DryRunCommitListShowsAuthorAndDate(t *testing.T) {
+	tr := newTestRepo(t)
+	tr.createCommitsWithMessages("first commit", "second commit", "third commit")
+	tr.git(t.Context(), "commit", "--allow-empty", "-m", "fourth commit", "--author", "Al <al@test.local>")
+
+	out := tr.runCLISuccess("-n", "3", "-dry-run", "-no-color")
+
+	var lines []string
+	for line := range strings.SplitSeq(out, "\n") {
+		if strings.HasSuffix(line, " commit") && strings.HasPrefix(line, "  ") {
+			lines = append(lines, line)
+		}
+	}
+	if len(lines) != 3 {
+		t.Fatalf("expected 3 commit lines, got %d in: %s", len(lines), out)
+	}
+	if !strings.Contains(lines[0], " Al ") || !strings.Contains(lines[1], "Test User") {
+		t.Errorf("expected author names in commit list, got: %q", lines)
+	}
+	col := -1
+	for _, line := range lines {
+		if !strings.Contains(line, " ago") {
+			t.Errorf("expected relative date in commit line, got: %q", line)
+		}
+		i := strings.LastIndex(line, "  ") + 2
+		if col == -1 {
+			col = i
+		} else if i != col {
+			t.Errorf("expected subjects aligned at column %d, got %d in %q", col, i, line)
+		}
+	}
+}
+
 // TestCLI_PrintRecoveryWithNoBackup tests that print-recovery shows warning when -no-backup is used
 func TestCLI_PrintRecoveryWithNoBackup(t *testing.T) {
 	tr := newTestRepo(t)
@@ -526,3 +859,3156 @@ func TestCLI_ListBackupsNoNRequired(t *testing.T) {
 		t.Errorf("expected list backups to work without -n, got: %s", out)
 	}
 }
+
+// TestCLI_ToRefSquashesDownToRef tests that -to squashes all commits above the given ref
+func TestCLI_ToRefSquashesDownToRef(t *testing.T) {
+	tr := newTestRepo(t)
+	tr.createCommitsWithMessages("base", "one", "two", "three")
+
+	base := tr.git(t.Context(), "rev-parse", "HEAD~3")
+
+	tr.runCLISuccess("-to", base, "-m", "squashed", "-yes")
+
+	if count := tr.commitCount(); count != 2 {
+		t.Errorf("expected 2 commits after squash, got %d", count)
+	}
+	if parent := tr.git(t.Context(), "rev-parse", "HEAD~1"); parent != base {
+		t.Errorf("expected squashed commit parent %s, got %s", base, parent)
+	}
+}
+
+// TestCLI_ToRefConflictsWithN tests that -n and -to cannot be combined
+func TestCLI_ToRefConflictsWithN(t *testing.T) {
+	tr := newTestRepo(t)
+	tr.createCommitsWithMessages("a", "b", "c")
+
+	out := tr.runCLIFailure("-n", "2", "-to", "HEAD~2")
+
+	if !strings.Contains(out, "mutually exclusive") {
+		t.Errorf("expected mutually exclusive error, got: %s", out)
+	}
+}
+
+// TestCLI_ToRefInvalid tests that an unknown -to ref fails before touching anything
+func TestCLI_ToRefInvalid(t *testing.T) {
+	tr := newTestRepo(t)
+	tr.createCommitsWithMessages("a", "b", "c")
+
+	headBefore := tr.git(t.Context(), "rev-parse", "HEAD")
+
+	out := tr.runCLIFailure("-to", "does-not-exist", "-yes")
+
+	if !strings.Contains(out, "does not exist") {
+		t.Errorf("expected unknown ref error, got: %s", out)
+	}
+	if headAfter := tr.git(t.Context(), "rev-parse", "HEAD"); headBefore != headAfter {
+		t.Errorf("HEAD changed on failure: before=%s, after=%s", headBefore, headAfter)
+	}
+}
+
+// TestCLI_ToRefNotAncestor tests that a -to ref outside HEAD's history is rejected
+func TestCLI_ToRefNotAncestor(t *testing.T) {
+	tr := newTestRepo(t)
+	tr.createCommitsWithMessages("a", "b")
+	tr.git(t.Context(), "checkout", "-b", "side")
+	tr.createCommitsWithMessages("side commit")
+	tr.git(t.Context(), "checkout", "-")
+	tr.createCommitsWithMessages("c", "d")
+
+	out := tr.runCLIFailure("-to", "side", "-yes")
+
+	if !strings.Contains(out, "not an ancestor of HEAD") {
+		t.Errorf("expected not-an-ancestor error, got: %s", out)
+	}
+}
+
+// TestCLI_ToRefTooFewCommits tests that -to selecting a single commit is rejected
+func TestCLI_ToRefTooFewCommits(t *testing.T) {
+	tr := newTestRepo(t)
+	tr.createCommitsWithMessages("a", "b", "c")
+
+	out := tr.runCLIFailure("-to", "HEAD~1", "-yes")
+
+	if !strings.Contains(out, "at least 2") {
+		t.Errorf("expected too-few-commits error, got: %s", out)
+	}
+}
+
+// TestCLI_FromToSquashesMiddleRange tests that -from/-to squashes a block and replays newer commits
+func TestCLI_FromToSquashesMiddleRange(t *testing.T) {
+	tr := newTestRepo(t)
+	tr.createCommitsWithMessages("base", "one", "two", "three", "four")
+
+	treeBefore := tr.git(t.Context(), "rev-parse", "HEAD^{tree}")
+	from := tr.git(t.Context(), "rev-parse", "HEAD~3")
+	to := tr.git(t.Context(), "rev-parse", "HEAD~1")
+
+	tr.runCLISuccess("-from", from, "-to", to, "-m", "squashed", "-yes")
+
+	if count := tr.commitCount(); count != 3 {
+		t.Errorf("expected 3 commits after squash, got %d", count)
+	}
+	if msg := tr.lastCommitMessage(); msg != "four" {
+		t.Errorf("expected replayed commit 'four' at HEAD, got %q", msg)
+	}
+	if msg := tr.git(t.Context(), "log", "-1", "--format=%s", "HEAD~1"); msg != "squashed" {
+		t.Errorf("expected squashed commit below HEAD, got %q", msg)
+	}
+	if treeAfter := tr.git(t.Context(), "rev-parse", "HEAD^{tree}"); treeAfter != treeBefore {
+		t.Errorf("tree changed: before=%s, after=%s", treeBefore, treeAfter)
+	}
+	branches := tr.git(t.Context(), "branch", "-a")
+	if !strings.Contains(branches, "locsquash/backup-") {
+		t.Errorf("expected backup branch to be created, branches: %s", branches)
+	}
+}
+
+// TestCLI_CleanupModes tests that -cleanup decides which lines of the message survive, both for
+// git commit and for the commit-tree path of a replayed range, and that unknown modes are rejected
+func TestCLI_CleanupModes(t *testing.T) {
+	tr := newTestRepo(t)
+	tr.createCommitsWithMessages("base", "one", "two", "three", "four", "five")
+	const message = "squashed\n\n# 12 was the issue\nbody  "
+
+	tr.runCLISuccess("-n", "2", "-m", message, "-yes")
+	if msg := tr.git(t.Context(), "log", "-1", "--format=%B"); msg != "squashed\n\n# 12 was the issue\nbody" {
+		t.Errorf("expected the default cleanup to keep the '#' line without an editor, got %q", msg)
+	}
+
+	tr.runCLISuccess("-n", "2", "-m", message, "-cleanup", "strip", "-allow-empty", "-yes")
+	if msg := tr.git(t.Context(), "log", "-1", "--format=%B"); msg != "squashed\n\nbody" {
+		t.Errorf("expected -cleanup strip to remove the '#' line, got %q", msg)
+	}
+
+	const verbatim = "squashed\n\n\n# 12 was the issue\nbody"
+	tr.runCLISuccess("-n", "2", "-m", verbatim, "-cleanup", "verbatim", "-yes")
+	if msg := tr.git(t.Context(), "cat-file", "commit", "HEAD"); !strings.HasSuffix(msg, "\n\n"+verbatim) {
+		t.Errorf("expected -cleanup verbatim to keep the message as given, got %q", msg)
+	}
+
+	tr.createCommitsWithMessages("six", "seven", "eight")
+	tr.runCLISuccess("-from", "HEAD~2", "-to", "HEAD~1", "-m", message, "-cleanup", "strip", "-yes")
+	if msg := tr.git(t.Context(), "log", "-1", "--format=%B", "HEAD~1"); msg != "squashed\n\nbody" {
+		t.Errorf("expected the replayed range to be cleaned up too, got %q", msg)
+	}
+
+	if out, code := tr.runCLIExitCode("-n", "2", "-cleanup", "comments", "-yes"); code != 2 || !strings.Contains(out, "invalid -cleanup") {
+		t.Errorf("expected a usage error for an unknown mode, got exit %d: %s", code, out)
+	}
+	out := tr.runCLIFailure("-n", "2", "-m", "# only a comment", "-cleanup", "strip", "-yes")
+	if !strings.Contains(out, "the commit message is empty") {
+		t.Errorf("expected a message emptied by -cleanup strip to be rejected, got: %s", out)
+	}
+	out = tr.runCLIFailure("-n", "2", "-m", "#42 fix the parser\n\nBody line", "-cleanup", "strip", "-yes")
+	if !strings.Contains(out, `would remove the subject line "#42 fix the parser"`) {
+		t.Errorf("expected -cleanup strip to refuse dropping the subject, got: %s", out)
+	}
+	tr.runCLISuccess("-n", "2", "-m", "#42 fix the parser\n\nBody line", "-yes")
+	if msg := tr.lastCommitMessage(); msg != "#42 fix the parser" {
+		t.Errorf("expected the default cleanup to keep a subject starting with '#', got %q", msg)
+	}
+}
+
+// TestCLI_EditHonorsCleanupMode tests that -edit keeps lines starting with '#' for the modes that
+// keep them and drops only the instructions below the scissors line
+func TestCLI_EditHonorsCleanupMode(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("editor script requires a POSIX shell")
+	}
+	tr := newTestRepo(t)
+	tr.createCommitsWithMessages("base", "one", "two", "three", "four")
+	// Replace the message above the scissors line, keeping the line and everything below it
+	editor := tr.writeScript("editor.sh", `{ printf '#42 edited\n\n\n# keep me\n\n'; sed -n '/^# -* >8 -*$/,$p' "$1"; } > "$1.new" && mv "$1.new" "$1"`)
+	env := []string{"EDITOR=" + editor}
+
+	if out, err := tr.runCLIWithEnv(env, "-n", "2", "-edit", "-cleanup", "verbatim", "-yes"); err != nil {
+		t.Fatalf("CLI failed unexpectedly: %v\nOutput: %s", err, out)
+	}
+	if msg := tr.git(t.Context(), "cat-file", "commit", "HEAD"); !strings.HasSuffix(msg, "\n\n#42 edited\n\n\n# keep me") {
+		t.Errorf("expected -cleanup verbatim to keep the edited message as saved, got %q", msg)
+	}
+
+	if out, err := tr.runCLIWithEnv(env, "-n", "2", "-edit", "-cleanup", "whitespace", "-yes"); err != nil {
+		t.Fatalf("CLI failed unexpectedly: %v\nOutput: %s", err, out)
+	}
+	if msg := tr.git(t.Context(), "log", "-1", "--format=%B"); msg != "#42 edited\n\n# keep me" {
+		t.Errorf("expected -cleanup whitespace to keep the '#' lines, got %q", msg)
+	}
+}
+
+// TestCLI_WrapWrapsTheBodyOnly tests that -wrap wraps long concatenated bodies but leaves the subject
+// and the appended trailers alone
+func TestCLI_WrapWrapsTheBodyOnly(t *testing.T) {
+	tr := newTestRepo(t)
+	tr.createCommitsWithMessages("base",
+		"feat: a subject that is longer than thirty columns\n\nThis body was pasted as one long line without any wrapping at all.",
+		"more\n\nDetails at https://example.com/issues/12345/comments/67890 for reference.")
+
+	tr.runCLISuccess("-n", "2", "-concat-messages", "-wrap", "30", "-s", "-yes")
+
+	want := "feat: a subject that is longer than thirty columns\n\n" +
+		"This body was pasted as one\nlong line without any wrapping\nat all.\n\n" +
+		"more\n\nDetails at\nhttps://example.com/issues/12345/comments/67890\nfor reference.\n\n" +
+		"Signed-off-by: Test User <test@test.local>"
+	if msg := tr.git(t.Context(), "log", "-1", "--format=%B"); msg != want {
+		t.Errorf("expected message %q, got %q", want, msg)
+	}
+}
+
+// TestCLI_FromWithoutToSquashesThroughHead tests that -from alone squashes up to HEAD
+func TestCLI_FromWithoutToSquashesThroughHead(t *testing.T) {
+	tr := newTestRepo(t)
+	tr.createCommitsWithMessages("base", "one", "two")
+
+	tr.runCLISuccess("-from", "HEAD~1", "-m", "squashed", "-yes")
+
+	if count := tr.commitCount(); count != 2 {
+		t.Errorf("expected 2 commits after squash, got %d", count)
+	}
+	if msg := tr.lastCommitMessage(); msg != "squashed" {
+		t.Errorf("expected 'squashed', got %q", msg)
+	}
+}
+
+// TestCLI_FromAfterToFails tests that an inverted range is rejected
+func TestCLI_FromAfterToFails(t *testing.T) {
+	tr := newTestRepo(t)
+	tr.createCommitsWithMessages("base", "one", "two", "three")
+
+	out := tr.runCLIFailure("-from", "HEAD~1", "-to", "HEAD~2", "-yes")
+
+	if !strings.Contains(out, "is not an ancestor of -to") {
+		t.Errorf("expected inverted range error, got: %s", out)
+	}
+}
+
+// TestCLI_FromToDryRunShowsReplay tests that dry-run describes the replay step
+func TestCLI_FromToDryRunShowsReplay(t *testing.T) {
+	tr := newTestRepo(t)
+	tr.createCommitsWithMessages("base", "one", "two", "three")
+
+	out := tr.runCLISuccess("-from", "HEAD~2", "-to", "HEAD~1", "-dry-run")
+
+	if !strings.Contains(out, "git rebase --onto") {
+		t.Errorf("expected rebase step in dry-run output, got: %s", out)
+	}
+}
+
+// TestCLI_ConcatMessages tests that -concat-messages joins all messages oldest first
+func TestCLI_ConcatMessages(t *testing.T) {
+	tr := newTestRepo(t)
+	tr.createCommitsWithMessages("base", "first\n\nfirst body   ", "second", "third")
+
+	tr.runCLISuccess("-n", "3", "-concat-messages", "-yes")
+
+	msg := tr.git(t.Context(), "log", "-1", "--format=%B")
+	expected := "first\n\nfirst body\n\nsecond\n\nthird"
+	if msg != expected {
+		t.Errorf("expected concatenated message %q, got %q", expected, msg)
+	}
+}
+
+// TestCLI_ConcatMessagesWithHeader tests that -m is prepended as a header with -concat-messages
+func TestCLI_ConcatMessagesWithHeader(t *testing.T) {
+	tr := newTestRepo(t)
+	tr.createCommitsWithMessages("base", "one", "two")
+
+	tr.runCLISuccess("-n", "2", "-m", "feature", "-concat-messages", "-yes")
+
+	msg := tr.git(t.Context(), "log", "-1", "--format=%B")
+	expected := "feature\n\none\n\ntwo"
+	if msg != expected {
+		t.Errorf("expected message %q, got %q", expected, msg)
+	}
+}
+
+// TestCLI_CollectCoauthors tests that -collect-coauthors keeps every co-author once, before the sign-off
+func TestCLI_CollectCoauthors(t *testing.T) {
+	tr := newTestRepo(t)
+	tr.createCommitsWithMessages("base",
+		"one\n\nCo-authored-by: Ann <ann@example.com>",
+		"two\n\nCo-authored-by: Bob <bob@example.com>\nCo-authored-by: Ann <ann@example.com>",
+		"three\n\nCo-authored-by: Annie <ANN@example.com>")
+
+	tr.runCLISuccess("-n", "3", "-m", "feature", "-collect-coauthors", "-s", "-yes")
+
+	msg := tr.git(t.Context(), "log", "-1", "--format=%B")
+	expected := "feature\n\nCo-authored-by: Ann <ann@example.com>\nCo-authored-by: Bob <bob@example.com>\nSigned-off-by: Test User <test@test.local>"
+	if msg != expected {
+		t.Errorf("expected message %q, got %q", expected, msg)
+	}
+
+	// With -concat-messages the co-authors are already in the joined bodies and are not repeated
+	tr.createCommitsWithMessages("four\n\nCo-authored-by: Ann <ann@example.com>", "five\n\nCo-authored-by: Cy <cy@example.com>")
+	tr.runCLISuccess("-n", "2", "-concat-messages", "-collect-coauthors", "-yes")
+
+	msg = tr.git(t.Context(), "log", "-1", "--format=%B")
+	expected = "four\n\nCo-authored-by: Ann <ann@example.com>\n\nfive\n\nCo-authored-by: Cy <cy@example.com>"
+	if msg != expected {
+		t.Errorf("expected the concatenated co-authors once each, got %q", msg)
+	}
+}
+
+// TestCLI_EditMessage tests that -edit uses the message saved by the editor, without comment lines
+func TestCLI_EditMessage(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("editor script requires a POSIX shell")
+	}
+	tr := newTestRepo(t)
+	tr.createCommitsWithMessages("base", "one", "two")
+
+	editor := tr.writeScript("editor.sh", `printf 'edited message\n# ignored comment\n' > "$1"`)
+
+	out, err := tr.runCLIWithEnv([]string{"EDITOR=" + editor}, "-n", "2", "-edit", "-yes")
+	if err != nil {
+		t.Fatalf("CLI failed unexpectedly: %v\nOutput: %s", err, out)
+	}
+
+	if msg := tr.git(t.Context(), "log", "-1", "--format=%B"); msg != "edited message" {
+		t.Errorf("expected edited message, got %q", msg)
+	}
+}
+
+// TestCLI_EditorPathWithSpaces tests that a quoted editor path containing spaces is run through
+// the shell with its arguments, as git does
+func TestCLI_EditorPathWithSpaces(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("editor script requires a POSIX shell")
+	}
+	tr := newTestRepo(t)
+	tr.createCommitsWithMessages("base", "one", "two")
+
+	editor := tr.writeScript("my editor.sh", `[ "$1" = --wait ] && printf 'edited message\n' > "$2"`)
+
+	out, err := tr.runCLIWithEnv([]string{`EDITOR="` + editor + `" --wait`}, "-n", "2", "-edit", "-yes")
+	if err != nil {
+		t.Fatalf("CLI failed unexpectedly: %v\nOutput: %s", err, out)
+	}
+	if msg := tr.git(t.Context(), "log", "-1", "--format=%B"); msg != "edited message" {
+		t.Errorf("expected edited message, got %q", msg)
+	}
+}
+
+// TestCLI_StripCommentsCleansConcatenatedMessages tests that -strip-comments drops template comment
+// lines and repeated blank lines from concatenated messages, and that -edit opens the cleaned text
+func TestCLI_StripCommentsCleansConcatenatedMessages(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("editor script requires a POSIX shell")
+	}
+	tr := newTestRepo(t)
+	// git commit -m already collapses blank lines, so the runs come from removed comment lines
+	tr.createCommitsWithMessages("base", "one\n\n# Why:\n\nfirst reason\n\nmore", "two\n\n# Refs:\n\nsecond reason\n\n#")
+	want := "one\n\nfirst reason\n\nmore\n\ntwo\n\nsecond reason"
+
+	out := tr.runCLISuccess("-n", "2", "-concat-messages", "-strip-comments", "-dry-run")
+	if !strings.Contains(out, strconv.Quote(want)) {
+		t.Errorf("expected the cleaned message in the dry run, got: %s", out)
+	}
+
+	seen := filepath.Join(t.TempDir(), "seen.txt")
+	editor := tr.writeScript("editor.sh", `cp "$1" `+seen)
+	out, err := tr.runCLIWithEnv([]string{"EDITOR=" + editor}, "-n", "2", "-concat-messages", "-strip-comments", "-edit", "-yes")
+	if err != nil {
+		t.Fatalf("CLI failed unexpectedly: %v\nOutput: %s", err, out)
+	}
+	if data, rErr := os.ReadFile(seen); rErr != nil || !strings.HasPrefix(string(data), want+"\n\n# Please enter") {
+		t.Errorf("expected the editor to open the cleaned message, got %q (%v)", data, rErr)
+	}
+	if msg := tr.git(t.Context(), "log", "-1", "--format=%B"); msg != want {
+		t.Errorf("expected message %q, got %q", want, msg)
+	}
+}
+
+// TestCLI_CommitNeverOpensGitEditor tests that the commit steps never hand control to git's
+// editor, which would hang in CI, even with -edit or when the range is replayed
+func TestCLI_CommitNeverOpensGitEditor(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("editor script requires a POSIX shell")
+	}
+	tr := newTestRepo(t)
+	tr.createCommitsWithMessages("base", "one", "two", "three", "four", "five")
+
+	marker := filepath.Join(t.TempDir(), "git-editor-ran")
+	gitEditor := tr.writeScript("git-editor.sh", "touch "+marker+"\nexit 1")
+	tr.git(t.Context(), "config", "core.editor", gitEditor)
+	editor := tr.writeScript("editor.sh", `printf 'edited message\n' > "$1"`)
+	env := []string{"GIT_EDITOR=" + gitEditor, "EDITOR=" + editor}
+
+	for _, args := range [][]string{
+		{"-n", "2", "-yes"},
+		{"-n", "2", "-yes", "-edit"},
+		{"-from", "HEAD~2", "-to", "HEAD~1", "-yes"},
+	} {
+		if out, err := tr.runCLIWithEnv(env, args...); err != nil {
+			t.Fatalf("%v: CLI failed unexpectedly: %v\nOutput: %s", args, err, out)
+		}
+	}
+	if _, err := os.Stat(marker); err == nil {
+		t.Error("expected git's editor never to be started")
+	}
+}
+
+// TestCLI_EditEmptyMessageAborts tests that an empty edited message aborts without rewriting history
+func TestCLI_EditEmptyMessageAborts(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("editor script requires a POSIX shell")
+	}
+	tr := newTestRepo(t)
+	tr.createCommitsWithMessages("base", "one", "two")
+
+	headBefore := tr.git(t.Context(), "rev-parse", "HEAD")
+	editor := tr.writeScript("editor.sh", `printf '# only a comment\n\n' > "$1"`)
+
+	out, err := tr.runCLIWithEnv([]string{"EDITOR=" + editor}, "-n", "2", "-edit", "-yes")
+	if err == nil {
+		t.Fatalf("expected failure with empty message, got success\nOutput: %s", out)
+	}
+	if !strings.Contains(out, "empty commit message") {
+		t.Errorf("expected empty message error, got: %s", out)
+	}
+	if headAfter := tr.git(t.Context(), "rev-parse", "HEAD"); headAfter != headBefore {
+		t.Errorf("HEAD changed after aborted edit: before=%s, after=%s", headBefore, headAfter)
+	}
+}
+
+// jsonSummary mirrors the JSON emitted by -json
+type jsonSummary struct {
+	DryRun       bool   `json:"dry_run"`
+	SquashCount  int    `json:"squash_count"`
+	BackupBranch string `json:"backup_branch"`
+	ResultCommit string `json:"result_commit"`
+	Stats        *struct {
+		FilesChanged int `json:"files_changed"`
+		Insertions   int `json:"insertions"`
+		Deletions    int `json:"deletions"`
+	} `json:"stats"`
+	ResetRef      string `json:"reset_ref"`
+	CommitMessage string `json:"commit_message"`
+	RecentDate    string `json:"recent_date"`
+	Commits       []struct {
+		Hash      string `json:"hash"`
+		Subject   string `json:"subject"`
+		Additions int    `json:"additions"`
+		Deletions int    `json:"deletions"`
+	} `json:"commits"`
+}
+
+// TestCLI_JSONDryRun tests that -json -dry-run prints only JSON on stdout
+func TestCLI_JSONDryRun(t *testing.T) {
+	tr := newTestRepo(t)
+	tr.createCommitsWithMessages("base", "one", "two")
+
+	stdout, stderr, err := tr.runCLISplit("-n", "2", "-m", "squashed", "-dry-run", "-json")
+	if err != nil {
+		t.Fatalf("CLI failed unexpectedly: %v\nstderr: %s", err, stderr)
+	}
+
+	var summary jsonSummary
+	if err = json.Unmarshal([]byte(stdout), &summary); err != nil {
+		t.Fatalf("stdout is not valid JSON: %v\n%s", err, stdout)
+	}
+	if !summary.DryRun || summary.SquashCount != 2 || summary.ResetRef != "HEAD~2" || summary.CommitMessage != "squashed" || summary.ResultCommit != "" {
+		t.Errorf("unexpected summary: %+v", summary)
+	}
+	if len(summary.Commits) != 2 || summary.Commits[0].Subject != "two" {
+		t.Errorf("unexpected commits in summary: %+v", summary.Commits)
+	}
+}
+
+// TestCLI_JSONResult tests that -json prints a result summary with the backup branch
+func TestCLI_JSONResult(t *testing.T) {
+	tr := newTestRepo(t)
+	tr.createCommitsWithMessages("base", "one", "two")
+
+	stdout, stderr, err := tr.runCLISplit("-n", "2", "-m", "squashed", "-yes", "-json")
+	if err != nil {
+		t.Fatalf("CLI failed unexpectedly: %v\nstderr: %s", err, stderr)
+	}
+
+	var summary jsonSummary
+	if err = json.Unmarshal([]byte(stdout), &summary); err != nil {
+		t.Fatalf("stdout is not valid JSON: %v\n%s", err, stdout)
+	}
+	if summary.DryRun {
+		t.Errorf("expected dry_run=false in result summary")
+	}
+	if !strings.HasPrefix(summary.BackupBranch, "locsquash/backup-") {
+		t.Errorf("expected backup branch in summary, got %q", summary.BackupBranch)
+	}
+	if head := tr.git(t.Context(), "rev-parse", "HEAD"); summary.ResultCommit != head {
+		t.Errorf("expected result_commit %s, got %q", head, summary.ResultCommit)
+	}
+	if !strings.Contains(stderr, "Creating squashed commit") {
+		t.Errorf("expected progress on stderr, got: %s", stderr)
+	}
+}
+
+// TestCLI_ColorAlwaysForcesColor tests that -color=always emits ANSI codes when not on a terminal
+func TestCLI_ColorAlwaysForcesColor(t *testing.T) {
+	tr := newTestRepo(t)
+	tr.createCommitsWithMessages("a", "b", "c")
+
+	out := tr.runCLISuccess("-n", "2", "-dry-run", "-color=always")
+
+	if !strings.Contains(out, "\033[") {
+		t.Errorf("expected ANSI codes with -color=always, got: %q", out)
+	}
+}
+
+// TestCLI_NoColorDisablesColor tests that -no-color and NO_COLOR disable ANSI codes
+func TestCLI_NoColorDisablesColor(t *testing.T) {
+	tr := newTestRepo(t)
+	tr.createCommitsWithMessages("a", "b", "c")
+
+	out := tr.runCLISuccess("-n", "2", "-dry-run", "-color=always", "-no-color")
+	if strings.Contains(out, "\033[") {
+		t.Errorf("expected no ANSI codes with -no-color, got: %q", out)
+	}
+
+	out, err := tr.runCLIWithEnv([]string{"NO_COLOR=1"}, "-n", "2", "-dry-run")
+	if err != nil {
+		t.Fatalf("CLI failed unexpectedly: %v\nOutput: %s", err, out)
+	}
+	if strings.Contains(out, "\033[") {
+		t.Errorf("expected no ANSI codes with NO_COLOR, got: %q", out)
+	}
+}
+
+// TestCLI_InvalidColorValue tests that an unknown -color value is rejected
+func TestCLI_InvalidColorValue(t *testing.T) {
+	tr := newTestRepo(t)
+	tr.createCommitsWithMessages("a", "b", "c")
+
+	out := tr.runCLIFailure("-n", "2", "-dry-run", "-color=sometimes")
+
+	if !strings.Contains(out, "invalid -color value") {
+		t.Errorf("expected invalid color error, got: %s", out)
+	}
+}
+
+// TestCLI_TagBackupCreatesTag tests that -tag-backup creates a tag instead of a branch
+func TestCLI_TagBackupCreatesTag(t *testing.T) {
+	tr := newTestRepo(t)
+	tr.createCommitsWithMessages("a", "b", "c")
+
+	headBefore := tr.git(t.Context(), "rev-parse", "HEAD")
+
+	out := tr.runCLISuccess("-n", "2", "-m", "squashed", "-yes", "-tag-backup")
+
+	if !strings.Contains(out, "Created backup tag") {
+		t.Errorf("expected backup tag message, got: %s", out)
+	}
+	if branches := tr.git(t.Context(), "branch", "-a"); strings.Contains(branches, "locsquash/backup-") {
+		t.Errorf("expected no backup branch with -tag-backup, got: %s", branches)
+	}
+	tag := tr.git(t.Context(), "tag", "--list", "locsquash/backup-*")
+	if tag == "" {
+		t.Fatal("expected backup tag to be created")
+	}
+	if target := tr.git(t.Context(), "rev-parse", tag+"^{commit}"); target != headBefore {
+		t.Errorf("backup tag points to %s, expected %s", target, headBefore)
+	}
+}
+
+// TestCLI_TagBackupRecoveryInstructions tests that recovery instructions reference the tag
+func TestCLI_TagBackupRecoveryInstructions(t *testing.T) {
+	tr := newTestRepo(t)
+	tr.createCommitsWithMessages("a", "b", "c")
+
+	out := tr.runCLISuccess("-n", "2", "-print-recovery", "-tag-backup")
+
+	if !strings.Contains(out, "git tag -d locsquash/backup-") {
+		t.Errorf("expected tag cleanup command in recovery output, got: %s", out)
+	}
+}
+
+// TestCLI_ListBackupsIncludesTagsAndDates tests that -list-backups shows tags and creation dates
+func TestCLI_ListBackupsIncludesTagsAndDates(t *testing.T) {
+	tr := newTestRepo(t)
+	tr.createCommitsWithMessages("a", "b", "c")
+	tr.git(t.Context(), "tag", "locsquash/backup-20200102-030405")
+
+	out := tr.runCLISuccess("-list-backups")
+
+	if !strings.Contains(out, "locsquash/backup-20200102-030405 [tag]") {
+		t.Errorf("expected backup tag in output, got: %s", out)
+	}
+	if !strings.Contains(out, "2020-01-0") {
+		t.Errorf("expected creation date in output, got: %s", out)
+	}
+	if !strings.Contains(out, "Found 1 backup tag") {
+		t.Errorf("expected 'Found 1 backup tag', got: %s", out)
+	}
+}
+
+// TestCLI_PruneBackupsDeletesOldOnly tests that -prune-backups keeps recent backups
+func TestCLI_PruneBackupsDeletesOldOnly(t *testing.T) {
+	tr := newTestRepo(t)
+	tr.createCommitsWithMessages("a", "b", "c", "d")
+	tr.git(t.Context(), "branch", "locsquash/backup-20200101-000000")
+	tr.git(t.Context(), "tag", "locsquash/backup-20200101-000001")
+
+	// Creates a fresh backup branch
+	tr.runCLISuccess("-n", "2", "-m", "squashed", "-yes")
+
+	out := tr.runCLISuccess("-prune-backups", "7d", "-yes")
+
+	if !strings.Contains(out, "Deleted locsquash/backup-20200101-000000") {
+		t.Errorf("expected old branch to be deleted, got: %s", out)
+	}
+	if tags := tr.git(t.Context(), "tag", "--list", "locsquash/backup-*"); tags != "" {
+		t.Errorf("expected old tag to be deleted, remaining: %s", tags)
+	}
+	branches := tr.git(t.Context(), "branch", "--list", "locsquash/backup-*")
+	if strings.Contains(branches, "20200101") || !strings.Contains(branches, "locsquash/backup-") {
+		t.Errorf("expected only the recent backup to remain, got: %s", branches)
+	}
+}
+
+// TestCLI_PruneBackupsDryRun tests that -prune-backups with -dry-run deletes nothing
+func TestCLI_PruneBackupsDryRun(t *testing.T) {
+	tr := newTestRepo(t)
+	tr.createCommitsWithMessages("a", "b")
+	tr.git(t.Context(), "branch", "locsquash/backup-20200101-000000")
+
+	out := tr.runCLISuccess("-prune-backups", "1d", "-dry-run")
+
+	if !strings.Contains(out, "No backups were deleted") {
+		t.Errorf("expected dry-run notice, got: %s", out)
+	}
+	if branches := tr.git(t.Context(), "branch", "--list", "locsquash/backup-*"); branches == "" {
+		t.Errorf("expected backup branch to remain after dry run")
+	}
+}
+
+// TestCLI_PruneBackupsInvalidAge tests that a malformed age is rejected
+func TestCLI_PruneBackupsInvalidAge(t *testing.T) {
+	tr := newTestRepo(t)
+	tr.createCommitsWithMessages("a", "b")
+
+	out := tr.runCLIFailure("-prune-backups", "soon")
+
+	if !strings.Contains(out, "not a valid age") {
+		t.Errorf("expected invalid age error, got: %s", out)
+	}
+}
+
+// TestCLI_AuthorOverride tests that -author sets the squashed commit's author
+func TestCLI_AuthorOverride(t *testing.T) {
+	tr := newTestRepo(t)
+	tr.createCommitsWithMessages("a", "b", "c")
+
+	tr.runCLISuccess("-n", "2", "-m", "squashed", "-author", "Jane Doe <jane@example.com>", "-yes")
+
+	if author := tr.git(t.Context(), "log", "-1", "--format=%an <%ae>"); author != "Jane Doe <jane@example.com>" {
+		t.Errorf("expected author override, got %q", author)
+	}
+}
+
+// TestCLI_AuthorMalformed tests that a malformed -author fails before rewriting history
+func TestCLI_AuthorMalformed(t *testing.T) {
+	tr := newTestRepo(t)
+	tr.createCommitsWithMessages("a", "b", "c")
+
+	headBefore := tr.git(t.Context(), "rev-parse", "HEAD")
+
+	out := tr.runCLIFailure("-n", "2", "-author", "Jane Doe", "-yes")
+
+	if !strings.Contains(out, "invalid -author") {
+		t.Errorf("expected invalid author error, got: %s", out)
+	}
+	if headAfter := tr.git(t.Context(), "rev-parse", "HEAD"); headAfter != headBefore {
+		t.Errorf("HEAD changed after rejected author: before=%s, after=%s", headBefore, headAfter)
+	}
+}
+
+// TestCLI_KeepsOldestAuthorByDefault tests that the squashed commit keeps the oldest commit's author
+func TestCLI_KeepsOldestAuthorByDefault(t *testing.T) {
+	tr := newTestRepo(t)
+	tr.createCommitsWithMessages("base")
+	tr.createCommitAs("Alice <alice@example.com>", "alice work")
+	tr.createCommitAs("Bob <bob@example.com>", "bob work")
+
+	tr.runCLISuccess("-n", "2", "-m", "squashed", "-yes")
+
+	if author := tr.git(t.Context(), "log", "-1", "--format=%an <%ae>"); author != "Alice <alice@example.com>" {
+		t.Errorf("expected oldest author to be preserved, got %q", author)
+	}
+}
+
+// TestCLI_KeepAuthorFalseUsesConfig tests that -keep-author=false attributes the commit to the git config user
+func TestCLI_KeepAuthorFalseUsesConfig(t *testing.T) {
+	tr := newTestRepo(t)
+	tr.createCommitsWithMessages("base")
+	tr.createCommitAs("Alice <alice@example.com>", "alice work")
+	tr.createCommitAs("Bob <bob@example.com>", "bob work")
+
+	tr.runCLISuccess("-n", "2", "-m", "squashed", "-keep-author=false", "-yes")
+
+	if author := tr.git(t.Context(), "log", "-1", "--format=%an <%ae>"); author != "Test User <test@test.local>" {
+		t.Errorf("expected git config author, got %q", author)
+	}
+}
+
+// TestCLI_KeepCommitter tests that -keep-committer takes the committer from the newest commit instead of the git config
+func TestCLI_KeepCommitter(t *testing.T) {
+	tr := newTestRepo(t)
+	tr.createCommitsWithMessages("base")
+	tr.createCommitAs("Alice <alice@example.com>", "alice work")
+	tr.git(t.Context(), "-c", "user.name=Carol", "-c", "user.email=carol@example.com", "commit", "--allow-empty", "-m", "carol work")
+
+	tr.runCLISuccess("-n", "2", "-m", "squashed", "-yes")
+	if committer := tr.git(t.Context(), "log", "-1", "--format=%cn <%ce>"); committer != "Test User <test@test.local>" {
+		t.Errorf("expected the git config committer by default, got %q", committer)
+	}
+
+	tr.runCLISuccess("-recover-last", "-yes")
+	tr.runCLISuccess("-n", "2", "-m", "squashed", "-keep-committer", "-yes")
+	if committer := tr.git(t.Context(), "log", "-1", "--format=%cn <%ce>"); committer != "Carol <carol@example.com>" {
+		t.Errorf("expected the newest commit's committer, got %q", committer)
+	}
+	if author := tr.git(t.Context(), "log", "-1", "--format=%an <%ae>"); author != "Alice <alice@example.com>" {
+		t.Errorf("expected the oldest author to be kept alongside, got %q", author)
+	}
+}
+
+// TestCLI_PreservesAuthorAndCommitterDatesSeparately tests that differing author and committer dates both survive
+func TestCLI_PreservesAuthorAndCommitterDatesSeparately(t *testing.T) {
+	tr := newTestRepo(t)
+	tr.createCommitsWithMessages("base", "one")
+	tr.writeFile("dated.txt", "dated")
+	tr.git(t.Context(), "add", "dated.txt")
+	tr.git(t.Context(), "commit", "--date", "2020-05-06T07:08:09+02:00", "-m", "dated")
+
+	authorBefore := tr.git(t.Context(), "log", "-1", "--format=%aI")
+	committerBefore := tr.git(t.Context(), "log", "-1", "--format=%cI")
+	if authorBefore == committerBefore {
+		t.Fatalf("test setup expected differing dates, both are %s", authorBefore)
+	}
+
+	tr.runCLISuccess("-n", "2", "-m", "squashed", "-yes")
+
+	if authorAfter := tr.git(t.Context(), "log", "-1", "--format=%aI"); authorAfter != authorBefore {
+		t.Errorf("author date changed: before=%s, after=%s", authorBefore, authorAfter)
+	}
+	if committerAfter := tr.git(t.Context(), "log", "-1", "--format=%cI"); committerAfter != committerBefore {
+		t.Errorf("committer date changed: before=%s, after=%s", committerBefore, committerAfter)
+	}
+}
+
+// TestCLI_DateOverride tests that -date sets both author and committer dates
+func TestCLI_DateOverride(t *testing.T) {
+	tr := newTestRepo(t)
+	tr.createCommitsWithMessages("a", "b", "c")
+
+	tr.runCLISuccess("-n", "2", "-m", "squashed", "-date", "2021-02-03T04:05:06Z", "-yes")
+
+	dates := tr.git(t.Context(), "log", "-1", "--format=%aI %cI")
+	if dates != "2021-02-03T04:05:06Z 2021-02-03T04:05:06Z" && dates != "2021-02-03T04:05:06+00:00 2021-02-03T04:05:06+00:00" {
+		t.Errorf("expected overridden dates, got %q", dates)
+	}
+}
+
+// TestCLI_DateOverrideInDryRun tests that the dry-run commit command shows the overridden date
+func TestCLI_DateOverrideInDryRun(t *testing.T) {
+	tr := newTestRepo(t)
+	tr.createCommitsWithMessages("a", "b", "c")
+
+	out := tr.runCLISuccess("-n", "2", "-date", "2021-02-03T04:05:06Z", "-dry-run")
+
+	if !strings.Contains(out, "GIT_COMMITTER_DATE=2021-02-03T04:05:06Z") {
+		t.Errorf("expected overridden date in dry-run output, got: %s", out)
+	}
+}
+
+// TestCLI_DateInvalid tests that a malformed -date fails fast
+func TestCLI_DateInvalid(t *testing.T) {
+	tr := newTestRepo(t)
+	tr.createCommitsWithMessages("a", "b", "c")
+
+	out := tr.runCLIFailure("-n", "2", "-date", "yesterday", "-yes")
+
+	if !strings.Contains(out, "invalid -date") {
+		t.Errorf("expected invalid date error, got: %s", out)
+	}
+}
+
+// TestCLI_SignInDryRun tests that signing flags appear in the dry-run commit command
+func TestCLI_SignInDryRun(t *testing.T) {
+	tr := newTestRepo(t)
+	tr.createCommitsWithMessages("a", "b", "c")
+
+	out := tr.runCLISuccess("-n", "2", "-sign", "-dry-run")
+	if !strings.Contains(out, " --gpg-sign -m ") {
+		t.Errorf("expected --gpg-sign in dry-run output, got: %s", out)
+	}
+
+	out = tr.runCLISuccess("-n", "2", "-S", "ABCDEF12", "-dry-run")
+	if !strings.Contains(out, "--gpg-sign=ABCDEF12") {
+		t.Errorf("expected --gpg-sign=ABCDEF12 in dry-run output, got: %s", out)
+	}
+}
+
+// TestCLI_SignVerifiesWithVerbose tests that a signed squash keeps the newest commit's dates, verifies
+// under -verbose, and that amending a signed base commit without -sign warns about the lost signature
+func TestCLI_SignVerifiesWithVerbose(t *testing.T) {
+	if _, err := exec.LookPath("gpg"); err != nil {
+		t.Skip("gpg is not installed")
+	}
+	// gpg-agent sockets live in GNUPGHOME, whose path must stay short
+	home, err := os.MkdirTemp("", "gpg")
+	if err != nil {
+		t.Fatalf("failed to create GNUPGHOME: %v", err)
+	}
+	env := []string{"GNUPGHOME=" + home}
+	t.Cleanup(func() {
+		kill := exec.Command("gpgconf", "--kill", "gpg-agent")
+		kill.Env = append(os.Environ(), env...)
+		_ = kill.Run()
+		_ = os.RemoveAll(home)
+	})
+	gen := exec.CommandContext(t.Context(), "gpg", "--batch", "--passphrase", "", "--quick-gen-key", "Test User <test@test.local>", "default", "default", "never")
+	gen.Env = append(os.Environ(), env...)
+	if out, gErr := gen.CombinedOutput(); gErr != nil {
+		t.Skipf("cannot generate a gpg key: %v\n%s", gErr, out)
+	}
+
+	tr := newTestRepo(t)
+	tr.createCommitsWithMessages("a", "b", "c")
+	newestDate := tr.git(t.Context(), "log", "-1", "--format=%cI")
+
+	out, err := tr.runCLIWithEnv(env, "-n", "2", "-m", "squashed", "-sign", "-verbose", "-yes")
+	if err != nil {
+		t.Fatalf("signed squash failed: %v\n%s", err, out)
+	}
+	if !strings.Contains(out, "Verified the GPG signature of") {
+		t.Errorf("expected -verbose to verify the signature, got: %s", out)
+	}
+	verify := exec.CommandContext(t.Context(), "git", "verify-commit", "HEAD")
+	verify.Dir, verify.Env = tr.Dir, append(os.Environ(), env...)
+	if vOut, vErr := verify.CombinedOutput(); vErr != nil {
+		t.Errorf("expected HEAD to carry a valid signature: %v\n%s", vErr, vOut)
+	}
+	if date := tr.git(t.Context(), "log", "-1", "--format=%cI"); date != newestDate {
+		t.Errorf("expected the signed commit to keep committer date %s, got %s", newestDate, date)
+	}
+
+	tr.createCommit("d")
+	out, err = tr.runCLIWithEnv(env, "-amend-into-base", "-n", "1", "-yes")
+	if err != nil {
+		t.Fatalf("amend into base failed: %v\n%s", err, out)
+	}
+	if !strings.Contains(out, "is GPG-signed, and amending it drops the signature") {
+		t.Errorf("expected a warning about the dropped signature, got: %s", out)
+	}
+}
+
+// TestCLI_SignWarnsWhenSignatureDoesNotVerify tests that -verbose warns about a signature that git
+// accepted when signing but that does not verify
+func TestCLI_SignWarnsWhenSignatureDoesNotVerify(t *testing.T) {
+	tr := newTestRepo(t)
+	tr.createCommitsWithMessages("a", "b", "c")
+	// Signs with a bogus signature and fails every verification
+	fakeGPG := tr.writeScript("fake-gpg", `case "$*" in
+*-bsau*)
+	cat >/dev/null
+	echo "[GNUPG:] SIG_CREATED D 1 8 00 0 0" >&2
+	printf -- '-----BEGIN PGP SIGNATURE-----\n\nbogus\n-----END PGP SIGNATURE-----\n'
+	;;
+*) exit 1 ;;
+esac
+`)
+	tr.git(t.Context(), "config", "gpg.program", fakeGPG)
+
+	out := tr.runCLISuccess("-n", "2", "-m", "squashed", "-sign", "-verbose", "-yes")
+	if !strings.Contains(out, "does not verify") {
+		t.Errorf("expected a warning that the signature does not verify, got: %s", out)
+	}
+
+	tr.createCommit("d")
+	out = tr.runCLISuccess("-n", "2", "-m", "quiet", "-sign", "-yes")
+	if strings.Contains(out, "verify") {
+		t.Errorf("expected no verification without -verbose, got: %s", out)
+	}
+}
+
+// TestCLI_SignFailureShowsRecoveryHint tests that a signing failure points at the backup branch
+func TestCLI_SignFailureShowsRecoveryHint(t *testing.T) {
+	tr := newTestRepo(t)
+	tr.createCommitsWithMessages("a", "b", "c")
+	tr.git(t.Context(), "config", "gpg.program", "false")
+
+	out := tr.runCLIFailure("-n", "2", "-m", "squashed", "-sign", "-yes", "-no-auto-recover")
+
+	if !strings.Contains(out, "Failed to create squashed commit") || !strings.Contains(out, "Recovery: git reset --hard locsquash/backup-") {
+		t.Errorf("expected commit failure with recovery hint, got: %s", out)
+	}
+}
+
+// TestCLI_NoVerifySkipsHooks tests that -no-verify bypasses a failing pre-commit hook
+func TestCLI_NoVerifySkipsHooks(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("hook script requires a POSIX shell")
+	}
+	tr := newTestRepo(t)
+	tr.createCommitsWithMessages("a", "b", "c")
+	tr.writeHook("pre-commit", "exit 1\n")
+
+	out := tr.runCLIFailure("-n", "2", "-m", "squashed", "-yes")
+	if !strings.Contains(out, "Failed to create squashed commit") {
+		t.Errorf("expected hook failure without -no-verify, got: %s", out)
+	}
+
+	tr.runCLISuccess("-n", "2", "-m", "squashed", "-no-verify", "-yes")
+	if msg := tr.lastCommitMessage(); msg != "squashed" {
+		t.Errorf("expected squashed commit with -no-verify, got %q", msg)
+	}
+}
+
+// TestCLI_AutoRecoverOnFailure tests that a failed commit restores the original history and stashed changes
+func TestCLI_AutoRecoverOnFailure(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("hook script requires a POSIX shell")
+	}
+	tr := newTestRepo(t)
+	tr.createCommitsWithMessages("a", "b", "c")
+	tr.writeHook("pre-commit", "exit 1\n")
+	tr.writeFile("dirty.txt", "uncommitted")
+	head := tr.git(t.Context(), "rev-parse", "HEAD")
+
+	out := tr.runCLIFailure("-n", "2", "-m", "squashed", "-stash", "-yes")
+	if !strings.Contains(out, "Failed to create squashed commit") || !strings.Contains(out, "restored to locsquash/backup-") {
+		t.Errorf("expected failure with automatic recovery, got: %s", out)
+	}
+	if got := tr.git(t.Context(), "rev-parse", "HEAD"); got != head {
+		t.Errorf("expected HEAD to be restored to %s, got %s", head, got)
+	}
+	if status := tr.git(t.Context(), "status", "--porcelain"); !strings.Contains(status, "dirty.txt") {
+		t.Errorf("expected stashed changes to be reapplied, got status: %q", status)
+	}
+	if stashes := tr.git(t.Context(), "stash", "list"); stashes != "" {
+		t.Errorf("expected no leftover stash, got: %s", stashes)
+	}
+}
+
+// TestCLI_DeclinedPromptKeepsWorkingTree tests that a refused prompt with -stash leaves the working tree as it was.
+// The test binary's stdin is the null device, which is not a terminal, so the prompt is refused.
+func TestCLI_DeclinedPromptKeepsWorkingTree(t *testing.T) {
+	tr := newTestRepo(t)
+	tr.createCommitsWithMessages("a", "b", "c")
+	tr.writeFile("dirty.txt", "uncommitted")
+	before := tr.git(t.Context(), "status", "--porcelain")
+	head := tr.git(t.Context(), "rev-parse", "HEAD")
+
+	out := tr.runCLIFailure("-n", "2", "-stash")
+	if !strings.Contains(out, "stdin is not a terminal") {
+		t.Errorf("expected the prompt to be refused, got: %s", out)
+	}
+	if got := tr.git(t.Context(), "rev-parse", "HEAD"); got != head {
+		t.Errorf("expected HEAD to be unchanged, got %s", got)
+	}
+	if after := tr.git(t.Context(), "status", "--porcelain"); after != before {
+		t.Errorf("expected working tree to be unchanged, got %q, want %q", after, before)
+	}
+	if stashes := tr.git(t.Context(), "stash", "list"); stashes != "" {
+		t.Errorf("expected no stash to be created, got: %s", stashes)
+	}
+}
+
+// TestCLI_NoAutoRecoverLeavesRepository tests that -no-auto-recover leaves the failed state for manual recovery
+func TestCLI_NoAutoRecoverLeavesRepository(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("hook script requires a POSIX shell")
+	}
+	tr := newTestRepo(t)
+	tr.createCommitsWithMessages("a", "b", "c")
+	tr.writeHook("pre-commit", "exit 1\n")
+	head := tr.git(t.Context(), "rev-parse", "HEAD")
+
+	out := tr.runCLIFailure("-n", "2", "-m", "squashed", "-yes", "-no-auto-recover")
+	if !strings.Contains(out, "Recovery: git reset --hard locsquash/backup-") {
+		t.Errorf("expected recovery hint, got: %s", out)
+	}
+	if got := tr.git(t.Context(), "rev-parse", "HEAD"); got == head {
+		t.Error("expected HEAD to stay at the soft-reset commit")
+	}
+}
+
+// TestCLI_KeepBackupOnSuccessFalseDeletesBackup tests that the backup is removed after a successful squash
+func TestCLI_KeepBackupOnSuccessFalseDeletesBackup(t *testing.T) {
+	tr := newTestRepo(t)
+	tr.createCommitsWithMessages("a", "b", "c")
+
+	out := tr.runCLISuccess("-n", "2", "-m", "squashed", "-yes", "-keep-backup-on-success=false")
+	if !strings.Contains(out, "no backup remains") {
+		t.Errorf("expected note that no backup remains, got: %s", out)
+	}
+	if refs := tr.git(t.Context(), "for-each-ref", "refs/heads/locsquash/", "refs/tags/locsquash/"); refs != "" {
+		t.Errorf("expected backup to be deleted, got: %s", refs)
+	}
+	if msg := tr.lastCommitMessage(); msg != "squashed" {
+		t.Errorf("expected squashed commit, got %q", msg)
+	}
+}
+
+// TestCLI_KeepBackupOnSuccessFalseInDryRun tests that the dry run shows the backup deletion step
+func TestCLI_KeepBackupOnSuccessFalseInDryRun(t *testing.T) {
+	tr := newTestRepo(t)
+	tr.createCommitsWithMessages("a", "b", "c")
+
+	out := tr.runCLISuccess("-n", "2", "-dry-run", "-tag-backup", "-keep-backup-on-success=false")
+	if !strings.Contains(out, "git tag -d locsquash/backup-") {
+		t.Errorf("expected backup deletion in dry-run output, got: %s", out)
+	}
+}
+
+// TestCLI_BackupPrefix tests that -backup-prefix names the backup and is honored by -list-backups
+func TestCLI_BackupPrefix(t *testing.T) {
+	tr := newTestRepo(t)
+	tr.createCommitsWithMessages("a", "b", "c")
+
+	out := tr.runCLISuccess("-n", "2", "-m", "squashed", "-yes", "-backup-prefix", "team/pre-squash-")
+	if !strings.Contains(out, "Created backup branch: team/pre-squash-") {
+		t.Errorf("expected backup with custom prefix, got: %s", out)
+	}
+
+	out = tr.runCLISuccess("-list-backups", "-backup-prefix", "team/pre-squash-")
+	if !strings.Contains(out, "Found 1 backup branch") {
+		t.Errorf("expected custom-prefixed backup to be listed, got: %s", out)
+	}
+}
+
+// TestCLI_BackupPrefixRejectsInvalidRef tests that a prefix that cannot form a ref name is rejected
+func TestCLI_BackupPrefixRejectsInvalidRef(t *testing.T) {
+	tr := newTestRepo(t)
+	tr.createCommitsWithMessages("a", "b", "c")
+
+	out := tr.runCLIFailure("-n", "2", "-yes", "-backup-prefix", "bad prefix..")
+	if !strings.Contains(out, "invalid -backup-prefix") {
+		t.Errorf("expected invalid prefix error, got: %s", out)
+	}
+}
+
+// TestCLI_SignoffAddsTrailer tests that -signoff appends a single Signed-off-by trailer
+func TestCLI_SignoffAddsTrailer(t *testing.T) {
+	tr := newTestRepo(t)
+	tr.createCommitsWithMessages("a", "b", "c")
+
+	out := tr.runCLISuccess("-n", "2", "-m", "squashed", "-s", "-dry-run")
+	if !strings.Contains(out, "Signed-off-by: Test User <test@test.local>") {
+		t.Errorf("expected sign-off in dry-run output, got: %s", out)
+	}
+
+	tr.runCLISuccess("-n", "2", "-m", "squashed\n\nSigned-off-by: Test User <test@test.local>", "-signoff", "-yes")
+	want := "squashed\n\nSigned-off-by: Test User <test@test.local>"
+	if msg := tr.git(t.Context(), "log", "-1", "--format=%B"); msg != want {
+		t.Errorf("expected a single sign-off, got %q", msg)
+	}
+}
+
+// TestCLI_TrailerFlag tests that -trailer appends trailers ahead of the sign-off and shows them in the dry run
+func TestCLI_TrailerFlag(t *testing.T) {
+	tr := newTestRepo(t)
+	tr.createCommitsWithMessages("a", "b", "c")
+
+	out := tr.runCLISuccess("-n", "2", "-m", "squashed", "-trailer", "Refs: #123", "-dry-run")
+	if !strings.Contains(out, `squashed\n\nRefs: #123`) {
+		t.Errorf("expected the trailer in the dry-run message, got: %s", out)
+	}
+
+	out = tr.runCLIFailure("-n", "2", "-m", "squashed", "-trailer", "Refs #123", "-yes")
+	if !strings.Contains(out, "invalid -trailer") {
+		t.Errorf("expected a malformed trailer to be rejected, got: %s", out)
+	}
+
+	tr.runCLISuccess("-n", "2", "-m", "squashed", "-trailer", "Refs: #123", "-trailer", "Reviewed-by:Jane <jane@example.com>", "-s", "-yes")
+	want := "squashed\n\nRefs: #123\nReviewed-by: Jane <jane@example.com>\nSigned-off-by: Test User <test@test.local>"
+	if msg := tr.git(t.Context(), "log", "-1", "--format=%B"); msg != want {
+		t.Errorf("expected message %q, got %q", want, msg)
+	}
+}
+
+// TestCLI_MessageFromFile tests that -F keeps the file's multi-line structure
+func TestCLI_MessageFromFile(t *testing.T) {
+	tr := newTestRepo(t)
+	tr.createCommitsWithMessages("a", "b", "c")
+	message := "Add widgets\n\nSummary:\n\n- first bullet\n- second bullet\n\nFooter paragraph"
+	path := filepath.Join(t.TempDir(), "msg.txt")
+	if err := os.WriteFile(path, []byte(message+"\n"), 0600); err != nil {
+		t.Fatalf("failed to write message file: %v", err)
+	}
+
+	tr.runCLISuccess("-n", "2", "-F", path, "-yes")
+
+	if msg := tr.git(t.Context(), "log", "-1", "--format=%B"); msg != message {
+		t.Errorf("expected message from file, got %q", msg)
+	}
+}
+
+// TestCLI_MessageFromStdin tests that -F - reads the message from stdin
+func TestCLI_MessageFromStdin(t *testing.T) {
+	tr := newTestRepo(t)
+	tr.createCommitsWithMessages("a", "b", "c")
+
+	out, err := tr.runCLIWithStdin("From stdin\n\nBody line\n", "-n", "2", "-F", "-", "-yes")
+	if err != nil {
+		t.Fatalf("CLI failed unexpectedly: %v\nOutput: %s", err, out)
+	}
+	if msg := tr.git(t.Context(), "log", "-1", "--format=%B"); msg != "From stdin\n\nBody line" {
+		t.Errorf("expected message from stdin, got %q", msg)
+	}
+
+	out = tr.runCLIFailure("-n", "2", "-F", "-")
+	if !strings.Contains(out, "Add -y") {
+		t.Errorf("expected -F - to require -y, got: %s", out)
+	}
+}
+
+// TestCLI_MessageFileExclusiveWithMessage tests that -F and -m cannot be combined
+func TestCLI_MessageFileExclusiveWithMessage(t *testing.T) {
+	tr := newTestRepo(t)
+	tr.createCommitsWithMessages("a", "b", "c")
+
+	out := tr.runCLIFailure("-n", "2", "-m", "msg", "-F", "msg.txt", "-yes")
+	if !strings.Contains(out, "mutually exclusive") {
+		t.Errorf("expected mutual exclusion error, got: %s", out)
+	}
+}
+
+// TestCLI_ConventionalRejectsNonConformingMessage tests that -conventional validates the squashed message
+func TestCLI_ConventionalRejectsNonConformingMessage(t *testing.T) {
+	tr := newTestRepo(t)
+	tr.createCommitsWithMessages("a", "b", "c")
+
+	out := tr.runCLIFailure("-n", "2", "-m", "squashed stuff", "-conventional", "-yes")
+	if !strings.Contains(out, "not a Conventional Commit") {
+		t.Errorf("expected conventional commit error, got: %s", out)
+	}
+	if count := tr.commitCount(); count != 3 {
+		t.Errorf("expected history to be untouched, got %d commits", count)
+	}
+
+	tr.runCLISuccess("-n", "2", "-m", "feat(cli): squash stuff", "-conventional", "-yes")
+	if msg := tr.lastCommitMessage(); msg != "feat(cli): squash stuff" {
+		t.Errorf("expected conforming message to be committed, got %q", msg)
+	}
+}
+
+// TestCLI_ShowDiffInDryRun tests that -show-diff prints the net change of the squashed commits
+func TestCLI_ShowDiffInDryRun(t *testing.T) {
+	tr := newTestRepo(t)
+	tr.createCommitsWithMessages("a", "b", "c")
+
+	out := tr.runCLISuccess("-n", "2", "-dry-run", "-show-diff")
+	if !strings.Contains(out, "diff --git") || !strings.Contains(out, "+b") {
+		t.Errorf("expected combined diff in dry-run output, got: %s", out)
+	}
+	if strings.Contains(out, "\x1b[") {
+		t.Errorf("expected no color codes when stdout is not a terminal, got: %q", out)
+	}
+
+	out = tr.runCLISuccess("-n", "2", "-dry-run", "-show-diff", "-color", "always")
+	if !strings.Contains(out, "\x1b[") {
+		t.Errorf("expected colored diff with -color always, got: %q", out)
+	}
+
+	out = tr.runCLISuccess("-n", "2", "-dry-run")
+	if strings.Contains(out, "diff --git") {
+		t.Errorf("expected no diff without -show-diff, got: %s", out)
+	}
+}
+
+// TestCLI_DetachedHeadRequiresForce tests that a detached HEAD is refused unless -force is given
+func TestCLI_DetachedHeadRequiresForce(t *testing.T) {
+	tr := newTestRepo(t)
+	tr.createCommitsWithMessages("a", "b", "c")
+	tr.git(t.Context(), "checkout", "-q", "--detach")
+
+	out := tr.runCLIFailure("-n", "2", "-m", "squashed", "-yes")
+	if !strings.Contains(out, "HEAD is detached") {
+		t.Errorf("expected detached HEAD error, got: %s", out)
+	}
+
+	out = tr.runCLISuccess("-n", "2", "-dry-run")
+	if !strings.Contains(out, "Warning: HEAD is detached") {
+		t.Errorf("expected detached HEAD warning in dry-run, got: %s", out)
+	}
+
+	out = tr.runCLIFailure("-n", "2", "-m", "squashed", "-yes", "-force", "-no-backup")
+	if !strings.Contains(out, "-no-backup") {
+		t.Errorf("expected detached HEAD to be refused with -no-backup, got: %s", out)
+	}
+
+	tr.runCLISuccess("-n", "2", "-m", "squashed", "-yes", "-force")
+	if msg := tr.lastCommitMessage(); msg != "squashed" {
+		t.Errorf("expected squash with -force, got %q", msg)
+	}
+}
+
+// TestCLI_OntoMovesSquashedCommit tests that -onto rebases the squashed commit onto another ref
+func TestCLI_OntoMovesSquashedCommit(t *testing.T) {
+	tr := newTestRepo(t)
+	tr.createCommit("base")
+	tr.git(t.Context(), "branch", "upstream")
+	tr.createCommitsWithMessages("a", "b")
+
+	tr.git(t.Context(), "checkout", "-q", "upstream")
+	tr.writeFile("other.txt", "upstream change")
+	tr.git(t.Context(), "add", "other.txt")
+	tr.git(t.Context(), "commit", "-q", "-m", "upstream change")
+	upstream := tr.git(t.Context(), "rev-parse", "HEAD")
+	tr.git(t.Context(), "checkout", "-q", "work")
+
+	out := tr.runCLISuccess("-n", "2", "-m", "squashed", "-onto", "upstream", "-dry-run")
+	if !strings.Contains(out, "git rebase --onto upstream ") {
+		t.Errorf("expected rebase step in dry-run output, got: %s", out)
+	}
+
+	tr.runCLISuccess("-n", "2", "-m", "squashed", "-onto", "upstream", "-yes")
+	if parent := tr.git(t.Context(), "rev-parse", "HEAD^"); parent != upstream {
+		t.Errorf("expected squashed commit on top of upstream %s, got parent %s", upstream, parent)
+	}
+	if msg := tr.lastCommitMessage(); msg != "squashed" {
+		t.Errorf("expected squashed commit, got %q", msg)
+	}
+}
+
+// TestCLI_OntoConflictRestoresBackup tests that a conflicting -onto move is aborted and rolled back
+func TestCLI_OntoConflictRestoresBackup(t *testing.T) {
+	tr := newTestRepo(t)
+	tr.createCommit("base")
+	tr.git(t.Context(), "branch", "upstream")
+	tr.createCommitsWithMessages("a", "b")
+	head := tr.git(t.Context(), "rev-parse", "HEAD")
+
+	tr.git(t.Context(), "checkout", "-q", "upstream")
+	tr.createCommit("conflicting")
+	tr.git(t.Context(), "checkout", "-q", "work")
+
+	out := tr.runCLIFailure("-n", "2", "-m", "squashed", "-onto", "upstream", "-yes")
+	if !strings.Contains(out, "Failed to move the squashed commit onto upstream") {
+		t.Errorf("expected rebase failure, got: %s", out)
+	}
+	if got := tr.git(t.Context(), "rev-parse", "HEAD"); got != head {
+		t.Errorf("expected HEAD to be restored to %s, got %s", head, got)
+	}
+}
+
+// TestCLI_ExecRunsAfterSquash tests that -exec runs from the repository root and a failure keeps the squash
+func TestCLI_ExecRunsAfterSquash(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("exec command requires a POSIX shell")
+	}
+	tr := newTestRepo(t)
+	tr.createCommitsWithMessages("a", "b", "c", "d")
+
+	tr.runCLISuccess("-n", "2", "-m", "squashed", "-yes", "-exec", "git log -1 --format=%s > exec-out.txt")
+	data, err := os.ReadFile(filepath.Join(tr.Dir, "exec-out.txt"))
+	if err != nil {
+		t.Fatalf("expected -exec to write a file in the repository root: %v", err)
+	}
+	if strings.TrimSpace(string(data)) != "squashed" {
+		t.Errorf("expected -exec to run after the squash, got %q", data)
+	}
+	if err = os.Remove(filepath.Join(tr.Dir, "exec-out.txt")); err != nil {
+		t.Fatal(err)
+	}
+
+	out := tr.runCLIFailure("-n", "2", "-m", "again", "-yes", "-exec", "exit 3")
+	if !strings.Contains(out, "-exec command \"exit 3\" failed") || !strings.Contains(out, "Recovery: git reset --hard locsquash/backup-") {
+		t.Errorf("expected -exec failure with backup hint, got: %s", out)
+	}
+	if msg := tr.lastCommitMessage(); msg != "again" {
+		t.Errorf("expected squash to be kept after -exec failure, got %q", msg)
+	}
+}
+
+// TestCLI_SinceTag tests that -since-tag squashes the commits after the latest or a named tag
+func TestCLI_SinceTag(t *testing.T) {
+	tr := newTestRepo(t)
+	tr.createCommitsWithMessages("a", "b")
+	tr.git(t.Context(), "tag", "v1.0")
+	tr.createCommitsWithMessages("c", "d")
+	tr.git(t.Context(), "tag", "v1.1")
+	tr.createCommitsWithMessages("e", "f", "g")
+
+	out := tr.runCLISuccess("-since-latest-tag", "-dry-run")
+	if !strings.Contains(out, "v1.1..HEAD (3 commits)") {
+		t.Errorf("expected resolved range for the latest tag, got: %s", out)
+	}
+
+	out = tr.runCLISuccess("-since-tag", "v1.0", "-dry-run")
+	if !strings.Contains(out, "v1.0..HEAD (5 commits)") {
+		t.Errorf("expected resolved range for v1.0, got: %s", out)
+	}
+
+	tr.runCLISuccess("-since-latest-tag", "-m", "release notes", "-yes")
+	if count := tr.commitCount(); count != 5 {
+		t.Errorf("expected 5 commits after squashing since v1.1, got %d", count)
+	}
+	if tagged := tr.git(t.Context(), "rev-parse", "HEAD^"); tagged != tr.git(t.Context(), "rev-parse", "v1.1^{commit}") {
+		t.Errorf("expected squashed commit to sit on the tagged commit, got parent %s", tagged)
+	}
+}
+
+// TestCLI_StrayArgumentIsRejected tests that a value that does not belong to a flag stops locsquash,
+// instead of the flags after it, such as -dry-run, being dropped and history rewritten
+func TestCLI_StrayArgumentIsRejected(t *testing.T) {
+	tr := newTestRepo(t)
+	tr.createCommitsWithMessages("a", "b")
+	tr.git(t.Context(), "tag", "v1")
+	tr.createCommitsWithMessages("c", "d")
+	head := tr.git(t.Context(), "rev-parse", "HEAD")
+
+	out, code := tr.runCLIExitCode("-y", "-since-latest-tag", "v1", "-dry-run")
+	if code != 2 || !strings.Contains(out, `unexpected argument "v1"`) {
+		t.Errorf("expected a usage error for the stray argument, got exit %d: %s", code, out)
+	}
+	if got := tr.git(t.Context(), "rev-parse", "HEAD"); got != head {
+		t.Errorf("expected history to be left alone, HEAD moved from %s to %s", head, got)
+	}
+	if branches := tr.git(t.Context(), "branch", "--list", "locsquash/*"); branches != "" {
+		t.Errorf("expected no backup branch, got: %s", branches)
+	}
+}
+
+// TestCLI_SinceTagWithoutTags tests that -since-tag fails clearly when the repository has no tags
+func TestCLI_SinceTagWithoutTags(t *testing.T) {
+	tr := newTestRepo(t)
+	tr.createCommitsWithMessages("a", "b", "c")
+
+	out := tr.runCLIFailure("-since-latest-tag", "-yes")
+	if !strings.Contains(out, "no tags found") || !strings.Contains(out, "-n or -to") {
+		t.Errorf("expected no-tags error, got: %s", out)
+	}
+}
+
+// TestCLI_MaxAgeRefusesOldCommits tests that -max-age rejects ranges reaching back past the limit
+func TestCLI_MaxAgeRefusesOldCommits(t *testing.T) {
+	tr := newTestRepo(t)
+	old := []string{"GIT_AUTHOR_DATE=2020-01-01T00:00:00Z", "GIT_COMMITTER_DATE=2020-01-01T00:00:00Z"}
+	tr.createCommit("ancient base")
+	tr.writeFile("old.txt", "old")
+	tr.git(t.Context(), "add", "old.txt")
+	cmd := exec.CommandContext(t.Context(), "git", "commit", "-q", "-m", "old")
+	cmd.Dir = tr.Dir
+	cmd.Env = append(os.Environ(), old...)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("failed to create old commit: %v\n%s", err, out)
+	}
+	tr.createCommitsWithMessages("new1", "new2")
+
+	tr.runCLISuccess("-n", "2", "-max-age", "72h", "-dry-run")
+
+	out := tr.runCLIFailure("-n", "3", "-m", "squashed", "-max-age", "72h", "-yes")
+	if !strings.Contains(out, "older than -max-age 72h") {
+		t.Errorf("expected max-age error, got: %s", out)
+	}
+
+	out = tr.runCLIFailure("-n", "3", "-max-age", "soon", "-yes")
+	if !strings.Contains(out, "invalid -max-age") {
+		t.Errorf("expected invalid -max-age error, got: %s", out)
+	}
+}
+
+// TestCLI_WritesRecoveryManifest tests that a squash records the pre-squash state in the git directory
+func TestCLI_WritesRecoveryManifest(t *testing.T) {
+	tr := newTestRepo(t)
+	tr.createCommitsWithMessages("a", "b", "c")
+	tr.writeFile("dirty.txt", "uncommitted")
+	head := tr.git(t.Context(), "rev-parse", "HEAD")
+
+	tr.runCLISuccess("-n", "2", "-m", "squashed", "-stash", "-yes")
+
+	data, err := os.ReadFile(filepath.Join(tr.Dir, ".git", "locsquash-last.json"))
+	if err != nil {
+		t.Fatalf("expected recovery manifest: %v", err)
+	}
+	var manifest struct {
+		Head      string `json:"head"`
+		Branch    string `json:"branch"`
+		Backup    string `json:"backup"`
+		Stash     string `json:"stash"`
+		StashHash string `json:"stash_hash"`
+		Result    string `json:"result"`
+		Created   string `json:"created"`
+	}
+	if err = json.Unmarshal(data, &manifest); err != nil {
+		t.Fatalf("invalid manifest: %v\n%s", err, data)
+	}
+	if manifest.Head != head || manifest.Branch != "work" {
+		t.Errorf("expected head %s on work, got %+v", head, manifest)
+	}
+	if !strings.HasPrefix(manifest.Backup, "locsquash/backup-") {
+		t.Errorf("expected backup name, got %q", manifest.Backup)
+	}
+	if manifest.Stash != "stash@{0}" || manifest.StashHash == "" || manifest.Created == "" {
+		t.Errorf("expected stash and timestamp to be recorded, got %+v", manifest)
+	}
+	if got := tr.git(t.Context(), "rev-parse", "HEAD"); manifest.Result != got {
+		t.Errorf("expected the squashed commit %s to be recorded, got %q", got, manifest.Result)
+	}
+}
+
+// TestCLI_RecoverLast tests that -recover-last restores the pre-squash HEAD and auto-stashed changes
+func TestCLI_RecoverLast(t *testing.T) {
+	tr := newTestRepo(t)
+	tr.createCommitsWithMessages("a", "b", "c")
+	tr.writeFile("dirty.txt", "uncommitted")
+	head := tr.git(t.Context(), "rev-parse", "HEAD")
+
+	tr.runCLISuccess("-n", "2", "-m", "squashed", "-stash", "-yes")
+
+	// The reapplied auto-stash makes the tree dirty
+	out := tr.runCLIFailure("-recover-last", "-yes")
+	if !strings.Contains(out, "uncommitted changes") {
+		t.Errorf("expected dirty tree to be refused, got: %s", out)
+	}
+
+	out = tr.runCLISuccess("-recover-last", "-dry-run")
+	if !strings.Contains(out, "git reset --hard locsquash/backup-") {
+		t.Errorf("expected recovery plan, got: %s", out)
+	}
+	if got := tr.git(t.Context(), "rev-parse", "HEAD"); got == head {
+		t.Fatal("dry run must not recover")
+	}
+
+	tr.runCLISuccess("-recover-last", "-stash", "-yes")
+	if got := tr.git(t.Context(), "rev-parse", "HEAD"); got != head {
+		t.Errorf("expected HEAD to be restored to %s, got %s", head, got)
+	}
+	if status := tr.git(t.Context(), "status", "--porcelain"); !strings.Contains(status, "dirty.txt") {
+		t.Errorf("expected auto-stashed changes to be reapplied, got status: %q", status)
+	}
+
+	out = tr.runCLIFailure("-recover-last", "-stash", "-yes")
+	if !strings.Contains(out, "no recovery manifest") {
+		t.Errorf("expected manifest to be consumed by recovery, got: %s", out)
+	}
+}
+
+// TestCLI_RecoverLastRefusesNewCommits tests that -recover-last will not discard commits made
+// after the squash unless -force is given
+func TestCLI_RecoverLastRefusesNewCommits(t *testing.T) {
+	tr := newTestRepo(t)
+	tr.createCommitsWithMessages("a", "b", "c")
+	head := tr.git(t.Context(), "rev-parse", "HEAD")
+
+	tr.runCLISuccess("-n", "2", "-m", "squashed", "-yes")
+	tr.createCommit("later work")
+	later := tr.git(t.Context(), "rev-parse", "HEAD")
+
+	out := tr.runCLIFailure("-recover-last", "-yes")
+	if !strings.Contains(out, "discard the commits made since") || !strings.Contains(out, "-force") {
+		t.Errorf("expected recovery to be refused, got: %s", out)
+	}
+	if got := tr.git(t.Context(), "rev-parse", "HEAD"); got != later {
+		t.Fatalf("expected HEAD to stay at the new commit %s, got %s", later, got)
+	}
+
+	tr.runCLISuccess("-recover-last", "-force", "-yes")
+	if got := tr.git(t.Context(), "rev-parse", "HEAD"); got != head {
+		t.Errorf("expected -force to restore %s, got %s", head, got)
+	}
+}
+
+// TestCLI_RecoverLastAfterBackupDeleted tests that -recover-last falls back to the recorded HEAD
+// when the backup was deleted after a successful squash
+func TestCLI_RecoverLastAfterBackupDeleted(t *testing.T) {
+	tr := newTestRepo(t)
+	tr.createCommitsWithMessages("a", "b", "c")
+	head := tr.git(t.Context(), "rev-parse", "HEAD")
+
+	tr.runCLISuccess("-n", "2", "-m", "squashed", "-keep-backup-on-success=false", "-yes")
+
+	out := tr.runCLISuccess("-recover-last", "-yes")
+	if !strings.Contains(out, "no longer exists") {
+		t.Errorf("expected a warning about the deleted backup, got: %s", out)
+	}
+	if got := tr.git(t.Context(), "rev-parse", "HEAD"); got != head {
+		t.Errorf("expected HEAD to be restored to %s, got %s", head, got)
+	}
+}
+
+// TestCLI_RecoverLastVerifiesBackup tests that -recover-last refuses when the backup was moved
+func TestCLI_RecoverLastVerifiesBackup(t *testing.T) {
+	tr := newTestRepo(t)
+	tr.createCommitsWithMessages("a", "b", "c")
+
+	out := tr.runCLISuccess("-n", "2", "-m", "squashed", "-yes")
+	backup := out[strings.Index(out, "locsquash/backup-"):]
+	backup = strings.Fields(backup)[0]
+	tr.git(t.Context(), "branch", "-f", backup, "HEAD")
+
+	out = tr.runCLIFailure("-recover-last", "-yes")
+	if !strings.Contains(out, "recorded pre-squash HEAD") {
+		t.Errorf("expected backup mismatch error, got: %s", out)
+	}
+}
+
+// TestCLI_RefusesShallowClone tests that a shallow clone fails early with a hint to unshallow it
+func TestCLI_RefusesShallowClone(t *testing.T) {
+	tr := newTestRepo(t)
+	tr.createCommitsWithMessages("a", "b", "c", "d")
+
+	shallow := &testRepo{Dir: filepath.Join(t.TempDir(), "shallow"), t: t, Binary: tr.Binary}
+	tr.git(t.Context(), "clone", "-q", "--depth", "2", "file://"+filepath.ToSlash(tr.Dir), shallow.Dir)
+	head := shallow.git(t.Context(), "rev-parse", "HEAD")
+
+	out := shallow.runCLIFailure("-n", "2", "-m", "squashed", "-yes")
+	if !strings.Contains(out, "shallow clone") || !strings.Contains(out, "git fetch --unshallow") {
+		t.Errorf("expected shallow clone error, got: %s", out)
+	}
+	if got := shallow.git(t.Context(), "rev-parse", "HEAD"); got != head {
+		t.Errorf("expected HEAD to be unchanged, got %s", got)
+	}
+}
+
+// TestCLI_Unpushed tests that -unpushed squashes exactly the commits ahead of upstream
+func TestCLI_Unpushed(t *testing.T) {
+	tr := newTestRepo(t)
+	tr.createCommitsWithMessages("a", "b")
+	tr.addRemote()
+	tr.createCommitsWithMessages("c", "d", "e")
+	pushed := tr.git(t.Context(), "rev-parse", "HEAD~3")
+
+	tr.runCLISuccess("-unpushed", "-m", "local work", "-yes")
+
+	if count := tr.commitCount(); count != 3 {
+		t.Errorf("expected 3 commits after squashing the unpushed ones, got %d", count)
+	}
+	if parent := tr.git(t.Context(), "rev-parse", "HEAD^"); parent != pushed {
+		t.Errorf("expected squashed commit on top of the pushed history, got parent %s", parent)
+	}
+
+	out := tr.runCLIFailure("-unpushed", "-yes")
+	if !strings.Contains(out, "nothing meaningful to squash") {
+		t.Errorf("expected nothing to squash with one unpushed commit, got: %s", out)
+	}
+}
+
+// TestCLI_UnpushedWithoutUpstream tests that -unpushed fails clearly without an upstream
+func TestCLI_UnpushedWithoutUpstream(t *testing.T) {
+	tr := newTestRepo(t)
+	tr.createCommitsWithMessages("a", "b", "c")
+
+	out := tr.runCLIFailure("-unpushed", "-yes")
+	if !strings.Contains(out, "no upstream") || !strings.Contains(out, "-n") {
+		t.Errorf("expected missing upstream error, got: %s", out)
+	}
+
+	out = tr.runCLIFailure("-unpushed", "-n", "2", "-yes")
+	if !strings.Contains(out, "mutually exclusive") {
+		t.Errorf("expected -unpushed and -n to be exclusive, got: %s", out)
+	}
+}
+
+// TestCLI_NoVerifyInDryRun tests that -no-verify appears in the dry-run commit command
+func TestCLI_NoVerifyInDryRun(t *testing.T) {
+	tr := newTestRepo(t)
+	tr.createCommitsWithMessages("a", "b", "c")
+
+	out := tr.runCLISuccess("-n", "2", "-no-verify", "-dry-run")
+
+	if !strings.Contains(out, "--no-verify") {
+		t.Errorf("expected --no-verify in dry-run output, got: %s", out)
+	}
+}
+
+// TestCLI_RefusesMergesWithoutAllowMerges tests that ranges containing merges are rejected by default
+func TestCLI_RefusesMergesWithoutAllowMerges(t *testing.T) {
+	tr := newTestRepo(t)
+	tr.createCommitsWithMessages("base", "one")
+	tr.createMerge("feature")
+	tr.createCommitsWithMessages("after merge")
+
+	headBefore := tr.git(t.Context(), "rev-parse", "HEAD")
+
+	out := tr.runCLIFailure("-n", "2", "-m", "squashed", "-yes")
+
+	if !strings.Contains(out, "merge commit") || !strings.Contains(out, "-allow-merges") {
+		t.Errorf("expected merge commit error, got: %s", out)
+	}
+	if headAfter := tr.git(t.Context(), "rev-parse", "HEAD"); headAfter != headBefore {
+		t.Errorf("HEAD changed after rejected squash: before=%s, after=%s", headBefore, headAfter)
+	}
+	if branches := tr.git(t.Context(), "branch", "--list", "locsquash/backup-*"); branches != "" {
+		t.Errorf("expected no backup branch after rejected squash, got: %s", branches)
+	}
+}
+
+// TestCLI_AllowMergesSquashesAcrossMerge tests that -allow-merges squashes across a merge commit
+func TestCLI_AllowMergesSquashesAcrossMerge(t *testing.T) {
+	tr := newTestRepo(t)
+	tr.createCommitsWithMessages("base", "one")
+	tr.createMerge("feature")
+	tr.createCommitsWithMessages("after merge")
+
+	tr.runCLISuccess("-n", "2", "-m", "squashed", "-allow-merges", "-yes")
+
+	if parents := tr.git(t.Context(), "log", "-1", "--format=%P"); strings.Contains(parents, " ") {
+		t.Errorf("expected a single-parent squashed commit, got parents %q", parents)
+	}
+}
+
+// TestCLI_DryRunWarnsAboutMerges tests that dry-run surfaces the merge warning
+func TestCLI_DryRunWarnsAboutMerges(t *testing.T) {
+	tr := newTestRepo(t)
+	tr.createCommitsWithMessages("base", "one")
+	tr.createMerge("feature")
+
+	out := tr.runCLISuccess("-n", "2", "-dry-run")
+
+	if !strings.Contains(out, "Warning: the selected range contains 1 merge commit") {
+		t.Errorf("expected merge warning in dry-run output, got: %s", out)
+	}
+}
+
+// TestCLI_RefusesPushedCommits tests that squashing commits already on a remote is rejected by default
+func TestCLI_RefusesPushedCommits(t *testing.T) {
+	tr := newTestRepo(t)
+	tr.createCommitsWithMessages("base", "pushed")
+	tr.addRemote()
+	tr.createCommitsWithMessages("local one", "local two")
+
+	out := tr.runCLIFailure("-n", "3", "-m", "squashed", "-yes")
+
+	if !strings.Contains(out, "already exist on origin/") || !strings.Contains(out, "force-push") {
+		t.Errorf("expected pushed commits error, got: %s", out)
+	}
+}
+
+// TestCLI_UnpushedCommitsSquashFreely tests that squashing only local commits is allowed
+func TestCLI_UnpushedCommitsSquashFreely(t *testing.T) {
+	tr := newTestRepo(t)
+	tr.createCommitsWithMessages("base", "pushed")
+	tr.addRemote()
+	tr.createCommitsWithMessages("local one", "local two")
+
+	tr.runCLISuccess("-n", "2", "-m", "squashed", "-yes")
+
+	if count := tr.commitCount(); count != 3 {
+		t.Errorf("expected 3 commits after squash, got %d", count)
+	}
+}
+
+// TestCLI_ForcePushedAllowsPushedCommits tests that -force-pushed overrides the remote check
+func TestCLI_ForcePushedAllowsPushedCommits(t *testing.T) {
+	tr := newTestRepo(t)
+	tr.createCommitsWithMessages("base", "pushed")
+	tr.addRemote()
+	tr.createCommitsWithMessages("local one", "local two")
+
+	tr.runCLISuccess("-n", "3", "-m", "squashed", "-force-pushed", "-yes")
+
+	if count := tr.commitCount(); count != 2 {
+		t.Errorf("expected 2 commits after squash, got %d", count)
+	}
+}
+
+// TestCLI_RefusesProtectedBranch tests that squashing on main is rejected without -force
+func TestCLI_RefusesProtectedBranch(t *testing.T) {
+	tr := newTestRepo(t)
+	tr.createCommitsWithMessages("a", "b", "c")
+	tr.git(t.Context(), "branch", "-m", "main")
+
+	out := tr.runCLIFailure("-n", "2", "-m", "squashed", "-yes")
+	if !strings.Contains(out, `branch "main" is protected`) || !strings.Contains(out, "-force") {
+		t.Errorf("expected protected branch error, got: %s", out)
+	}
+
+	tr.runCLISuccess("-n", "2", "-m", "squashed", "-force", "-yes")
+	if count := tr.commitCount(); count != 2 {
+		t.Errorf("expected 2 commits after forced squash, got %d", count)
+	}
+}
+
+// TestCLI_ProtectedFlagAndEnv tests that -protected and LOCSQUASH_PROTECTED extend the protected set
+func TestCLI_ProtectedFlagAndEnv(t *testing.T) {
+	tr := newTestRepo(t)
+	tr.createCommitsWithMessages("a", "b", "c")
+	tr.git(t.Context(), "branch", "-m", "release")
+
+	out := tr.runCLIFailure("-n", "2", "-protected", "develop, release", "-yes")
+	if !strings.Contains(out, `branch "release" is protected`) {
+		t.Errorf("expected protected branch error from -protected, got: %s", out)
+	}
+
+	out, err := tr.runCLIWithEnv([]string{"LOCSQUASH_PROTECTED=release"}, "-n", "2", "-yes")
+	if err == nil || !strings.Contains(out, `branch "release" is protected`) {
+		t.Errorf("expected protected branch error from LOCSQUASH_PROTECTED, got: %s", out)
+	}
+}
+
+// TestCLI_ConfigFileProvidesDefaults tests that .locsquash.yml in the repo root sets flag defaults
+func TestCLI_ConfigFileProvidesDefaults(t *testing.T) {
+	tr := newTestRepo(t)
+	tr.writeFile(".locsquash.yml", "tag-backup: true\nm: from config\ntrailer: [\"Refs: #1\", \"Reviewed-by: Jane <jane@example.com>\"]\n")
+	tr.createCommitsWithMessages("a", "b", "c")
+
+	tr.runCLISuccess("-n", "2", "-yes")
+
+	if msg := tr.git(t.Context(), "log", "-1", "--format=%B"); msg != "from config\n\nRefs: #1\nReviewed-by: Jane <jane@example.com>" {
+		t.Errorf("expected message and one trailer per list item from config, got %q", msg)
+	}
+	if tags := tr.git(t.Context(), "tag", "--list", "locsquash/backup-*"); tags == "" {
+		t.Error("expected a backup tag with tag-backup: true in config")
+	}
+}
+
+// TestCLI_ConfigFileRepoKeysAreLimited tests that the repository config cannot run commands,
+// reach remotes or turn off a safety guard, which the config in $HOME still may, and that no
+// config can set -C
+func TestCLI_ConfigFileRepoKeysAreLimited(t *testing.T) {
+	tr := newTestRepo(t)
+	tr.createCommitsWithMessages("a", "b", "c")
+	home := t.TempDir()
+	env := []string{"HOME=" + home}
+	marker := filepath.Join(t.TempDir(), "exec-ran")
+	// Keep the config file out of the dirty check while it changes between runs
+	tr.writeFile(".git/info/exclude", ".locsquash.yml\n")
+
+	for _, key := range []string{
+		"exec: touch " + marker, "push-backup: origin", "yes: true",
+		"keep-backup-on-success: false", "warn-threshold: 0", "no-verify: true",
+		"allow-empty: true", "dry-run: true", "json: true",
+	} {
+		tr.writeFile(".locsquash.yml", key+"\n")
+		out, err := tr.runCLIWithEnv(env, "-n", "2", "-m", "squashed", "-yes")
+		if err == nil || !strings.Contains(out, "is not allowed in the repository config") {
+			t.Errorf("expected %q to be rejected in the repository config, got: %s", key, out)
+		}
+	}
+	if _, err := os.Stat(marker); err == nil {
+		t.Errorf("expected the repository config not to run -exec")
+	}
+	if count := tr.commitCount(); count != 3 {
+		t.Errorf("expected history to be left alone, got %d commits", count)
+	}
+
+	tr.writeFile(".locsquash.yml", "C: /tmp\n")
+	if out, err := tr.runCLIWithEnv(env, "-n", "2", "-dry-run"); err == nil || !strings.Contains(out, `key "C" can only be given on the command line`) {
+		t.Errorf("expected C to be rejected, got: %s", out)
+	}
+
+	tr.writeFile(".locsquash.yml", "m: from repo\n")
+	if err := os.WriteFile(filepath.Join(home, ".locsquash.yml"), []byte("exec: touch "+marker+"\n"), 0600); err != nil {
+		t.Fatalf("failed to write home config: %v", err)
+	}
+	if out, err := tr.runCLIWithEnv(env, "-n", "2", "-yes"); err != nil {
+		t.Fatalf("CLI failed unexpectedly: %v\nOutput: %s", err, out)
+	}
+	if _, err := os.Stat(marker); err != nil {
+		t.Errorf("expected exec from the home config to run: %v", err)
+	}
+}
+
+// TestCLI_ConfigFileExplicitFlagWins tests that command-line flags override config values
+func TestCLI_ConfigFileExplicitFlagWins(t *testing.T) {
+	tr := newTestRepo(t)
+	tr.writeFile(".locsquash.yml", "m: from config\n")
+	tr.createCommitsWithMessages("a", "b", "c")
+
+	tr.runCLISuccess("-n", "2", "-m", "from flag", "-yes")
+
+	if msg := tr.lastCommitMessage(); msg != "from flag" {
+		t.Errorf("expected message from flag, got %q", msg)
+	}
+}
+
+// TestCLI_ConfigFileFromHome tests that a config file in $HOME is loaded
+func TestCLI_ConfigFileFromHome(t *testing.T) {
+	tr := newTestRepo(t)
+	tr.createCommitsWithMessages("a", "b", "c")
+
+	home := t.TempDir()
+	if err := os.WriteFile(filepath.Join(home, ".locsquashrc"), []byte("m: from home\n"), 0600); err != nil {
+		t.Fatalf("failed to write home config: %v", err)
+	}
+
+	out, err := tr.runCLIWithEnv([]string{"HOME=" + home}, "-n", "2", "-yes")
+	if err != nil {
+		t.Fatalf("CLI failed unexpectedly: %v\nOutput: %s", err, out)
+	}
+	if msg := tr.lastCommitMessage(); msg != "from home" {
+		t.Errorf("expected message from home config, got %q", msg)
+	}
+}
+
+// TestCLI_ConfigFileMalformed tests that malformed YAML and unknown keys produce clear errors
+func TestCLI_ConfigFileMalformed(t *testing.T) {
+	tr := newTestRepo(t)
+	tr.createCommitsWithMessages("a", "b", "c")
+
+	tr.writeFile(".locsquash.yml", "stash: [unclosed\n")
+	out := tr.runCLIFailure("-n", "2", "-dry-run")
+	if !strings.Contains(out, "config file") || !strings.Contains(out, ".locsquash.yml") {
+		t.Errorf("expected config parse error, got: %s", out)
+	}
+
+	tr.writeFile(".locsquash.yml", "stahs: true\n")
+	out = tr.runCLIFailure("-n", "2", "-dry-run")
+	if !strings.Contains(out, `unknown key "stahs"`) {
+		t.Errorf("expected unknown key error, got: %s", out)
+	}
+}
+
+// TestCLI_VersionFlag tests that -version prints the version with platform info
+func TestCLI_VersionFlag(t *testing.T) {
+	tr := newTestRepo(t)
+
+	out := tr.runCLISuccess("-version")
+
+	if !strings.HasPrefix(out, "locsquash ") || !strings.Contains(out, runtime.GOOS+"/"+runtime.GOARCH) {
+		t.Errorf("expected version with platform info, got: %s", out)
+	}
+}
+
+// TestCLI_VersionSubcommandOutsideRepo tests that `version` works without a git repository
+func TestCLI_VersionSubcommandOutsideRepo(t *testing.T) {
+	cmd := exec.CommandContext(t.Context(), buildTestBinary(t), "version") //nolint:gosec
+	cmd.Dir = t.TempDir()
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("version subcommand failed: %v\nOutput: %s", err, out)
+	}
+	if !strings.Contains(string(out), runtime.Version()) {
+		t.Errorf("expected Go version in output, got: %s", out)
+	}
+}
+
+// TestCLI_QuietSuppressesProgress tests that -quiet prints nothing on success
+func TestCLI_QuietSuppressesProgress(t *testing.T) {
+	tr := newTestRepo(t)
+	tr.createCommitsWithMessages("a", "b", "c")
+
+	out := tr.runCLISuccess("-n", "2", "-m", "squashed", "-quiet", "-yes")
+
+	if out != "" {
+		t.Errorf("expected no output with -quiet, got: %q", out)
+	}
+	if count := tr.commitCount(); count != 2 {
+		t.Errorf("expected 2 commits after squash, got %d", count)
+	}
+}
+
+// TestCLI_QuietStillReportsErrors tests that -quiet does not hide errors
+func TestCLI_QuietStillReportsErrors(t *testing.T) {
+	tr := newTestRepo(t)
+	tr.createCommitsWithMessages("a", "b", "c")
+	tr.writeFile("dirty.txt", "uncommitted")
+
+	out := tr.runCLIFailure("-n", "2", "-q", "-yes")
+
+	if !strings.Contains(out, "uncommitted changes") {
+		t.Errorf("expected error with -quiet, got: %s", out)
+	}
+}
+
+// TestCLI_VerboseEchoesGitCommands tests that -verbose prints git commands to stderr
+func TestCLI_VerboseEchoesGitCommands(t *testing.T) {
+	tr := newTestRepo(t)
+	tr.createCommitsWithMessages("a", "b", "c")
+
+	_, stderr, err := tr.runCLISplit("-n", "2", "-m", "squashed", "-verbose", "-yes")
+	if err != nil {
+		t.Fatalf("CLI failed unexpectedly: %v\nstderr: %s", err, stderr)
+	}
+
+	for _, want := range []string{"+ git rev-parse --is-inside-work-tree", "+ git reset --soft HEAD~2", `git commit --author "Test User <test@test.local>" -m squashed`} {
+		if !strings.Contains(stderr, want) {
+			t.Errorf("expected %q in verbose output, got: %s", want, stderr)
+		}
+	}
+}
+
+// TestCLI_RefusesDuringInProgressMerge tests that an unfinished merge blocks the squash
+func TestCLI_RefusesDuringInProgressMerge(t *testing.T) {
+	tr := newTestRepo(t)
+	tr.createCommitsWithMessages("a", "b", "c")
+	head := tr.git(t.Context(), "rev-parse", "HEAD")
+	if err := os.WriteFile(filepath.Join(tr.Dir, ".git", "MERGE_HEAD"), []byte(head+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	out := tr.runCLIFailure("-n", "2", "-y")
+
+	if !strings.Contains(out, "MERGE_HEAD exists") {
+		t.Errorf("expected in-progress merge error, got: %s", out)
+	}
+	if count := tr.commitCount(); count != 3 {
+		t.Errorf("expected 3 commits to remain, got %d", count)
+	}
+}
+
+// TestCLI_NoColorWhenRedirectedToFile tests that output redirected to a file contains no ANSI codes
+func TestCLI_NoColorWhenRedirectedToFile(t *testing.T) {
+	tr := newTestRepo(t)
+	tr.createCommitsWithMessages("a", "b", "c")
+
+	outFile, err := os.Create(filepath.Join(t.TempDir(), "out.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = outFile.Close() }()
+
+	cmd := exec.CommandContext(t.Context(), tr.Binary, "-n", "2", "-dry-run", "-show-diff") //nolint:gosec
+	cmd.Dir = tr.Dir
+	cmd.Env = append(os.Environ(), "NO_COLOR=")
+	cmd.Stdout = outFile
+	cmd.Stderr = outFile
+	if err = cmd.Run(); err != nil {
+		t.Fatalf("CLI failed unexpectedly: %v", err)
+	}
+
+	out, err := os.ReadFile(outFile.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(out), "commits will be squashed") {
+		t.Fatalf("expected dry-run output in file, got: %s", out)
+	}
+	if strings.Contains(string(out), "\x1b[") {
+		t.Errorf("expected no color codes when redirected to a file, got: %q", out)
+	}
+}
+
+// TestCLI_RunFromNestedSubdirectory tests that squashing and recovering work from a nested subdirectory
+func TestCLI_RunFromNestedSubdirectory(t *testing.T) {
+	tr := newTestRepo(t)
+	tr.createCommitsWithMessages("a", "b", "c")
+	nested := filepath.Join(tr.Dir, "pkg", "inner")
+	if err := os.MkdirAll(nested, 0o750); err != nil {
+		t.Fatal(err)
+	}
+	tr.writeFile(filepath.Join("pkg", "inner", "file.txt"), "nested")
+	tr.git(t.Context(), "add", ".")
+	tr.git(t.Context(), "commit", "-m", "d")
+
+	run := func(args ...string) string {
+		t.Helper()
+		cmd := exec.CommandContext(t.Context(), tr.Binary, args...) //nolint:gosec
+		cmd.Dir = nested
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			t.Fatalf("CLI failed unexpectedly: %v\nOutput: %s", err, out)
+		}
+		return string(out)
+	}
+
+	run("-n", "3", "-y", "-m", "squashed from a subdirectory")
+	if count := tr.commitCount(); count != 2 {
+		t.Fatalf("expected 2 commits after squash, got %d", count)
+	}
+	if msg := tr.lastCommitMessage(); msg != "squashed from a subdirectory" {
+		t.Errorf("unexpected commit message: %q", msg)
+	}
+	if _, err := os.Stat(filepath.Join(tr.Dir, ".git", "locsquash-last.json")); err != nil {
+		t.Errorf("expected recovery manifest in the git directory: %v", err)
+	}
+
+	run("-recover-last", "-y")
+	if count := tr.commitCount(); count != 4 {
+		t.Errorf("expected 4 commits after -recover-last, got %d", count)
+	}
+}
+
+// TestCLI_DryRunExitCode tests that -dry-run-exit-code signals whether the squash is viable
+func TestCLI_DryRunExitCode(t *testing.T) {
+	tr := newTestRepo(t)
+	tr.createCommitsWithMessages("a", "b", "c")
+	head := tr.git(t.Context(), "rev-parse", "HEAD")
+
+	out, code := tr.runCLIExitCode("-n", "2", "-dry-run-exit-code")
+	if code != 0 {
+		t.Fatalf("expected exit code 0 for a viable squash, got %d: %s", code, out)
+	}
+	if !strings.Contains(out, "Dry run") {
+		t.Errorf("expected normal dry-run output, got: %s", out)
+	}
+
+	// A warning that would stop a real run still prints the plan but exits with 5
+	tr.writeFile("dirty.txt", "uncommitted")
+	out, code = tr.runCLIExitCode("-n", "2", "-dry-run-exit-code")
+	if code != 5 {
+		t.Errorf("expected exit code 5 for a dirty tree, got %d: %s", code, out)
+	}
+	if !strings.Contains(out, "uncommitted changes") || !strings.Contains(out, "Planned operations") {
+		t.Errorf("expected the warning and the dry-run plan, got: %s", out)
+	}
+	if out, code = tr.runCLIExitCode("-n", "2", "-dry-run-exit-code", "-stash"); code != 0 {
+		t.Errorf("expected exit code 0 with -stash, got %d: %s", code, out)
+	}
+
+	// Errors are reported with exit code 5 as well
+	if out, code = tr.runCLIExitCode("-n", "5", "-dry-run-exit-code", "-stash"); code != 5 {
+		t.Errorf("expected exit code 5 when too many commits are selected, got %d: %s", code, out)
+	}
+
+	// Invalid flags keep the usage code
+	if out, code = tr.runCLIExitCode("-n", "2", "-to", "HEAD~2", "-dry-run-exit-code"); code != 2 {
+		t.Errorf("expected exit code 2 for invalid flags, got %d: %s", code, out)
+	}
+
+	// Without the flag, the dry run itself still succeeds
+	if out, code = tr.runCLIExitCode("-n", "2", "-dry-run"); code != 0 {
+		t.Errorf("expected plain -dry-run to exit with 0, got %d: %s", code, out)
+	}
+	if got := tr.git(t.Context(), "rev-parse", "HEAD"); got != head {
+		t.Errorf("expected HEAD to be unchanged, got %s", got)
+	}
+}
+
+// TestCLI_DryRunExitCodeNoNetChanges tests that a squash with no net change exits with 5
+func TestCLI_DryRunExitCodeNoNetChanges(t *testing.T) {
+	tr := newTestRepo(t)
+	tr.createCommitsWithMessages("a")
+	tr.git(t.Context(), "commit", "--allow-empty", "-m", "empty 1")
+	tr.git(t.Context(), "commit", "--allow-empty", "-m", "empty 2")
+
+	out, code := tr.runCLIExitCode("-n", "2", "-dry-run-exit-code")
+	if code != 5 {
+		t.Errorf("expected exit code 5 for a squash without net changes, got %d: %s", code, out)
+	}
+	if !strings.Contains(out, "no net changes") {
+		t.Errorf("expected the no-op reason, got: %s", out)
+	}
+}
+
+// TestCLI_ExitCodes tests that each failure category exits with its documented code
+func TestCLI_ExitCodes(t *testing.T) {
+	tests := []struct {
+		name  string
+		setup func(tr *testRepo)
+		args  []string
+		want  int
+	}{
+		{name: "usage", args: []string{"-n", "2", "-to", "HEAD~2", "-y"}, want: 2},
+		{name: "unknown ref", args: []string{"-to", "no-such-ref", "-y"}, want: 2},
+		{
+			name:  "dirty tree",
+			setup: func(tr *testRepo) { tr.writeFile("dirty.txt", "uncommitted") },
+			args:  []string{"-n", "2", "-y"},
+			want:  3,
+		},
+		{
+			name:  "git failure",
+			setup: func(tr *testRepo) { tr.git(tr.t.Context(), "config", "gpg.program", "false") },
+			args:  []string{"-n", "2", "-sign", "-y"},
+			want:  4,
+		},
+		{
+			name:  "safety guard",
+			setup: func(tr *testRepo) { tr.git(tr.t.Context(), "checkout", "-q", "-b", "main") },
+			args:  []string{"-n", "2", "-y"},
+			want:  1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tr := newTestRepo(t)
+			tr.createCommitsWithMessages("a", "b", "c")
+			if tt.setup != nil {
+				tt.setup(tr)
+			}
+			if out, code := tr.runCLIExitCode(tt.args...); code != tt.want {
+				t.Errorf("expected exit code %d, got %d: %s", tt.want, code, out)
+			}
+		})
+	}
+
+	t.Run("not a git repository", func(t *testing.T) {
+		cmd := exec.CommandContext(t.Context(), buildTestBinary(t), "-n", "2", "-y") //nolint:gosec
+		cmd.Dir = t.TempDir()
+		out, err := cmd.CombinedOutput()
+		var exitErr *exec.ExitError
+		if !errors.As(err, &exitErr) || exitErr.ExitCode() != 2 {
+			t.Errorf("expected exit code 2 outside a repository, got %v: %s", err, out)
+		}
+	})
+}
+
+// TestCLI_PreviewShowsDiffStat tests that -preview prints the diffstat even when stdout is not a terminal
+func TestCLI_PreviewShowsDiffStat(t *testing.T) {
+	tr := newTestRepo(t)
+	tr.createCommitsWithMessages("a", "b", "c")
+
+	// Without -y the prompt cannot be answered here, but the preview comes first
+	out := tr.runCLIFailure("-n", "2", "-preview")
+	if !strings.Contains(out, "Changes to be squashed") || !strings.Contains(out, "1 file changed") {
+		t.Errorf("expected diffstat before the prompt, got: %s", out)
+	}
+	if count := tr.commitCount(); count != 3 {
+		t.Fatalf("expected no squash without confirmation, got %d commits", count)
+	}
+
+	out = tr.runCLISuccess("-n", "2", "-preview", "-y")
+	if !strings.Contains(out, "Changes to be squashed") {
+		t.Errorf("expected diffstat with -preview -y, got: %s", out)
+	}
+	if count := tr.commitCount(); count != 2 {
+		t.Errorf("expected 2 commits after squash, got %d", count)
+	}
+}
+
+// TestCLI_StashKeptWhenReappliedChangesVanish tests that the auto-stash is only dropped after
+// verifying that its changes are back in the working tree
+func TestCLI_StashKeptWhenReappliedChangesVanish(t *testing.T) {
+	tr := newTestRepo(t)
+	tr.writeFile("h.txt", "old\n")
+	tr.git(t.Context(), "add", "h.txt")
+	tr.git(t.Context(), "commit", "-m", "base")
+	tr.git(t.Context(), "checkout", "-q", "-b", "target")
+	tr.writeFile("h.txt", "new\n")
+	tr.git(t.Context(), "commit", "-am", "update h")
+	tr.git(t.Context(), "checkout", "-q", "work")
+	tr.createCommitsWithMessages("a", "b")
+
+	// The stashed edit matches what the squashed commit lands on, so reapplying it changes nothing
+	tr.writeFile("h.txt", "new\n")
+	out := tr.runCLISuccess("-n", "2", "-onto", "target", "-stash", "-y")
+
+	if !strings.Contains(out, "show no changes: h.txt") {
+		t.Errorf("expected a warning about the vanished change, got: %s", out)
+	}
+	if stashes := tr.git(t.Context(), "stash", "list"); stashes == "" {
+		t.Error("expected the stash to be kept")
+	}
+}
+
+// TestCLI_StashDroppedAfterVerifiedReapply tests that a verified reapply drops the auto-stash
+func TestCLI_StashDroppedAfterVerifiedReapply(t *testing.T) {
+	tr := newTestRepo(t)
+	tr.createCommitsWithMessages("a", "b", "c")
+	tr.writeFile("file.txt", "edited")
+	tr.writeFile("new dir file.txt", "untracked")
+
+	out := tr.runCLISuccess("-n", "2", "-stash", "-y")
+
+	if strings.Contains(out, "Warning") {
+		t.Errorf("expected no verification warning, got: %s", out)
+	}
+	if stashes := tr.git(t.Context(), "stash", "list"); stashes != "" {
+		t.Errorf("expected the stash to be dropped, got: %s", stashes)
+	}
+	status := tr.git(t.Context(), "status", "--porcelain")
+	if !strings.Contains(status, "file.txt") || !strings.Contains(status, "new dir file.txt") {
+		t.Errorf("expected both changes to be restored, got: %q", status)
+	}
+}
+
+// TestCLI_StashUntrackedFalseLeavesUntrackedFiles tests that -stash-untracked=false only stashes tracked changes
+func TestCLI_StashUntrackedFalseLeavesUntrackedFiles(t *testing.T) {
+	tr := newTestRepo(t)
+	tr.createCommitsWithMessages("a", "b", "c")
+	tr.writeFile("file.txt", "edited")
+	tr.writeFile("artifact.bin", "build output")
+
+	out := tr.runCLISuccess("-n", "2", "-stash", "-stash-untracked=false", "-dry-run")
+	if !strings.Contains(out, "git stash push -m \"locsquash auto-stash\"") {
+		t.Errorf("expected stash command without -u in dry-run, got: %s", out)
+	}
+
+	tr.runCLISuccess("-n", "2", "-stash", "-stash-untracked=false", "-y")
+	if count := tr.commitCount(); count != 2 {
+		t.Fatalf("expected 2 commits after squash, got %d", count)
+	}
+	status := tr.git(t.Context(), "status", "--porcelain")
+	if !strings.Contains(status, "M file.txt") || !strings.Contains(status, "?? artifact.bin") {
+		t.Errorf("expected tracked change restored and untracked file untouched, got: %q", status)
+	}
+
+	// Only untracked files: nothing needs to be stashed at all
+	tr.git(t.Context(), "checkout", "--", "file.txt")
+	tr.createCommitsWithMessages("d")
+	out = tr.runCLISuccess("-n", "2", "-stash", "-stash-untracked=false", "-y")
+	if strings.Contains(out, "Stashed") {
+		t.Errorf("expected no stash for untracked-only changes, got: %s", out)
+	}
+	if stashes := tr.git(t.Context(), "stash", "list"); stashes != "" {
+		t.Errorf("expected no stash entries, got: %s", stashes)
+	}
+}
+
+// TestCLI_StashAllIncludesIgnoredFiles tests that -stash-all stashes ignored files with -a
+func TestCLI_StashAllIncludesIgnoredFiles(t *testing.T) {
+	tr := newTestRepo(t)
+	tr.writeFile(".gitignore", "*.log\n")
+	tr.git(t.Context(), "add", ".gitignore")
+	tr.git(t.Context(), "commit", "-m", "ignore logs")
+	tr.createCommitsWithMessages("a", "b")
+	tr.writeFile("file.txt", "edited")
+	tr.writeFile("debug.log", "ignored")
+
+	out := tr.runCLISuccess("-n", "2", "-stash", "-stash-all", "-dry-run")
+	if !strings.Contains(out, "git stash push -a -m") {
+		t.Errorf("expected stash command with -a in dry-run, got: %s", out)
+	}
+
+	tr.runCLISuccess("-n", "2", "-stash", "-stash-all", "-y")
+	if data, err := os.ReadFile(filepath.Join(tr.Dir, "debug.log")); err != nil || string(data) != "ignored" {
+		t.Errorf("expected ignored file to be restored, got %q, %v", data, err)
+	}
+	if stashes := tr.git(t.Context(), "stash", "list"); stashes != "" {
+		t.Errorf("expected the stash to be dropped, got: %s", stashes)
+	}
+}
+
+// TestCLI_RefusesWithLeftoverAutoStash tests that an auto-stash left by an earlier run blocks the squash
+func TestCLI_RefusesWithLeftoverAutoStash(t *testing.T) {
+	tr := newTestRepo(t)
+	tr.createCommitsWithMessages("a", "b", "c")
+	tr.writeFile("mine.txt", "user stash")
+	tr.git(t.Context(), "stash", "push", "-u", "-m", "my own work")
+
+	// Stashes made by the user don't matter
+	tr.runCLISuccess("-n", "2", "-dry-run")
+
+	tr.writeFile("lost.txt", "never restored")
+	tr.git(t.Context(), "stash", "push", "-u", "-m", "locsquash auto-stash")
+	tr.createCommitsWithMessages("d")
+
+	out := tr.runCLIFailure("-n", "2", "-y")
+	if !strings.Contains(out, "stash@{0} holds an auto-stash") || !strings.Contains(out, "git stash show -p stash@{0}") {
+		t.Errorf("expected leftover auto-stash error, got: %s", out)
+	}
+	if count := tr.commitCount(); count != 4 {
+		t.Fatalf("expected no squash, got %d commits", count)
+	}
+
+	out = tr.runCLISuccess("-n", "2", "-dry-run")
+	if !strings.Contains(out, "Warning: stash@{0} holds an auto-stash") {
+		t.Errorf("expected a warning in dry-run, got: %s", out)
+	}
+
+	tr.runCLISuccess("-n", "2", "-y", "-force")
+	if count := tr.commitCount(); count != 3 {
+		t.Errorf("expected squash with -force, got %d commits", count)
+	}
+}
+
+// TestCLI_AutoStashMessageMatchesDryRun tests that the real auto-stash carries the documented
+// "locsquash auto-stash" message, the same one the dry-run prints
+func TestCLI_AutoStashMessageMatchesDryRun(t *testing.T) {
+	tr := newTestRepo(t)
+	tr.createCommitsWithMessages("a", "b", "c")
+	tr.git(t.Context(), "config", "gpg.program", "false")
+	tr.writeFile("dirty.txt", "uncommitted")
+
+	out := tr.runCLISuccess("-n", "2", "-stash", "-dry-run")
+	if !strings.Contains(out, `git stash push -u -m "locsquash auto-stash"`) {
+		t.Errorf("expected documented stash command in dry-run, got: %s", out)
+	}
+
+	// A failing commit with -no-auto-recover leaves the auto-stash in place for inspection
+	tr.runCLIFailure("-n", "2", "-stash", "-sign", "-y", "-no-auto-recover")
+	if stashes := tr.git(t.Context(), "stash", "list", "--format=%gs"); stashes != "On work: locsquash auto-stash" {
+		t.Errorf("expected the auto-stash to be named \"locsquash auto-stash\", got: %q", stashes)
+	}
+}
+
+// TestCLI_PrintsResultCommit tests that the success summary names the new commit unless -quiet is set
+func TestCLI_PrintsResultCommit(t *testing.T) {
+	tr := newTestRepo(t)
+	tr.createCommitsWithMessages("a", "b", "c", "d", "e")
+
+	out := tr.runCLISuccess("-n", "2", "-y")
+	head := tr.git(t.Context(), "rev-parse", "HEAD")
+	if !strings.Contains(out, "Squashed commit: "+head) {
+		t.Errorf("expected the new commit hash %s in the summary, got: %s", head, out)
+	}
+
+	stdout, _, err := tr.runCLISplit("-n", "2", "-y", "-quiet")
+	if err != nil {
+		t.Fatalf("CLI failed unexpectedly: %v", err)
+	}
+	if strings.Contains(stdout, "Squashed commit") {
+		t.Errorf("expected no summary with -quiet, got: %s", stdout)
+	}
+
+	stdout, _, err = tr.runCLISplit("-n", "2", "-y", "-quiet", "-json")
+	if err != nil {
+		t.Fatalf("CLI failed unexpectedly: %v", err)
+	}
+	var summary jsonSummary
+	if err = json.Unmarshal([]byte(stdout), &summary); err != nil {
+		t.Fatalf("stdout is not valid JSON: %v\n%s", err, stdout)
+	}
+	if head = tr.git(t.Context(), "rev-parse", "HEAD"); summary.ResultCommit != head {
+		t.Errorf("expected result_commit %s with -quiet -json, got %q", head, summary.ResultCommit)
+	}
+}
+
+// TestCLI_BranchSquashesWithoutCheckout tests that -branch rewrites another branch while
+// leaving the current checkout alone, and that -recover-last moves it back
+func TestCLI_BranchSquashesWithoutCheckout(t *testing.T) {
+	tr := newTestRepo(t)
+	tr.createCommitsWithMessages("a", "b")
+	tr.git(t.Context(), "checkout", "-q", "-b", "feature")
+	tr.createCommitsWithMessages("c", "d", "e")
+	tr.git(t.Context(), "checkout", "-q", "work")
+	tr.writeFile("file.txt", "uncommitted\n")
+	head := tr.git(t.Context(), "rev-parse", "HEAD")
+	tip := tr.git(t.Context(), "rev-parse", "feature")
+
+	out := tr.runCLISuccess("-branch", "feature", "-n", "3", "-y", "-m", "feature work")
+	if !strings.Contains(out, "Successfully squashed the last 3 commits of feature.") {
+		t.Errorf("expected success message naming the branch, got: %s", out)
+	}
+	if count := tr.git(t.Context(), "rev-list", "--count", "feature"); count != "3" {
+		t.Errorf("expected 3 commits on feature after squash, got %s", count)
+	}
+	if msg := tr.git(t.Context(), "log", "-1", "--format=%s", "feature"); msg != "feature work" {
+		t.Errorf("unexpected commit message on feature: %q", msg)
+	}
+	if tree := tr.git(t.Context(), "rev-parse", "feature^{tree}"); tree != tr.git(t.Context(), "rev-parse", tip+"^{tree}") {
+		t.Error("expected the squashed commit to keep the tree of the old tip")
+	}
+	if got := tr.git(t.Context(), "rev-parse", "HEAD"); got != head {
+		t.Errorf("expected HEAD to stay at %s, got %s", head, got)
+	}
+	if branch := tr.git(t.Context(), "branch", "--show-current"); branch != "work" {
+		t.Errorf("expected work to stay checked out, got %s", branch)
+	}
+	if status := tr.git(t.Context(), "status", "--porcelain"); status != "M file.txt" {
+		t.Errorf("expected the uncommitted change to be untouched, got: %q", status)
+	}
+	if backups := tr.git(t.Context(), "branch", "--list", "locsquash/backup-*", "--format=%(objectname)"); backups != tip {
+		t.Errorf("expected a backup at the old tip %s, got: %q", tip, backups)
+	}
+
+	tr.runCLISuccess("-recover-last", "-y")
+	if got := tr.git(t.Context(), "rev-parse", "feature"); got != tip {
+		t.Errorf("expected -recover-last to move feature back to %s, got %s", tip, got)
+	}
+	if got := tr.git(t.Context(), "rev-parse", "HEAD"); got != head {
+		t.Errorf("expected HEAD to stay at %s after recovery, got %s", head, got)
+	}
+}
+
+// TestCLI_BranchRecoverLastRefusesMovedBranch tests that -recover-last leaves a -branch branch
+// alone once it moved past the squashed commit, even when the backup is gone
+func TestCLI_BranchRecoverLastRefusesMovedBranch(t *testing.T) {
+	tr := newTestRepo(t)
+	tr.createCommitsWithMessages("a", "b")
+	tr.git(t.Context(), "checkout", "-q", "-b", "feature")
+	tr.createCommitsWithMessages("c", "d", "e")
+	tr.git(t.Context(), "checkout", "-q", "work")
+
+	tr.runCLISuccess("-branch", "feature", "-n", "3", "-keep-backup-on-success=false", "-y", "-m", "feature work")
+	tr.git(t.Context(), "checkout", "-q", "feature")
+	tr.createCommit("later work")
+	tr.git(t.Context(), "checkout", "-q", "work")
+	later := tr.git(t.Context(), "rev-parse", "feature")
+
+	out := tr.runCLIFailure("-recover-last", "-y")
+	if !strings.Contains(out, "discard the commits made since") {
+		t.Errorf("expected recovery to be refused, got: %s", out)
+	}
+	if got := tr.git(t.Context(), "rev-parse", "feature"); got != later {
+		t.Errorf("expected feature to stay at %s, got %s", later, got)
+	}
+}
+
+// TestCLI_BranchRecoveryInstructions tests that -print-recovery for -branch moves the branch, not HEAD
+func TestCLI_BranchRecoveryInstructions(t *testing.T) {
+	tr := newTestRepo(t)
+	tr.createCommitsWithMessages("a", "b")
+	tr.git(t.Context(), "branch", "feature")
+	tr.git(t.Context(), "checkout", "-q", "feature")
+	tr.createCommitsWithMessages("c", "d")
+	tr.git(t.Context(), "checkout", "-q", "work")
+
+	out := tr.runCLISuccess("-branch", "feature", "-n", "2", "-print-recovery")
+	if !strings.Contains(out, "git branch -f feature locsquash/backup-") {
+		t.Errorf("expected recovery to move feature back to the backup, got: %s", out)
+	}
+	if strings.Contains(out, "git reset --hard") {
+		t.Errorf("expected no reset of the current checkout, got: %s", out)
+	}
+
+	out = tr.runCLISuccess("-branch", "feature", "-n", "2", "-dry-run")
+	if !strings.Contains(out, "git update-ref refs/heads/feature <squashed-commit>") {
+		t.Errorf("expected the dry run to plan an update-ref, got: %s", out)
+	}
+}
+
+// TestCLI_BranchRefusals tests that -branch rejects missing branches and branches checked out elsewhere
+func TestCLI_BranchRefusals(t *testing.T) {
+	tr := newTestRepo(t)
+	tr.createCommitsWithMessages("a", "b", "c")
+
+	out, code := tr.runCLIExitCode("-branch", "nope", "-n", "2", "-y")
+	if code != 2 || !strings.Contains(out, `branch "nope" does not exist`) {
+		t.Errorf("expected usage error for a missing branch, got exit %d: %s", code, out)
+	}
+
+	tr.git(t.Context(), "branch", "feature")
+	worktree := filepath.Join(t.TempDir(), "wt")
+	tr.git(t.Context(), "worktree", "add", "-q", worktree, "feature")
+	tip := tr.git(t.Context(), "rev-parse", "feature")
+
+	out = tr.runCLIFailure("-branch", "feature", "-n", "2", "-y")
+	if !strings.Contains(out, "checked out in the worktree") {
+		t.Errorf("expected refusal for a branch checked out in another worktree, got: %s", out)
+	}
+	if got := tr.git(t.Context(), "rev-parse", "feature"); got != tip {
+		t.Errorf("expected feature to be unchanged, got %s", got)
+	}
+
+	out, code = tr.runCLIExitCode("-branch", "feature", "-onto", "HEAD~1", "-n", "2")
+	if code != 2 || !strings.Contains(out, "-branch cannot be combined") {
+		t.Errorf("expected usage error for -branch with -onto, got exit %d: %s", code, out)
+	}
+}
+
+// TestCLI_PrintsChangeSummary tests that a successful squash reports the files and lines it changed
+func TestCLI_PrintsChangeSummary(t *testing.T) {
+	tr := newTestRepo(t)
+	tr.createCommitsWithMessages("a", "b")
+	tr.createCommitAs("Test User <test@test.local>", "extra file")
+	tr.createCommit("c")
+
+	out := tr.runCLISuccess("-n", "3", "-y")
+	if !strings.Contains(out, "Changes: 2 files changed, 3 insertions(+), 0 deletions(-)") {
+		t.Errorf("expected change summary, got: %s", out)
+	}
+
+	tr.createCommitsWithMessages("d", "e")
+	stdout, _, err := tr.runCLISplit("-n", "2", "-y", "-quiet")
+	if err != nil {
+		t.Fatalf("CLI failed unexpectedly: %v", err)
+	}
+	if strings.Contains(stdout, "Changes:") {
+		t.Errorf("expected no change summary with -quiet, got: %s", stdout)
+	}
+
+	tr.createCommitsWithMessages("f", "g")
+	stdout, _, err = tr.runCLISplit("-n", "2", "-y", "-json")
+	if err != nil {
+		t.Fatalf("CLI failed unexpectedly: %v", err)
+	}
+	var summary jsonSummary
+	if err = json.Unmarshal([]byte(stdout), &summary); err != nil {
+		t.Fatalf("stdout is not valid JSON: %v\n%s", err, stdout)
+	}
+	if summary.Stats == nil || summary.Stats.FilesChanged != 1 || summary.Stats.Insertions != 2 || summary.Stats.Deletions != 0 {
+		t.Errorf("expected stats for 1 file and 2 insertions, got %+v", summary.Stats)
+	}
+}
+
+// TestCLI_MessagePlaceholders tests that -m placeholders are expanded from the squashed range
+func TestCLI_MessagePlaceholders(t *testing.T) {
+	tr := newTestRepo(t)
+	tr.createCommitsWithMessages("base", "one", "two", "three")
+	oldest := tr.git(t.Context(), "rev-parse", "--short", "HEAD~2")
+	newest := tr.git(t.Context(), "rev-parse", "--short", "HEAD")
+
+	tr.runCLISuccess("-n", "3", "-y", "-date", "2024-06-01T12:00:00Z", "-m", "Squash {count} commits ({date}) {oldest}..{newest} {other}")
+	want := "Squash 3 commits (2024-06-01) " + oldest + ".." + newest + " {other}"
+	if msg := tr.lastCommitMessage(); msg != want {
+		t.Errorf("expected %q, got %q", want, msg)
+	}
+}
+
+// TestCLI_NonDefaultInitialBranch tests the branch-aware checks on a repository initialized
+// with -b trunk, including a tag that shares the branch's name
+func TestCLI_NonDefaultInitialBranch(t *testing.T) {
+	tr := newTestRepoOnBranch(t, "trunk")
+	tr.createCommitsWithMessages("a", "b", "c", "d")
+	tr.git(t.Context(), "tag", "trunk", "HEAD~2")
+
+	out := tr.runCLIFailure("-n", "2", "-y", "-protected", "trunk")
+	if !strings.Contains(out, `branch "trunk" is protected`) {
+		t.Errorf("expected trunk to be protected by -protected, got: %s", out)
+	}
+
+	tr.runCLISuccess("-n", "2", "-y")
+	if count := tr.commitCount(); count != 3 {
+		t.Errorf("expected 3 commits after squash, got %d", count)
+	}
+	if branch := tr.git(t.Context(), "branch", "--show-current"); branch != "trunk" {
+		t.Errorf("expected trunk to stay checked out, got %s", branch)
+	}
+
+	// The manifest must name the branch exactly, or -recover-last would refuse
+	tr.runCLISuccess("-recover-last", "-y")
+	if count := tr.commitCount(); count != 4 {
+		t.Errorf("expected 4 commits after -recover-last, got %d", count)
+	}
+
+	// -branch treats trunk as the current branch and squashes it the usual way
+	tr.runCLISuccess("-branch", "trunk", "-n", "2", "-y")
+	if count := tr.commitCount(); count != 3 {
+		t.Errorf("expected 3 commits after -branch trunk, got %d", count)
+	}
+}
+
+// TestCLI_ShowsRelativeCommitDate tests that prose output shows the squashed commit's date with
+// its age, while the dry-run command keeps the exact ISO value
+func TestCLI_ShowsRelativeCommitDate(t *testing.T) {
+	tr := newTestRepo(t)
+	tr.createCommitsWithMessages("a", "b", "c")
+
+	out := tr.runCLISuccess("-n", "2", "-dry-run", "-date", "2020-01-02T03:04:05Z")
+	if !strings.Contains(out, "Result commit date: 2020-01-02T03:04:05Z (") || !strings.Contains(out, "years ago)") {
+		t.Errorf("expected the result date with its age in the commit list, got: %s", out)
+	}
+	if !strings.Contains(out, "GIT_COMMITTER_DATE=2020-01-02T03:04:05Z git commit") {
+		t.Errorf("expected the exact date in the dry-run command, got: %s", out)
+	}
+
+	out = tr.runCLISuccess("-n", "2", "-y")
+	if !strings.Contains(out, "Commit date: ") || (!strings.Contains(out, "ago)") && !strings.Contains(out, "(just now)")) {
+		t.Errorf("expected the commit date with its age in the summary, got: %s", out)
+	}
+}
+
+// TestCLI_StatShowsLineCountsPerCommit tests that -stat adds insertions and deletions to the
+// commit list, and that -json reports them per commit
+func TestCLI_StatShowsLineCountsPerCommit(t *testing.T) {
+	tr := newTestRepo(t)
+	tr.createCommit("base")
+	tr.writeFile("big.txt", strings.Repeat("line\n", 12))
+	tr.git(t.Context(), "add", ".")
+	tr.git(t.Context(), "commit", "-m", "big")
+	tr.git(t.Context(), "commit", "--allow-empty", "-m", "empty")
+	tr.createCommit("small")
+
+	out := tr.runCLISuccess("-n", "3", "-dry-run", "-stat")
+	for _, want := range []string{"+12 -0  big", " +0 -0  empty", " +1 -0  small"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("expected %q in the commit list, got: %s", want, out)
+		}
+	}
+	if out = tr.runCLISuccess("-n", "3", "-dry-run"); strings.Contains(out, "+12") {
+		t.Errorf("expected no line counts without -stat, got: %s", out)
+	}
+
+	stdout, _, err := tr.runCLISplit("-n", "3", "-dry-run", "-json")
+	if err != nil {
+		t.Fatalf("CLI failed unexpectedly: %v", err)
+	}
+	var summary jsonSummary
+	if err = json.Unmarshal([]byte(stdout), &summary); err != nil {
+		t.Fatalf("stdout is not valid JSON: %v\n%s", err, stdout)
+	}
+	if len(summary.Commits) != 3 || summary.Commits[2].Additions != 12 || summary.Commits[1].Additions != 0 || summary.Commits[0].Additions != 1 {
+		t.Errorf("unexpected per-commit additions: %+v", summary.Commits)
+	}
+}
+
+// TestCLI_CommitListEscapesControlCharacters tests that subjects and authors with escape
+// sequences, tabs or wide characters cannot mangle the terminal or the column alignment
+func TestCLI_CommitListEscapesControlCharacters(t *testing.T) {
+	tr := newTestRepo(t)
+	tr.createCommit("base")
+	tr.createCommitAs("日本 太郎 <taro@test.local>", "wide author")
+	tr.createCommit("evil \x1b[2Jclear\tand tab")
+	tr.createCommitAs("Ann <ann@test.local>", "rocket 🚀")
+
+	out := tr.runCLISuccess("-n", "3", "-dry-run", "-color=never")
+	if strings.Contains(out, "\x1b") || strings.Contains(out, "\t") {
+		t.Errorf("expected control characters to be escaped, got: %q", out)
+	}
+	if !strings.Contains(out, `evil \x1b[2Jclear\tand tab`) {
+		t.Errorf("expected the escaped subject in the commit list, got: %s", out)
+	}
+	// The subject column starts at the same terminal column on every line
+	var columns []int
+	for line := range strings.SplitSeq(out, "\n") {
+		for _, subject := range []string{"wide author", "evil ", "rocket "} {
+			if i := strings.Index(line, subject); i >= 0 && strings.HasPrefix(line, "  ") {
+				columns = append(columns, runewidth.StringWidth(line[:i]))
+			}
+		}
+	}
+	if len(columns) != 3 || columns[0] != columns[1] || columns[1] != columns[2] {
+		t.Errorf("expected aligned subjects, got columns %v in: %s", columns, out)
+	}
+}
+
+// TestCLI_RewordAmendsOnlyTheMessage tests that -reword rewrites HEAD's message while keeping
+// its tree, parent and dates, with a backup that -recover-last restores
+func TestCLI_RewordAmendsOnlyTheMessage(t *testing.T) {
+	tr := newTestRepo(t)
+	tr.createCommitsWithMessages("a", "b")
+	tr.git(t.Context(), "commit", "--amend", "-q", "-m", "b", "--date", "2020-01-02T03:04:05Z")
+	before := tr.git(t.Context(), "log", "-1", "--format=%T %P %aI %an")
+	oldHead := tr.git(t.Context(), "rev-parse", "HEAD")
+
+	out := tr.runCLISuccess("-reword", "-m", "better message", "-dry-run")
+	if !strings.Contains(out, "git commit --amend") || !strings.Contains(out, "The following commit will be reworded") {
+		t.Errorf("expected the dry run to plan an amend, got: %s", out)
+	}
+
+	out = tr.runCLISuccess("-reword", "-m", "better message", "-y")
+	if !strings.Contains(out, "Successfully reworded the latest commit.") {
+		t.Errorf("expected reword success message, got: %s", out)
+	}
+	if count := tr.commitCount(); count != 2 {
+		t.Errorf("expected 2 commits after reword, got %d", count)
+	}
+	if msg := tr.lastCommitMessage(); msg != "better message" {
+		t.Errorf("expected the new message, got %q", msg)
+	}
+	if after := tr.git(t.Context(), "log", "-1", "--format=%T %P %aI %an"); after != before {
+		t.Errorf("expected tree, parent, author date and author to be kept: before %q, after %q", before, after)
+	}
+	if backups := tr.git(t.Context(), "branch", "--list", "locsquash/backup-*", "--format=%(objectname)"); backups != oldHead {
+		t.Errorf("expected a backup at the old HEAD %s, got %q", oldHead, backups)
+	}
+
+	tr.runCLISuccess("-recover-last", "-y")
+	if msg := tr.lastCommitMessage(); msg != "b" {
+		t.Errorf("expected -recover-last to restore the old message, got %q", msg)
+	}
+
+	out, code := tr.runCLIExitCode("-reword", "-n", "2", "-m", "x", "-y")
+	if code != 2 || !strings.Contains(out, "-reword only rewrites") {
+		t.Errorf("expected usage error for -reword with -n, got exit %d: %s", code, out)
+	}
+}
+
+// TestCLI_AmendIntoBaseKeepsTheBaseCommit tests that -amend-into-base folds the selected commits
+// into the commit below them, keeping its message, author and dates, and that it can be undone
+func TestCLI_AmendIntoBaseKeepsTheBaseCommit(t *testing.T) {
+	tr := newTestRepo(t)
+	tr.createCommitsWithMessages("root", "base")
+	tr.git(t.Context(), "commit", "--amend", "-q", "-m", "base", "--date", "2020-01-02T03:04:05Z")
+	tr.createCommitsWithMessages("c", "d")
+	before := tr.git(t.Context(), "log", "-1", "--format=%P %aI %an", "HEAD~2")
+	tree := tr.git(t.Context(), "rev-parse", "HEAD^{tree}")
+
+	out := tr.runCLISuccess("-n", "2", "-amend-into-base", "-dry-run")
+	if !strings.Contains(out, "folded into their base commit HEAD~2") || !strings.Contains(out, "git commit --amend") {
+		t.Errorf("expected the dry run to plan an amend of the base commit, got: %s", out)
+	}
+	if !strings.Contains(out, "also rewrites the base commit") {
+		t.Errorf("expected the recovery section to mention the rewritten base, got: %s", out)
+	}
+
+	out = tr.runCLISuccess("-n", "2", "-amend-into-base", "-y")
+	if !strings.Contains(out, "Amended base commit:") {
+		t.Errorf("expected the amended base commit in the summary, got: %s", out)
+	}
+	if count := tr.commitCount(); count != 2 {
+		t.Errorf("expected 2 commits after folding, got %d", count)
+	}
+	if msg := tr.lastCommitMessage(); msg != "base" {
+		t.Errorf("expected the base commit's message, got %q", msg)
+	}
+	if after := tr.git(t.Context(), "log", "-1", "--format=%P %aI %an"); after != before {
+		t.Errorf("expected parent, author date and author of the base to be kept: before %q, after %q", before, after)
+	}
+	if got := tr.git(t.Context(), "rev-parse", "HEAD^{tree}"); got != tree {
+		t.Errorf("expected the tree of the old HEAD, got %s want %s", got, tree)
+	}
+
+	tr.runCLISuccess("-recover-last", "-y")
+	if count := tr.commitCount(); count != 4 {
+		t.Errorf("expected -recover-last to restore 4 commits, got %d", count)
+	}
+}
+
+// TestCLI_SquashMergesOnlyKeepsTheMerge tests that -squash-merges-only folds the follow-ups of the
+// newest merge into it without flattening its second parent
+func TestCLI_SquashMergesOnlyKeepsTheMerge(t *testing.T) {
+	tr := newTestRepo(t)
+	tr.createCommitsWithMessages("root", "base")
+
+	out := tr.runCLIFailure("-squash-merges-only", "-y")
+	if !strings.Contains(out, "found no merge commit") {
+		t.Errorf("expected an error without a merge, got: %s", out)
+	}
+
+	tr.createMerge("feature")
+	out = tr.runCLIFailure("-squash-merges-only", "-y")
+	if !strings.Contains(out, "no follow-up commits") {
+		t.Errorf("expected an error when HEAD is the merge, got: %s", out)
+	}
+
+	tr.createCommitsWithMessages("fix lint", "fix test")
+	parents := tr.git(t.Context(), "log", "-1", "--format=%P", "HEAD~2")
+	tree := tr.git(t.Context(), "rev-parse", "HEAD^{tree}")
+
+	out = tr.runCLISuccess("-squash-merges-only", "-dry-run")
+	if !strings.Contains(out, "2 commit(s) will be folded into the merge commit HEAD~2") || !strings.Contains(out, "both of its parents") {
+		t.Errorf("expected the dry run to plan an amend of the merge, got: %s", out)
+	}
+
+	out = tr.runCLISuccess("-squash-merges-only", "-y")
+	if !strings.Contains(out, "Amended merge commit:") {
+		t.Errorf("expected the amended merge in the summary, got: %s", out)
+	}
+	if got := tr.git(t.Context(), "log", "-1", "--format=%P"); got != parents || len(strings.Fields(got)) != 2 {
+		t.Errorf("expected the merge's parents %q to be kept, got %q", parents, got)
+	}
+	if msg := tr.lastCommitMessage(); msg != "merge feature" {
+		t.Errorf("expected the merge's message, got %q", msg)
+	}
+	if got := tr.git(t.Context(), "rev-parse", "HEAD^{tree}"); got != tree {
+		t.Errorf("expected the tree of the old HEAD, got %s want %s", got, tree)
+	}
+
+	tr.runCLISuccess("-recover-last", "-y")
+	if msg := tr.lastCommitMessage(); msg != "fix test" {
+		t.Errorf("expected -recover-last to restore the follow-ups, got HEAD %q", msg)
+	}
+}
+
+// TestCLI_SquashWIPFoldsTrailingWIPCommits tests that -squash-wip folds only the WIP commits at the tip
+// into the first real commit below them
+func TestCLI_SquashWIPFoldsTrailingWIPCommits(t *testing.T) {
+	tr := newTestRepo(t)
+	tr.createCommitsWithMessages("base", "wip early", "feat: real work")
+
+	out := tr.runCLISuccess("-squash-wip", "-y")
+	if !strings.Contains(out, "Nothing to squash") {
+		t.Errorf("expected nothing to do when HEAD is not WIP, got: %s", out)
+	}
+
+	tr.createCommitsWithMessages("wip: one", "WIP two", "tmp")
+	tree := tr.git(t.Context(), "rev-parse", "HEAD^{tree}")
+	out = tr.runCLISuccess("-squash-wip", "-y")
+	if !strings.Contains(out, "Successfully folded the last 3 commit(s)") {
+		t.Errorf("expected the three WIP commits to be folded, got: %s", out)
+	}
+	if msg := tr.lastCommitMessage(); msg != "feat: real work" {
+		t.Errorf("expected the real commit's message to be kept, got %q", msg)
+	}
+	if count := tr.commitCount(); count != 3 {
+		t.Errorf("expected the older WIP commit below the real one to stay, got %d commits", count)
+	}
+	if got := tr.git(t.Context(), "rev-parse", "HEAD^{tree}"); got != tree {
+		t.Errorf("expected the tree of the old HEAD")
+	}
+
+	tr.createCommitsWithMessages("draft: x")
+	out = tr.runCLISuccess("-squash-wip", "-wip-pattern", "^draft:", "-y")
+	if !strings.Contains(out, "Successfully folded the last 1 commit(s)") || tr.commitCount() != 3 {
+		t.Errorf("expected -wip-pattern to select the draft commit, got: %s", out)
+	}
+}
+
+// TestCLI_AutofixupFoldsFixupCommits tests that -autofixup folds fixup! and squash! commits into
+// the commits they name, keeping the final tree and a backup
+func TestCLI_AutofixupFoldsFixupCommits(t *testing.T) {
+	tr := newTestRepo(t)
+	// Each commit touches its own file, so the reordering rebase cannot conflict
+	for _, msg := range []string{"base", "add parser", "add lexer", "fixup! add parser", "squash! add lexer", "add docs"} {
+		tr.createCommitAs("Test User <test@test.local>", msg)
+	}
+	tree := tr.git(t.Context(), "rev-parse", "HEAD^{tree}")
+	oldHead := tr.git(t.Context(), "rev-parse", "HEAD")
+
+	out := tr.runCLISuccess("-autofixup", "-dry-run")
+	if !strings.Contains(out, "GIT_SEQUENCE_EDITOR=true GIT_EDITOR=true git rebase -i --autosquash") {
+		t.Errorf("expected the dry run to plan an autosquash rebase, got: %s", out)
+	}
+	if got := tr.git(t.Context(), "rev-parse", "HEAD"); got != oldHead {
+		t.Fatal("dry run must not change HEAD")
+	}
+	out = tr.runCLISuccess("-autofixup", "-S", "ABCDEF12", "-dry-run")
+	if !strings.Contains(out, "git rebase -i --autosquash --gpg-sign=ABCDEF12 ") {
+		t.Errorf("expected -S to be passed to the rebase, got: %s", out)
+	}
+
+	out = tr.runCLISuccess("-autofixup", "-y")
+	if !strings.Contains(out, "Successfully folded 2 fixup commit(s).") {
+		t.Errorf("expected fold success message, got: %s", out)
+	}
+	if subjects := tr.git(t.Context(), "log", "--format=%s"); subjects != "add docs\nadd lexer\nadd parser\nbase" {
+		t.Errorf("unexpected history after -autofixup:\n%s", subjects)
+	}
+	if got := tr.git(t.Context(), "rev-parse", "HEAD^{tree}"); got != tree {
+		t.Errorf("expected the final tree to be unchanged")
+	}
+	if backups := tr.git(t.Context(), "branch", "--list", "locsquash/backup-*", "--format=%(objectname)"); backups != oldHead {
+		t.Errorf("expected a backup at the old HEAD %s, got %q", oldHead, backups)
+	}
+
+	out = tr.runCLISuccess("-autofixup", "-y")
+	if !strings.Contains(out, "No fixup! or squash! commits to fold.") {
+		t.Errorf("expected a clean exit without fixup commits, got: %s", out)
+	}
+
+	out, code := tr.runCLIExitCode("-autofixup", "-n", "2")
+	if code != 2 || !strings.Contains(out, "-autofixup finds its own commits") {
+		t.Errorf("expected usage error for -autofixup with -n, got exit %d: %s", code, out)
+	}
+	for _, args := range [][]string{{"-author", "Jane <jane@example.com>"}, {"-no-verify"}, {"-signoff"}} {
+		out, code = tr.runCLIExitCode(append([]string{"-autofixup", "-y"}, args...)...)
+		if code != 2 || !strings.Contains(out, "-autofixup replays the commits") {
+			t.Errorf("expected usage error for -autofixup with %v, got exit %d: %s", args, code, out)
+		}
+	}
+}
+
+// TestCLI_AutofixupSkipsPushedCommits tests that -autofixup never rewrites commits already on a remote
+func TestCLI_AutofixupSkipsPushedCommits(t *testing.T) {
+	tr := newTestRepo(t)
+	tr.createCommitsWithMessages("base", "add parser", "fixup! add parser")
+	tr.addRemote()
+	tr.createCommit("fixup! add parser")
+	head := tr.git(t.Context(), "rev-parse", "HEAD")
+
+	out := tr.runCLISuccess("-autofixup", "-y")
+	if !strings.Contains(out, "matches no earlier unpushed commit") || !strings.Contains(out, "No fixup! or squash! commits to fold.") {
+		t.Errorf("expected the fixup of a pushed commit to be left alone, got: %s", out)
+	}
+	if got := tr.git(t.Context(), "rev-parse", "HEAD"); got != head {
+		t.Errorf("expected HEAD to be unchanged, got %s", got)
+	}
+}
+
+// TestCLI_DryRunIncludesRecovery tests that a dry run ends with the recovery instructions, in a
+// separate section, and prints them only once when -print-recovery is also given
+func TestCLI_DryRunIncludesRecovery(t *testing.T) {
+	tr := newTestRepo(t)
+	tr.createCommitsWithMessages("a", "b", "c")
+
+	for _, args := range [][]string{{"-n", "2", "-dry-run"}, {"-n", "2", "-dry-run", "-print-recovery"}} {
+		out := tr.runCLISuccess(args...)
+		end := strings.Index(out, "# End of dry run")
+		recovery := strings.Index(out, "# Recovery instructions")
+		if end < 0 || recovery < end {
+			t.Errorf("%v: expected the recovery section after the dry run, got: %s", args, out)
+		}
+		if n := strings.Count(out, "# Recovery instructions"); n != 1 {
+			t.Errorf("%v: expected the recovery section once, got %d times", args, n)
+		}
+		if !strings.Contains(out[recovery:], "git reset --hard locsquash/backup-") {
+			t.Errorf("%v: expected the reset command in the recovery section, got: %s", args, out)
+		}
+	}
+}
+
+// TestCLI_AssumeYesEnv tests that LOCSQUASH_ASSUME_YES answers the prompt unless -yes is given explicitly
+func TestCLI_AssumeYesEnv(t *testing.T) {
+	tr := newTestRepo(t)
+	tr.createCommitsWithMessages("a", "b", "c", "d")
+
+	if out, err := tr.runCLIWithEnv([]string{"LOCSQUASH_ASSUME_YES=1"}, "-n", "2"); err != nil {
+		t.Fatalf("expected LOCSQUASH_ASSUME_YES=1 to skip the prompt: %v\n%s", err, out)
+	}
+	if count := tr.commitCount(); count != 3 {
+		t.Errorf("expected 3 commits after squash, got %d", count)
+	}
+
+	// An explicit -yes=false wins, so the prompt is shown and fails without a terminal
+	out, err := tr.runCLIWithEnv([]string{"LOCSQUASH_ASSUME_YES=1"}, "-n", "2", "-yes=false")
+	if err == nil || !strings.Contains(out, "stdin is not a terminal") {
+		t.Errorf("expected the prompt with -yes=false, got err %v: %s", err, out)
+	}
+
+	out, err = tr.runCLIWithEnv([]string{"LOCSQUASH_ASSUME_YES=sure"}, "-n", "2")
+	if err == nil || !strings.Contains(out, "invalid LOCSQUASH_ASSUME_YES value") {
+		t.Errorf("expected an error for an invalid value, got err %v: %s", err, out)
+	}
+	if count := tr.commitCount(); count != 3 {
+		t.Errorf("expected no further squash, got %d commits", count)
+	}
+}
+
+// TestCLI_RefusesRangePastFirstParentRoot tests that a range reaching past the root of the
+// first-parent history is refused up front, even though a merged-in second root makes the
+// total commit count large enough
+func TestCLI_RefusesRangePastFirstParentRoot(t *testing.T) {
+	tr := newTestRepo(t)
+	tr.createCommitsWithMessages("a", "b")
+	tr.git(t.Context(), "checkout", "-q", "--orphan", "other")
+	tr.git(t.Context(), "rm", "-q", "-rf", ".")
+	tr.createCommitAs("Test User <test@test.local>", "x")
+	tr.createCommitAs("Test User <test@test.local>", "y")
+	tr.createCommitAs("Test User <test@test.local>", "z")
+	tr.git(t.Context(), "checkout", "-q", "work")
+	tr.git(t.Context(), "merge", "-q", "--allow-unrelated-histories", "-m", "merge other", "other")
+	head := tr.git(t.Context(), "rev-parse", "HEAD")
+
+	out := tr.runCLIFailure("-n", "3", "-y", "-allow-merges")
+	if !strings.Contains(out, "HEAD~3 does not exist: HEAD has only 3 commits along its first parents, so at most 2 can be squashed") {
+		t.Errorf("expected a clear error about the first-parent root, got: %s", out)
+	}
+	if got := tr.git(t.Context(), "rev-parse", "HEAD"); got != head {
+		t.Errorf("expected HEAD to be unchanged, got %s", got)
+	}
+	if branches := tr.git(t.Context(), "branch", "--list", "locsquash/backup-*"); branches != "" {
+		t.Errorf("expected no backup to be created, got %q", branches)
+	}
+
+	tr.runCLISuccess("-n", "2", "-y", "-allow-merges", "-m", "squashed")
+	if count := tr.git(t.Context(), "rev-list", "--count", "--first-parent", "HEAD"); count != "2" {
+		t.Errorf("expected 2 first-parent commits after squashing 2, got %s", count)
+	}
+}
+
+// TestCLI_PushBackupToRemote tests that -push-backup pushes the backup branch and that the
+// recovery instructions explain how to fetch it back
+func TestCLI_PushBackupToRemote(t *testing.T) {
+	tr := newTestRepo(t)
+	tr.createCommit("a")
+	remote := tr.addRemote()
+	tr.createCommitsWithMessages("b", "c")
+
+	out := tr.runCLISuccess("-n", "2", "-m", "Squashed", "-push-backup", "origin", "-print-recovery")
+	if !strings.Contains(out, "git fetch origin refs/heads/locsquash/backup-") {
+		t.Errorf("expected the recovery instructions to fetch the backup from origin, got: %s", out)
+	}
+
+	out = tr.runCLISuccess("-n", "2", "-m", "Squashed", "-push-backup", "origin", "-y")
+	if !strings.Contains(out, "(also on origin)") {
+		t.Errorf("expected the summary to mention the pushed backup, got: %s", out)
+	}
+	backup := tr.git(t.Context(), "for-each-ref", "--format=%(refname)", "refs/heads/locsquash/")
+	if backup == "" {
+		t.Fatal("expected a local backup branch")
+	}
+	cmd := exec.CommandContext(t.Context(), "git", "ls-remote", remote, backup)
+	if lsOut, err := cmd.Output(); err != nil || !strings.Contains(string(lsOut), backup) {
+		t.Errorf("expected %s on the remote, got %q (%v)", backup, lsOut, err)
+	}
+}
+
+// TestCLI_PushBackupFailureOnlyWarns tests that a failed backup push warns but the squash goes on
+func TestCLI_PushBackupFailureOnlyWarns(t *testing.T) {
+	tr := newTestRepo(t)
+	tr.createCommitsWithMessages("a", "b", "c")
+
+	out := tr.runCLISuccess("-n", "2", "-m", "Squashed", "-push-backup", "nowhere", "-y")
+	if !strings.Contains(out, "Warning: failed to push backup") {
+		t.Errorf("expected a push warning, got: %s", out)
+	}
+	if got := tr.commitCount(); got != 2 {
+		t.Errorf("expected 2 commits after squash, got %d", got)
+	}
+	if branches := tr.git(t.Context(), "branch"); !strings.Contains(branches, "locsquash/backup-") {
+		t.Errorf("expected the local backup to remain, got: %s", branches)
+	}
+
+	out, code := tr.runCLIExitCode("-n", "2", "-m", "x", "-push-backup", "origin", "-no-backup", "-y")
+	if code != 2 || !strings.Contains(out, "cannot be combined with -no-backup") {
+		t.Errorf("expected usage error for -push-backup with -no-backup, got exit %d: %s", code, out)
+	}
+}
+
+// TestCLI_InterruptRestoresBackup tests that Ctrl-C during the squash stops the running git
+// command and restores the repository from the backup
+func TestCLI_InterruptRestoresBackup(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("sending os.Interrupt to a process is not supported on Windows")
+	}
+	tr := newTestRepo(t)
+	tr.createCommitsWithMessages("a", "b", "c")
+	head := tr.git(t.Context(), "rev-parse", "HEAD")
+	marker := filepath.Join(t.TempDir(), "hook-started")
+	// The hook hands its output streams to /dev/null so the abandoned sleep holds no pipe open
+	tr.writeHook("pre-commit", "touch '"+marker+"'\nexec sleep 10 >/dev/null 2>&1 </dev/null\n")
+
+	cmd := exec.CommandContext(t.Context(), tr.Binary, "-n", "2", "-m", "Squashed", "-y") //nolint:gosec
+	cmd.Dir = tr.Dir
+	var out strings.Builder
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+	if err := cmd.Start(); err != nil {
+		t.Fatalf("failed to start CLI: %v", err)
+	}
+	for deadline := time.Now().Add(10 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		if _, err := os.Stat(marker); err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("the pre-commit hook never started")
+		}
+	}
+	if err := cmd.Process.Signal(os.Interrupt); err != nil {
+		t.Fatalf("failed to interrupt CLI: %v", err)
+	}
+	var exitErr *exec.ExitError
+	if err := cmd.Wait(); !errors.As(err, &exitErr) || exitErr.ExitCode() != 130 {
+		t.Fatalf("expected an interrupted squash to exit with 130, got %v\nOutput: %s", err, out.String())
+	}
+	if !strings.Contains(out.String(), "Interrupted.") || !strings.Contains(out.String(), "nothing was squashed") {
+		t.Errorf("expected the interrupt to restore the backup, got: %s", out.String())
+	}
+	if got := tr.git(t.Context(), "rev-parse", "HEAD"); got != head {
+		t.Errorf("expected HEAD to be restored to %s, got %s", head, got)
+	}
+}
+
+// TestCLI_RenameBackup tests that -rename-backup renames a backup branch within the backup
+// namespace and keeps -recover-last pointing at it
+func TestCLI_RenameBackup(t *testing.T) {
+	tr := newTestRepo(t)
+	tr.createCommitsWithMessages("a", "b", "c")
+	tr.runCLISuccess("-n", "2", "-m", "Squashed", "-y")
+	backup := tr.git(t.Context(), "branch", "--list", "locsquash/backup-*", "--format=%(refname:short)")
+
+	out := tr.runCLISuccess("-rename-backup", backup, "locsquash/verified-feature")
+	if !strings.Contains(out, "Renamed backup branch "+backup+" to locsquash/verified-feature") {
+		t.Errorf("expected the rename to be reported, got: %s", out)
+	}
+	if branches := tr.git(t.Context(), "branch", "--list", "locsquash/*", "--format=%(refname:short)"); branches != "locsquash/verified-feature" {
+		t.Errorf("expected only the renamed backup, got %q", branches)
+	}
+
+	tr.runCLISuccess("-recover-last", "-y")
+	if count := tr.commitCount(); count != 3 {
+		t.Errorf("expected -recover-last to use the renamed backup and restore 3 commits, got %d", count)
+	}
+}
+
+// TestCLI_RenameBackupRefusals tests that -rename-backup rejects a missing source backup and
+// names outside the backup namespace
+func TestCLI_RenameBackupRefusals(t *testing.T) {
+	tr := newTestRepo(t)
+	tr.createCommitsWithMessages("a", "b", "c")
+	tr.git(t.Context(), "branch", "locsquash/backup-20240101-000000")
+
+	out, code := tr.runCLIExitCode("-rename-backup", "locsquash/backup-19990101-000000", "locsquash/kept")
+	if code != 1 || !strings.Contains(out, `backup "locsquash/backup-19990101-000000" does not exist`) {
+		t.Errorf("expected a missing backup to be refused, got exit %d: %s", code, out)
+	}
+
+	out, code = tr.runCLIExitCode("-rename-backup", "locsquash/backup-20240101-000000", "release")
+	if code != 1 || !strings.Contains(out, "outside the backup namespace locsquash/") {
+		t.Errorf("expected a name outside the namespace to be refused, got exit %d: %s", code, out)
+	}
+
+	tr.runCLISuccess("-rename-backup", "locsquash/backup-20240101-000000", "release", "-force")
+	if branches := tr.git(t.Context(), "branch", "--list", "release", "--format=%(refname:short)"); branches != "release" {
+		t.Errorf("expected -force to allow the rename, got %q", branches)
+	}
+
+	out, code = tr.runCLIExitCode("-rename-backup", "locsquash/backup-20240101-000000")
+	if code != 2 || !strings.Contains(out, "needs the new name") {
+		t.Errorf("expected usage error without the new name, got exit %d: %s", code, out)
+	}
+}
+
+// TestCLI_SeparatesStatusFromDiagnostics tests that status goes to stdout while errors and
+// warnings go to stderr only
+func TestCLI_SeparatesStatusFromDiagnostics(t *testing.T) {
+	tr := newTestRepo(t)
+	tr.createCommitsWithMessages("a", "b", "c", "d")
+
+	stdout, stderr, err := tr.runCLISplit("-n", "2", "-m", "Squashed", "-y", "-push-backup", "nowhere")
+	if err != nil {
+		t.Fatalf("CLI failed unexpectedly: %v\nstderr: %s", err, stderr)
+	}
+	if !strings.Contains(stdout, "Successfully squashed") || strings.Contains(stdout, "Warning:") {
+		t.Errorf("expected the status, and no warning, on stdout, got: %s", stdout)
+	}
+	if !strings.Contains(stderr, "Warning: failed to push backup") || strings.Contains(stderr, "Successfully") {
+		t.Errorf("expected only the warning on stderr, got: %s", stderr)
+	}
+
+	tr.writeFile("file.txt", "uncommitted")
+	stdout, stderr, err = tr.runCLISplit("-n", "2", "-y")
+	if err == nil {
+		t.Fatal("expected a dirty tree to be refused")
+	}
+	if stdout != "" || !strings.Contains(stderr, "Error: uncommitted changes detected") {
+		t.Errorf("expected the error on stderr only, got stdout %q, stderr %q", stdout, stderr)
+	}
+}
+
+// TestCLI_CommitTemplate tests that -commit-template starts from the configured commit.template
+// and falls back to the oldest commit's message when none is configured
+func TestCLI_CommitTemplate(t *testing.T) {
+	tr := newTestRepo(t)
+	tr.createCommitsWithMessages("a", "b", "c", "d", "e")
+
+	tr.runCLISuccess("-n", "2", "-commit-template", "-y")
+	if msg := tr.lastCommitMessage(); msg != "d" {
+		t.Errorf("expected the oldest message without a template, got %q", msg)
+	}
+
+	template := filepath.Join(t.TempDir(), "gitmessage")
+	if err := os.WriteFile(template, []byte("# Summarize the change\nfeat: from the template\n\nDetails here.\n"), 0600); err != nil {
+		t.Fatalf("failed to write template: %v", err)
+	}
+	tr.git(t.Context(), "config", "commit.template", template)
+
+	tr.runCLISuccess("-n", "2", "-commit-template", "-y")
+	if msg := tr.git(t.Context(), "log", "-1", "--format=%B"); msg != "feat: from the template\n\nDetails here." {
+		t.Errorf("expected the template without its comments, got %q", msg)
+	}
+
+	// ~/ is expanded the way git commit expands it
+	home := filepath.Dir(template)
+	tr.git(t.Context(), "config", "commit.template", "~/gitmessage")
+	out, err := tr.runCLIWithEnv([]string{"HOME=" + home}, "-n", "2", "-commit-template", "-y")
+	if err != nil {
+		t.Fatalf("CLI failed unexpectedly: %v\nOutput: %s", err, out)
+	}
+	if msg := tr.lastCommitMessage(); msg != "feat: from the template" {
+		t.Errorf("expected the template from the home directory, got %q", msg)
+	}
+}
+
+// TestCLI_AppendsSquashHistory tests that each successful squash appends a line to the history
+// log, and that -no-history skips it
+func TestCLI_AppendsSquashHistory(t *testing.T) {
+	tr := newTestRepo(t)
+	tr.createCommitsWithMessages("a", "b", "c", "d", "e")
+	before := tr.git(t.Context(), "rev-parse", "HEAD")
+
+	tr.runCLISuccess("-n", "2", "-m", "Squashed", "-y")
+	after := tr.git(t.Context(), "rev-parse", "HEAD")
+	path := filepath.Join(tr.Dir, ".git", "locsquash-history.log")
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("expected a history log: %v", err)
+	}
+	fields := strings.Split(strings.TrimSuffix(string(data), "\n"), "\t")
+	if len(fields) != 5 || fields[1] != "2" || !strings.HasPrefix(fields[2], "locsquash/backup-") || fields[3] != before || fields[4] != after {
+		t.Errorf("expected time, count, backup, old and new HEAD, got %q", data)
+	}
+
+	tr.runCLISuccess("-n", "2", "-m", "Again", "-y", "-no-history")
+	tr.runCLISuccess("-n", "2", "-m", "Third", "-y")
+	if data, err = os.ReadFile(path); err != nil || strings.Count(string(data), "\n") != 2 {
+		t.Errorf("expected two entries after skipping one with -no-history, got %q (%v)", data, err)
+	}
+}
+
+func TestCLI_History(t *testing.T) {
+	tr := newTestRepo(t)
+	tr.createCommitsWithMessages("a", "b")
+
+	if out := tr.runCLISuccess("-history"); !strings.Contains(out, "No squashes recorded yet") {
+		t.Errorf("expected an empty history note, got:\n%s", out)
+	}
+
+	tr.git(t.Context(), "branch", "locsquash/backup-kept")
+	log := "2024-06-01T12:00:00Z\t3\tlocsquash/backup-gone\taaaaaaaaaa\tbbbbbbbbbb\n" +
+		"2024-06-02T12:00:00Z\t2\tlocsquash/backup-kept\tbbbbbbbbbb\tcccccccccc\n"
+	tr.writeFile(".git/locsquash-history.log", log)
+
+	out := tr.runCLISuccess("-history")
+	kept, gone := strings.Index(out, "locsquash/backup-kept (still exists)"), strings.Index(out, "locsquash/backup-gone (deleted)")
+	if kept < 0 || gone < 0 || kept > gone {
+		t.Errorf("expected the newest squash first and each backup's status, got:\n%s", out)
+	}
+	if !strings.Contains(out, "bbbbbbb..ccccccc") {
+		t.Errorf("expected abbreviated HEADs, got:\n%s", out)
+	}
+
+	var entries []struct {
+		Count        int    `json:"count"`
+		Backup       string `json:"backup"`
+		BackupExists bool   `json:"backup_exists"`
+	}
+	stdout, _, err := tr.runCLISplit("-history", "-json")
+	if err != nil {
+		t.Fatalf("-history -json failed: %v", err)
+	}
+	if err = json.Unmarshal([]byte(stdout), &entries); err != nil {
+		t.Fatalf("expected a JSON array, got %q: %v", stdout, err)
+	}
+	if len(entries) != 2 || entries[0].Backup != "locsquash/backup-kept" || !entries[0].BackupExists ||
+		entries[1].Count != 3 || entries[1].BackupExists {
+		t.Errorf("unexpected JSON history %+v", entries)
+	}
+}
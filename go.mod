@@ -1,3 +1,14 @@
 module github.com/OutOfStack/locsquash
 
 go 1.24
+
+require (
+	github.com/mattn/go-runewidth v0.0.16
+	golang.org/x/term v0.32.0
+	gopkg.in/yaml.v3 v3.0.1
+)
+
+require (
+	github.com/rivo/uniseg v0.2.0 // indirect
+	golang.org/x/sys v0.33.0 // indirect
+)
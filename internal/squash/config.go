@@ -0,0 +1,145 @@
+package squash
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// configFileNames are the config files looked up in the repository root and $HOME, in order of preference
+var configFileNames = []string{".locsquash.yml", ".locsquashrc"}
+
+// repoConfigKeys are the keys the repository config file may set. The file comes with the
+// repository, so it is limited to defaults for the message, the commit and the output; keys
+// that run commands, reach remotes, read or write other files, or skip a confirmation or
+// safety guard are only read from $HOME.
+var repoConfigKeys = map[string]bool{
+	"m": true, "message-from": true, "concat-messages": true, "cleanup": true, "wrap": true,
+	"strip-comments": true, "signoff": true, "s": true, "trailer": true, "collect-coauthors": true,
+	"conventional": true, "conventional-types": true, "commit-template": true, "edit": true,
+	"keep-author": true, "keep-committer": true, "sign": true, "S": true,
+	"stash": true, "stash-untracked": true, "stash-all": true,
+	"max-age": true, "protected": true, "wip-pattern": true,
+	"backup-prefix": true, "tag-backup": true, "no-history": true,
+	"print-recovery": true, "stat": true, "show-diff": true, "preview": true,
+	"quiet": true, "q": true, "verbose": true, "color": true, "no-color": true,
+	"prompt-timeout": true,
+}
+
+// commandLineOnlyKeys are flags a config file cannot set: the config is read from the
+// directory they select, so it is too late for them to take effect
+var commandLineOnlyKeys = map[string]bool{"C": true, "workdir": true}
+
+// repeatableKeys are flags that may be given several times; a YAML list sets them once per item
+var repeatableKeys = map[string]bool{"trailer": true}
+
+// LoadConfig applies default flag values from config files to every flag that was not
+// set explicitly on the command line. Keys are flag names (e.g. "stash", "no-verify").
+// The $HOME config is applied first so that the repository config takes precedence.
+func LoadConfig(ctx context.Context, g GitRunner, fs *flag.FlagSet) error {
+	explicit := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) { explicit[f.Name] = true })
+
+	var home, root string
+	if dir, err := os.UserHomeDir(); err == nil {
+		home = dir
+	}
+	if dir, err := gitStdout(ctx, g, "rev-parse", "--show-toplevel"); err == nil {
+		root = dir
+	}
+
+	seen := map[string]bool{}
+	for _, dir := range []string{home, root} {
+		if dir == "" {
+			continue
+		}
+		path := findConfigFile(dir)
+		if path == "" || seen[path] {
+			continue
+		}
+		seen[path] = true
+		if err := applyConfigFile(fs, path, explicit, dir != home); err != nil {
+			return fmt.Errorf("config file %s: %w", path, err)
+		}
+	}
+	return nil
+}
+
+// findConfigFile returns the path of the first config file present in dir, or ""
+func findConfigFile(dir string) string {
+	for _, name := range configFileNames {
+		path := filepath.Join(dir, name)
+		if fi, err := os.Stat(path); err == nil && !fi.IsDir() {
+			return path
+		}
+	}
+	return ""
+}
+
+// applyConfigFile parses a YAML mapping of flag names to values and sets the flags
+// that are not in explicit. A repository config may only set repoConfigKeys.
+func applyConfigFile(fs *flag.FlagSet, path string, explicit map[string]bool, fromRepo bool) error {
+	data, err := os.ReadFile(path) //nolint:gosec // path is a well-known config location
+	if err != nil {
+		return err
+	}
+	var values map[string]any
+	if err = yaml.Unmarshal(data, &values); err != nil {
+		return err
+	}
+	for key, raw := range values {
+		if fs.Lookup(key) == nil {
+			return fmt.Errorf("unknown key %q (keys are flag names without the leading dash)", key)
+		}
+		if commandLineOnlyKeys[key] {
+			return fmt.Errorf("key %q can only be given on the command line", key)
+		}
+		if fromRepo && !repoConfigKeys[key] {
+			return fmt.Errorf("key %q is not allowed in the repository config, which comes with the repository; set it in the config file in your home directory instead", key)
+		}
+		if explicit[key] {
+			continue
+		}
+		values, cErr := configValues(raw)
+		if cErr != nil {
+			return fmt.Errorf("key %q: %w", key, cErr)
+		}
+		if !repeatableKeys[key] {
+			values = []string{strings.Join(values, ",")}
+		}
+		for _, value := range values {
+			if err = fs.Set(key, value); err != nil {
+				return fmt.Errorf("key %q: %w", key, err)
+			}
+		}
+	}
+	return nil
+}
+
+// configValues converts a YAML scalar or a list of scalars to flag value strings
+func configValues(raw any) ([]string, error) {
+	switch v := raw.(type) {
+	case nil:
+		return nil, errors.New("missing value")
+	case []any:
+		values := make([]string, 0, len(v))
+		for _, item := range v {
+			switch item.(type) {
+			case nil, []any, map[string]any:
+				return nil, errors.New("list items must be single values")
+			}
+			values = append(values, fmt.Sprint(item))
+		}
+		return values, nil
+	case map[string]any:
+		return nil, errors.New("nested mappings are not supported")
+	default:
+		return []string{fmt.Sprint(v)}, nil
+	}
+}
@@ -0,0 +1,240 @@
+package squash
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"time"
+)
+
+// fixupPrefixes are the subject prefixes that git rebase --autosquash folds into an earlier commit
+var fixupPrefixes = []string{"fixup! ", "squash! "}
+
+// fixupCommit is a commit considered by -autofixup
+type fixupCommit struct {
+	Hash    string // Full commit hash
+	Short   string // Abbreviated commit hash
+	Subject string // First line of the commit message
+}
+
+// fixupFold pairs a fixup!/squash! commit with the earlier commit it is folded into
+type fixupFold struct {
+	Fixup  fixupCommit
+	Target fixupCommit
+	index  int // Position of Target in the scanned commits, newest first
+}
+
+// gitLocalCommits returns the first-parent commits of HEAD that are on no remote-tracking
+// branch, newest first. Only these are considered by -autofixup, so it never rewrites pushed history.
+func gitLocalCommits(ctx context.Context, g GitRunner) ([]fixupCommit, error) {
+	out, err := gitStdout(ctx, g, "log", "--first-parent", "--format=%H%x00%h%x00%s", "HEAD", "--not", "--remotes")
+	if err != nil {
+		return nil, err
+	}
+	var commits []fixupCommit
+	for line := range strings.SplitSeq(out, "\n") {
+		parts := strings.SplitN(line, "\x00", 3)
+		if len(parts) == 3 {
+			commits = append(commits, fixupCommit{Hash: parts[0], Short: parts[1], Subject: parts[2]})
+		}
+	}
+	return commits, nil
+}
+
+// stripFixupPrefixes removes every leading fixup!/squash! prefix from subject and reports
+// whether there was one
+func stripFixupPrefixes(subject string) (string, bool) {
+	found := false
+	for {
+		stripped := false
+		for _, prefix := range fixupPrefixes {
+			if rest, ok := strings.CutPrefix(subject, prefix); ok {
+				subject, stripped, found = rest, true, true
+			}
+		}
+		if !stripped {
+			return subject, found
+		}
+	}
+}
+
+// findFixupFolds matches every fixup!/squash! commit in commits (newest first) with the older
+// commit it refers to, the way git rebase --autosquash does: by exact subject, then by hash
+// prefix, then by subject prefix. Fixup commits without a match are returned separately; the
+// rebase leaves them as they are.
+func findFixupFolds(commits []fixupCommit) ([]fixupFold, []fixupCommit) {
+	var folds []fixupFold
+	var unmatched []fixupCommit
+	for i, c := range commits {
+		ref, ok := stripFixupPrefixes(c.Subject)
+		if !ok {
+			continue
+		}
+		older := commits[i+1:]
+		target := slices.IndexFunc(older, func(o fixupCommit) bool { return o.Subject == ref })
+		if target < 0 && len(ref) >= 4 && !strings.Contains(ref, " ") {
+			target = slices.IndexFunc(older, func(o fixupCommit) bool { return strings.HasPrefix(o.Hash, ref) })
+		}
+		if target < 0 {
+			target = slices.IndexFunc(older, func(o fixupCommit) bool { return strings.HasPrefix(o.Subject, ref) })
+		}
+		if target < 0 {
+			unmatched = append(unmatched, c)
+			continue
+		}
+		folds = append(folds, fixupFold{Fixup: c, Target: older[target], index: i + 1 + target})
+	}
+	return folds, unmatched
+}
+
+// autosquashEnv accepts the generated todo list and the combined squash! messages as they are
+var autosquashEnv = []string{"GIT_SEQUENCE_EDITOR=true", "GIT_EDITOR=true"}
+
+// autosquashArgs returns the rebase arguments that fold the fixup commits above ResetRef,
+// or the whole history when ResetRef is empty. With -sign/-S the rewritten commits are signed.
+func (info SquashInfo) autosquashArgs() []string {
+	args := []string{"rebase", "-i", "--autosquash"}
+	if sign := info.commitOptions().signArg(); sign != "" {
+		args = append(args, sign)
+	}
+	if info.ResetRef == "" {
+		return append(args, "--root")
+	}
+	return append(args, info.ResetRef)
+}
+
+// runAutofixup folds the unpushed fixup!/squash! commits into their targets with an automated
+// git rebase -i --autosquash, behind the same backup, stash and recovery steps as a squash
+func runAutofixup(ctx context.Context, g GitRunner, info *SquashInfo) error {
+	commits, err := gitLocalCommits(ctx, g)
+	if err != nil {
+		return failCodef(exitGit, "Error listing commits: %v", err)
+	}
+	folds, unmatched := findFixupFolds(commits)
+	for _, c := range unmatched {
+		warnf("%s %q matches no earlier unpushed commit and is left as it is.", c.Short, c.Subject)
+	}
+	if len(folds) == 0 {
+		statusln("No fixup! or squash! commits to fold.")
+		return nil
+	}
+
+	// The rebase starts right below the oldest commit that receives a fixup
+	oldest := folds[0]
+	for _, f := range folds {
+		if f.index > oldest.index {
+			oldest = f
+		}
+	}
+	var merges []string
+	if base, bErr := gitResolveCommit(ctx, g, oldest.Target.Hash+"^"); bErr == nil {
+		info.ResetRef = base
+		merges, err = gitMergeCommits(ctx, g, base, "HEAD")
+	} else {
+		info.ResetRef = ""
+		var out string
+		if out, err = gitStdout(ctx, g, "rev-list", "--merges", "--abbrev-commit", "HEAD"); out != "" {
+			merges = strings.Split(out, "\n")
+		}
+	}
+	if err != nil {
+		return failCodef(exitGit, "Error checking for merge commits: %v", err)
+	}
+	// rebase -i without --rebase-merges would flatten them
+	if len(merges) > 0 {
+		return failf("Error: the commits to rebase contain %d merge commit(s) (%s); -autofixup cannot fold fixups across merges.", len(merges), strings.Join(merges, ", "))
+	}
+
+	info.SquashCount = len(folds)
+	info.Commits = info.Commits[:0]
+	for _, f := range folds {
+		info.Commits = append(info.Commits, CommitInfo{Hash: f.Fixup.Short, Subject: f.Fixup.Subject})
+	}
+	info.BackupName = info.backupPrefix() + time.Now().UTC().Format(backupTimeFormat)
+	if err := checkBackupName(ctx, g, info); err != nil {
+		return err
+	}
+
+	if info.fromPlan != nil {
+		if err := checkPlan(info); err != nil {
+			return err
+		}
+	}
+	if info.DryRun && !info.JSON {
+		statusln("Dry run. No changes will be made.")
+		statusln()
+	}
+	statusf("The following %d fixup commit(s) will be folded:\n\n", len(folds))
+	for _, f := range folds {
+		statusf("  %s  %s\n      into %s  %s\n",
+			colorize(colorYellow, f.Fixup.Short), sanitizeForTerminal(f.Fixup.Subject),
+			colorize(colorYellow, f.Target.Short), sanitizeForTerminal(f.Target.Subject))
+	}
+	statusln()
+
+	if info.DryRun {
+		if err := info.printAutofixupPlan(); err != nil {
+			return failCodef(exitGeneral, "Error encoding JSON output: %v", err)
+		}
+	}
+	if info.DumpPlan != "" {
+		if err := writePlan(ctx, g, info); err != nil {
+			return failf("Error: -dump-plan: cannot write the plan: %v", err)
+		}
+	}
+	if info.showsRecovery() {
+		info.printRecovery()
+	}
+	if info.DryRun && info.DryRunExitCode && info.WouldFail {
+		return &exitError{code: exitNoop}
+	}
+	if info.DryRun || info.PrintRecovery {
+		return nil
+	}
+
+	if !info.Yes {
+		ok, pErr := confirm(ctx, "Proceed?")
+		if pErr != nil {
+			return pErr
+		}
+		if !ok {
+			statusln("Aborted.")
+			return nil
+		}
+	}
+	return execute(ctx, g, info)
+}
+
+// printAutofixupPlan outputs the git commands -autofixup would run
+func (info SquashInfo) printAutofixupPlan() error {
+	if info.JSON {
+		return info.printJSON()
+	}
+	statusln("# Planned operations (copy-paste friendly):")
+	statusln()
+	if !info.NoBackup {
+		statusf("# Backup %s\n", info.backupKind())
+		statusf("git %s %s HEAD\n\n", info.backupKind(), info.BackupName)
+		if info.PushBackup != "" {
+			statusf("# Push backup %s to %s\n", info.backupKind(), info.PushBackup)
+			statusf("git push --quiet %s %s:%s\n\n", info.PushBackup, info.backupRef(), info.backupRef())
+		}
+	}
+	if info.Dirty && info.AllowStash {
+		statusf("# Stash working tree\n")
+		statusf("%s\n\n", formatCommand(nil, stashPushArgs(info.stashInclude(), autoStashMessage)))
+	}
+	statusf("# Fold the fixup commits\n")
+	statusf("%s\n\n", formatCommand(autosquashEnv, info.autosquashArgs()))
+	if info.Dirty && info.AllowStash {
+		statusf("# Restore working tree\n")
+		statusf("git stash apply stash@{0}\n")
+		statusf("git stash drop stash@{0}\n\n")
+	}
+	if !info.NoBackup && !info.KeepBackup {
+		statusf("# Delete backup %s after success\n", info.backupKind())
+		statusf("%s\n\n", info.deleteBackupCommand())
+	}
+	statusln("# End of dry run")
+	return nil
+}
@@ -0,0 +1,50 @@
+package squash
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestFindFixupFolds(t *testing.T) {
+	// Newest first, as git log lists them
+	commits := []fixupCommit{
+		{Hash: "f5f5f5f5", Short: "f5f5f5f", Subject: "fixup! no such commit"},
+		{Hash: "e4e4e4e4", Short: "e4e4e4e", Subject: "fixup! a1a1a1a1"},
+		{Hash: "d3d3d3d3", Short: "d3d3d3d", Subject: "squash! fixup! add parser"},
+		{Hash: "c2c2c2c2", Short: "c2c2c2c", Subject: "fixup! add lex"},
+		{Hash: "b1b1b1b1", Short: "b1b1b1b", Subject: "add parser"},
+		{Hash: "a1a1a1a1", Short: "a1a1a1a", Subject: "add lexer"},
+	}
+
+	folds, unmatched := findFixupFolds(commits)
+	got := make([]string, 0, len(folds))
+	for _, f := range folds {
+		got = append(got, f.Fixup.Short+"->"+f.Target.Short)
+	}
+	// By hash, by exact subject after stripping every prefix, and by subject prefix
+	want := []string{"e4e4e4e->a1a1a1a", "d3d3d3d->b1b1b1b", "c2c2c2c->a1a1a1a"}
+	if !slices.Equal(got, want) {
+		t.Errorf("got folds %v, want %v", got, want)
+	}
+	if len(unmatched) != 1 || unmatched[0].Short != "f5f5f5f" {
+		t.Errorf("expected only f5f5f5f to be unmatched, got %+v", unmatched)
+	}
+}
+
+func TestStripFixupPrefixes(t *testing.T) {
+	tests := []struct {
+		subject string
+		want    string
+		found   bool
+	}{
+		{"fixup! add parser", "add parser", true},
+		{"squash! fixup! add parser", "add parser", true},
+		{"add parser", "add parser", false},
+		{"fixup!add parser", "fixup!add parser", false},
+	}
+	for _, tt := range tests {
+		if got, found := stripFixupPrefixes(tt.subject); got != tt.want || found != tt.found {
+			t.Errorf("stripFixupPrefixes(%q) = %q, %v; want %q, %v", tt.subject, got, found, tt.want, tt.found)
+		}
+	}
+}
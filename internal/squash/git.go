@@ -0,0 +1,1065 @@
+package squash
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// GitRunner executes git commands. The squash flow only talks to git through it,
+// so tests can substitute a scripted fake for the real binary.
+type GitRunner interface {
+	// Stdout runs git with extra environment variables and returns its trimmed stdout
+	Stdout(ctx context.Context, env []string, args ...string) (string, error)
+	// Run runs git with stdout going to the progress stream and stderr passed through
+	Run(ctx context.Context, env []string, args ...string) error
+}
+
+// realGit runs the git binary found in PATH, from dir when it is set and with env added
+// to the environment of every command
+type realGit struct {
+	dir string
+	env []string
+}
+
+// newRepoGit returns a realGit that runs from the top-level directory of the repository
+// containing dir (the current directory if dir is empty), so results do not depend on the
+// subdirectory locsquash was started from. Outside a repository it runs from dir and the
+// squash reports the error.
+func newRepoGit(ctx context.Context, dir string) realGit {
+	g := realGit{dir: dir, env: absoluteGitEnv()}
+	root, err := gitStdout(ctx, g, "rev-parse", "--show-toplevel")
+	if err != nil {
+		return g
+	}
+	g.dir = root
+	return g
+}
+
+// absoluteGitEnv returns GIT_DIR and GIT_WORK_TREE made absolute when they are set to relative
+// paths, which would otherwise resolve against the directory a command runs from rather than
+// the one locsquash was started from
+func absoluteGitEnv() []string {
+	var env []string
+	for _, key := range []string{"GIT_DIR", "GIT_WORK_TREE"} {
+		if value := os.Getenv(key); value != "" && !filepath.IsAbs(value) {
+			if abs, err := filepath.Abs(value); err == nil {
+				env = append(env, key+"="+abs)
+			}
+		}
+	}
+	return env
+}
+
+// command builds a git command with optional extra environment variables.
+// All git invocations go through here so -verbose can echo them before they run.
+func (r realGit) command(ctx context.Context, env []string, args ...string) *exec.Cmd {
+	if verboseOutput {
+		fmt.Fprintln(os.Stderr, colorizeErr(colorCyan, "+ "+formatCommand(env, args)))
+	}
+	cmd := exec.CommandContext(ctx, "git", args...)
+	cmd.Dir = r.dir
+	if len(r.env) > 0 || len(env) > 0 {
+		cmd.Env = slices.Concat(os.Environ(), r.env, env)
+	}
+	return cmd
+}
+
+func (r realGit) Stdout(ctx context.Context, env []string, args ...string) (string, error) {
+	cmd := r.command(ctx, env, args...)
+	var out bytes.Buffer
+	var errBuf bytes.Buffer
+	cmd.Stdout = &out
+	cmd.Stderr = &errBuf
+	err := cmd.Run()
+	if err != nil {
+		return "", fmt.Errorf("%w: %s", err, strings.TrimSpace(errBuf.String()))
+	}
+	return strings.TrimSpace(out.String()), nil
+}
+
+func (r realGit) Run(ctx context.Context, env []string, args ...string) error {
+	cmd := r.command(ctx, env, args...)
+	cmd.Stdout = progressWriter()
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
+
+// cachedReadCommands lists the git subcommands whose output depends only on refs and
+// objects, so repeating them before the next mutation is guaranteed to give the same result
+var cachedReadCommands = map[string]bool{
+	"rev-parse":  true,
+	"rev-list":   true,
+	"log":        true,
+	"cat-file":   true,
+	"merge-base": true,
+	"describe":   true,
+	// locsquash only reads HEAD with it, never points HEAD elsewhere
+	"symbolic-ref": true,
+}
+
+// mutatingCommands lists the git subcommands locsquash uses to move refs or HEAD; any of
+// them may change what the cached reads return. stash is handled in mutates.
+var mutatingCommands = map[string]bool{
+	"reset":       true,
+	"commit":      true,
+	"update-ref":  true,
+	"branch":      true,
+	"tag":         true,
+	"rebase":      true,
+	"checkout":    true,
+	"switch":      true,
+	"merge":       true,
+	"cherry-pick": true,
+	"push":        true,
+	"fetch":       true,
+}
+
+// mutates reports whether the git command args may invalidate cached reads
+func mutates(args []string) bool {
+	if len(args) == 0 {
+		return false
+	}
+	if args[0] == "stash" {
+		// Only listing and showing stashes leave refs/stash alone
+		return len(args) < 2 || (args[1] != "list" && args[1] != "show")
+	}
+	return mutatingCommands[args[0]]
+}
+
+// cachingGit memoizes read-only Stdout calls for the duration of one invocation.
+// locsquash reads everything it needs before mutating, so the whole cache is simply
+// dropped on the first command that may change the repository.
+type cachingGit struct {
+	GitRunner
+	reads map[string]cachedResult
+}
+
+// cachedResult is a memoized Stdout result; failures such as a missing ref are cached too
+type cachedResult struct {
+	out string
+	err error
+}
+
+// newCachingGit wraps g with a request-scoped read cache
+func newCachingGit(g GitRunner) *cachingGit {
+	return &cachingGit{GitRunner: g, reads: make(map[string]cachedResult)}
+}
+
+func (c *cachingGit) Stdout(ctx context.Context, env []string, args ...string) (string, error) {
+	if mutates(args) {
+		clear(c.reads)
+	}
+	if len(env) > 0 || len(args) == 0 || !cachedReadCommands[args[0]] {
+		return c.GitRunner.Stdout(ctx, env, args...)
+	}
+	key := strings.Join(args, "\x00")
+	if r, ok := c.reads[key]; ok {
+		return r.out, r.err
+	}
+	out, err := c.GitRunner.Stdout(ctx, env, args...)
+	c.reads[key] = cachedResult{out: out, err: err}
+	return out, err
+}
+
+func (c *cachingGit) Run(ctx context.Context, env []string, args ...string) error {
+	if mutates(args) {
+		clear(c.reads)
+	}
+	return c.GitRunner.Run(ctx, env, args...)
+}
+
+// exitCode returns the exit status carried by err, or -1 if err has none
+func exitCode(err error) int {
+	var coded interface{ ExitCode() int }
+	if errors.As(err, &coded) {
+		return coded.ExitCode()
+	}
+	return -1
+}
+
+// gitStdout runs a git command and returns its stdout
+func gitStdout(ctx context.Context, g GitRunner, args ...string) (string, error) {
+	return g.Stdout(ctx, nil, args...)
+}
+
+// runGitCommand runs a git command with output to the progress stream and stderr
+func runGitCommand(ctx context.Context, g GitRunner, args ...string) error {
+	return g.Run(ctx, nil, args...)
+}
+
+// refExists checks if the fully qualified ref (e.g. refs/heads/name) exists.
+// Uses git show-ref which is locale-independent (avoids parsing error messages).
+func refExists(ctx context.Context, g GitRunner, ref string) bool {
+	return runGitCommand(ctx, g, "show-ref", "--verify", "--quiet", ref) == nil
+}
+
+// headIsUnborn reports whether HEAD is on a branch that has no commits yet, as in a freshly
+// initialized repository
+func headIsUnborn(ctx context.Context, g GitRunner) bool {
+	_, err := gitStdout(ctx, g, "rev-parse", "--verify", "--quiet", "HEAD")
+	return err != nil
+}
+
+// maxBackupSuffix caps the numeric suffix tried by uniqueBackupName
+const maxBackupSuffix = 1000
+
+// uniqueBackupName returns base, or base with the lowest numeric suffix (-2, -3, ...)
+// that does not name an existing ref under namespace (e.g. refs/heads/)
+func uniqueBackupName(ctx context.Context, g GitRunner, namespace, base string) (string, error) {
+	if !refExists(ctx, g, namespace+base) {
+		return base, nil
+	}
+	for i := 2; i <= maxBackupSuffix; i++ {
+		name := fmt.Sprintf("%s-%d", base, i)
+		if !refExists(ctx, g, namespace+name) {
+			return name, nil
+		}
+	}
+	return "", fmt.Errorf("no free name for %s after %d attempts", base, maxBackupSuffix)
+}
+
+// createBackupRef creates a branch (or a lightweight tag if asTag is set) at target,
+// adding a numeric suffix if the base name already exists
+func createBackupRef(ctx context.Context, g GitRunner, baseName, target string, asTag bool) (string, error) {
+	namespace, command := "refs/heads/", "branch"
+	if asTag {
+		namespace, command = "refs/tags/", "tag"
+	}
+
+	name, err := uniqueBackupName(ctx, g, namespace, baseName)
+	if err != nil {
+		return "", err
+	}
+	if _, err = gitStdout(ctx, g, command, name, target); err != nil {
+		return "", err
+	}
+	return name, nil
+}
+
+// ensureInsideGitRepo checks if the current directory is inside a git repository
+func ensureInsideGitRepo(ctx context.Context, g GitRunner) error {
+	out, err := gitStdout(ctx, g, "rev-parse", "--is-inside-work-tree")
+	if err != nil {
+		return errors.New("not a git repository (or any of the parent directories)")
+	}
+	if out != "true" {
+		return errors.New("not inside a git work tree")
+	}
+	return nil
+}
+
+// gitVersion is a git release as major, minor and patch numbers
+type gitVersion [3]int
+
+func (v gitVersion) String() string {
+	return fmt.Sprintf("%d.%d.%d", v[0], v[1], v[2])
+}
+
+// minGitVersion is the oldest git locsquash supports. The newest features it relies on are
+// rev-parse --is-shallow-repository (2.15) and config --type (2.18).
+var minGitVersion = gitVersion{2, 20, 0}
+
+// gitVersionRe matches the release in git --version output, such as "git version 2.39.3 (Apple Git-146)"
+var gitVersionRe = regexp.MustCompile(`^git version (\d+)\.(\d+)(?:\.(\d+))?`)
+
+// parseGitVersion extracts the release from git --version output. A missing patch number reads as 0.
+func parseGitVersion(out string) (gitVersion, error) {
+	m := gitVersionRe.FindStringSubmatch(strings.TrimSpace(out))
+	if m == nil {
+		return gitVersion{}, fmt.Errorf("unrecognized git version %q", out)
+	}
+	var v gitVersion
+	for i, part := range m[1:] {
+		if part != "" {
+			v[i], _ = strconv.Atoi(part)
+		}
+	}
+	return v, nil
+}
+
+// checkGitVersion refuses to run with a git older than minGitVersion, whose failures would
+// otherwise surface as cryptic errors halfway through. A version that cannot be parsed only
+// produces a warning.
+func checkGitVersion(ctx context.Context, g GitRunner) error {
+	out, err := gitStdout(ctx, g, "--version")
+	if err != nil {
+		return failCodef(exitGit, "Error: cannot run git: %v", err)
+	}
+	v, err := parseGitVersion(out)
+	if err != nil {
+		warnf("%v; locsquash needs git %s or newer.", err, minGitVersion)
+		return nil
+	}
+	if verboseOutput {
+		fmt.Fprintln(os.Stderr, colorizeErr(colorCyan, "Detected git "+v.String()))
+	}
+	if slices.Compare(v[:], minGitVersion[:]) < 0 {
+		return failCodef(exitGit, "Error: git %s is too old; locsquash needs git %s or newer. Please upgrade git.", v, minGitVersion)
+	}
+	return nil
+}
+
+// ensureNoInProgressOps checks that no git operation (rebase, merge, etc.) is in progress
+func ensureNoInProgressOps(ctx context.Context, g GitRunner) error {
+	gitDir, err := gitStdout(ctx, g, "rev-parse", "--absolute-git-dir")
+	if err != nil {
+		return err
+	}
+	for _, ref := range []string{"REBASE_HEAD", "MERGE_HEAD", "CHERRY_PICK_HEAD", "BISECT_LOG"} {
+		if _, sErr := os.Stat(filepath.Join(gitDir, ref)); sErr == nil {
+			return fmt.Errorf("git operation in progress (%s exists); abort/finish it first", ref)
+		}
+	}
+	return nil
+}
+
+// gitDirPath returns the absolute path of name in the git directory of the current worktree.
+// git may run from another directory than ours, so the git directory is asked for as an absolute path.
+func gitDirPath(ctx context.Context, g GitRunner, name string) (string, error) {
+	gitDir, err := gitStdout(ctx, g, "rev-parse", "--absolute-git-dir")
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(gitDir, name), nil
+}
+
+// gitCurrentBranch returns the name of the checked-out branch, or "HEAD" when detached.
+// The name is read from HEAD's symbolic ref rather than abbreviated, so it stays exact when a
+// tag shares the branch's name, and it works on a branch that has no commits yet.
+func gitCurrentBranch(ctx context.Context, g GitRunner) (string, error) {
+	ref, err := gitStdout(ctx, g, "symbolic-ref", "-q", "HEAD")
+	if err != nil {
+		if exitCode(err) == 1 {
+			return "HEAD", nil
+		}
+		return "", err
+	}
+	return strings.TrimPrefix(ref, "refs/heads/"), nil
+}
+
+// gitIsShallow reports whether the repository is a shallow clone with truncated history
+func gitIsShallow(ctx context.Context, g GitRunner) (bool, error) {
+	out, err := gitStdout(ctx, g, "rev-parse", "--is-shallow-repository")
+	if err != nil {
+		return false, err
+	}
+	return out == "true", nil
+}
+
+// gitIsDetached reports whether HEAD is detached (not pointing at a branch)
+func gitIsDetached(ctx context.Context, g GitRunner) (bool, error) {
+	_, err := gitStdout(ctx, g, "symbolic-ref", "-q", "HEAD")
+	if err == nil {
+		return false, nil
+	}
+	if exitCode(err) == 1 {
+		return true, nil
+	}
+	return false, err
+}
+
+// hasUncommittedChanges returns true if there are uncommitted changes in the working directory
+func hasUncommittedChanges(ctx context.Context, g GitRunner) (bool, error) {
+	out, err := gitStdout(ctx, g, "status", "--porcelain")
+	if err != nil {
+		return false, err
+	}
+	return out != "", nil
+}
+
+// hasTrackedChanges checks for uncommitted changes to tracked files, ignoring untracked ones
+func hasTrackedChanges(ctx context.Context, g GitRunner) (bool, error) {
+	out, err := gitStdout(ctx, g, "status", "--porcelain", "--untracked-files=no")
+	if err != nil {
+		return false, err
+	}
+	return out != "", nil
+}
+
+// gitStatusPaths returns the paths that git status reports as changed or untracked.
+// The porcelain v2 format is used since, unlike v1, no line starts with a space that
+// output trimming would eat.
+func gitStatusPaths(ctx context.Context, g GitRunner) ([]string, error) {
+	out, err := gitStdout(ctx, g, "status", "--porcelain=v2", "-z")
+	if err != nil {
+		return nil, err
+	}
+	return parseStatusPaths(out), nil
+}
+
+// parseStatusPaths extracts the paths from NUL-separated git status --porcelain=v2 output
+func parseStatusPaths(out string) []string {
+	var paths []string
+	entries := strings.Split(out, "\x00")
+	for i := 0; i < len(entries); i++ {
+		entry := entries[i]
+		if entry == "" {
+			continue
+		}
+		// Fields before the path: ordinary "1" has 8, renamed/copied "2" has 9, unmerged "u" has 10
+		fields := 0
+		switch entry[0] {
+		case '1':
+			fields = 8
+		case '2':
+			fields = 9
+			i++ // the original path follows as its own entry
+		case 'u':
+			fields = 10
+		case '?', '!':
+			fields = 1
+		default:
+			continue
+		}
+		if parts := strings.SplitN(entry, " ", fields+1); len(parts) == fields+1 {
+			paths = append(paths, parts[fields])
+		}
+	}
+	return paths
+}
+
+// missingStatusPaths returns the paths from want that git status no longer reports as changed
+func missingStatusPaths(ctx context.Context, g GitRunner, want []string) ([]string, error) {
+	if len(want) == 0 {
+		return nil, nil
+	}
+	paths, err := gitStatusPaths(ctx, g)
+	if err != nil {
+		return nil, err
+	}
+	var missing []string
+	for _, p := range want {
+		if !slices.Contains(paths, p) {
+			missing = append(missing, p)
+		}
+	}
+	return missing, nil
+}
+
+// gitHasChangesBetween returns true if there are changes between two refs.
+func gitHasChangesBetween(ctx context.Context, g GitRunner, baseRef, headRef string) (bool, error) {
+	if err := runGitCommand(ctx, g, "diff", "--quiet", baseRef, headRef); err != nil {
+		if exitCode(err) == 1 {
+			return true, nil
+		}
+		return false, err
+	}
+	return false, nil
+}
+
+// gitMergeCommits returns the short hashes of merge commits in baseRef..headRef
+func gitMergeCommits(ctx context.Context, g GitRunner, baseRef, headRef string) ([]string, error) {
+	out, err := gitStdout(ctx, g, "rev-list", "--merges", "--abbrev-commit", baseRef+".."+headRef)
+	if err != nil {
+		return nil, err
+	}
+	if out == "" {
+		return nil, nil
+	}
+	return strings.Split(out, "\n"), nil
+}
+
+// gitRemoteBranchesContaining returns the remote-tracking branches that contain the given commit
+func gitRemoteBranchesContaining(ctx context.Context, g GitRunner, ref string) ([]string, error) {
+	out, err := gitStdout(ctx, g, "for-each-ref", "--contains", ref, "--format=%(refname:short)", "refs/remotes")
+	if err != nil {
+		return nil, err
+	}
+	var branches []string
+	for line := range strings.SplitSeq(out, "\n") {
+		// Skip symbolic refs such as origin/HEAD
+		if line != "" && !strings.HasSuffix(line, "/HEAD") {
+			branches = append(branches, line)
+		}
+	}
+	return branches, nil
+}
+
+// gitDiffStat returns the `git diff --stat` summary between two refs
+func gitDiffStat(ctx context.Context, g GitRunner, baseRef, headRef string) (string, error) {
+	return gitStdout(ctx, g, "diff", "--stat", baseRef, headRef)
+}
+
+// DiffStats summarizes the changes introduced by a commit
+type DiffStats struct {
+	FilesChanged int `json:"files_changed"`
+	Insertions   int `json:"insertions"`
+	Deletions    int `json:"deletions"`
+}
+
+// String formats the stats like git's --shortstat line
+func (s DiffStats) String() string {
+	plural := func(n int, noun string) string {
+		if n == 1 {
+			return fmt.Sprintf("%d %s", n, noun)
+		}
+		return fmt.Sprintf("%d %ss", n, noun)
+	}
+	return fmt.Sprintf("%s changed, %s(+), %s(-)", plural(s.FilesChanged, "file"), plural(s.Insertions, "insertion"), plural(s.Deletions, "deletion"))
+}
+
+// gitCommitStats returns the number of files changed, insertions and deletions of commit
+func gitCommitStats(ctx context.Context, g GitRunner, commit string) (DiffStats, error) {
+	out, err := gitStdout(ctx, g, "show", "--shortstat", "--format=", commit)
+	if err != nil {
+		return DiffStats{}, err
+	}
+	return parseShortStat(out), nil
+}
+
+// gitCommitIsSigned reports whether commit carries a GPG signature, read from its header so gpg is not needed
+func gitCommitIsSigned(ctx context.Context, g GitRunner, commit string) (bool, error) {
+	out, err := gitStdout(ctx, g, "cat-file", "commit", commit)
+	if err != nil {
+		return false, err
+	}
+	header, _, _ := strings.Cut(out, "\n\n")
+	for line := range strings.SplitSeq(header, "\n") {
+		if strings.HasPrefix(line, "gpgsig ") || strings.HasPrefix(line, "gpgsig-sha256 ") {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// gitVerifyCommit checks the GPG signature of commit with git verify-commit
+func gitVerifyCommit(ctx context.Context, g GitRunner, commit string) error {
+	_, err := gitStdout(ctx, g, "verify-commit", commit)
+	return err
+}
+
+// parseShortStat parses a --shortstat line such as " 2 files changed, 5 insertions(+), 1 deletion(-)".
+// git leaves out the parts that are zero, and prints nothing at all for an empty commit.
+func parseShortStat(line string) DiffStats {
+	var s DiffStats
+	for part := range strings.SplitSeq(strings.TrimSpace(line), ",") {
+		fields := strings.Fields(part)
+		if len(fields) < 2 {
+			continue
+		}
+		n, err := strconv.Atoi(fields[0])
+		if err != nil {
+			continue
+		}
+		switch {
+		case strings.HasPrefix(fields[1], "file"):
+			s.FilesChanged = n
+		case strings.HasPrefix(fields[1], "insertion"):
+			s.Insertions = n
+		case strings.HasPrefix(fields[1], "deletion"):
+			s.Deletions = n
+		}
+	}
+	return s
+}
+
+// Messages of the stash entries created by locsquash. An auto-stash is always dropped once
+// it has been reapplied, so one that is still listed was left behind by a failed run.
+const (
+	autoStashMessage    = "locsquash auto-stash"
+	recoverStashMessage = "locsquash recover-last"
+)
+
+// stashPushArgs returns the git arguments that create a stash with the given message. include
+// is "-u" to also stash untracked files, "-a" to stash untracked and ignored files, or empty for neither.
+func stashPushArgs(include, message string) []string {
+	args := []string{"stash", "push"}
+	if include != "" {
+		args = append(args, include)
+	}
+	return append(args, "-m", message)
+}
+
+// gitLeftoverAutoStashes returns the stash entries (e.g. stash@{1}) holding an auto-stash
+// of an earlier run
+func gitLeftoverAutoStashes(ctx context.Context, g GitRunner) ([]string, error) {
+	out, err := gitStdout(ctx, g, "stash", "list", "--format=%gd%x00%gs")
+	if err != nil {
+		return nil, err
+	}
+	var refs []string
+	for line := range strings.SplitSeq(out, "\n") {
+		// The subject reads "On <branch>: <message>"
+		ref, subject, _ := strings.Cut(line, "\x00")
+		if strings.HasSuffix(subject, ": "+autoStashMessage) {
+			refs = append(refs, ref)
+		}
+	}
+	return refs, nil
+}
+
+// stashPushAndGetRef stashes uncommitted changes and returns the stash reference
+func stashPushAndGetRef(ctx context.Context, g GitRunner, include, message string) (string, error) {
+	if err := runGitCommand(ctx, g, stashPushArgs(include, message)...); err != nil {
+		return "", err
+	}
+	if _, err := gitStdout(ctx, g, "rev-parse", "-q", "--verify", "refs/stash"); err != nil {
+		return "", errors.New("stash push reported success but refs/stash not found")
+	}
+	return "stash@{0}", nil
+}
+
+// gitCommitCount returns the total number of commits reachable from top
+func gitCommitCount(ctx context.Context, g GitRunner, top string) (int, error) {
+	out, err := gitStdout(ctx, g, "rev-list", "--count", top)
+	if err != nil {
+		return 0, fmt.Errorf("cannot count commits (does %s exist?)", top)
+	}
+	n, err := strconv.Atoi(out)
+	if err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+// commitRange describes a block of first-parent commits selected for squashing
+type commitRange struct {
+	Top    string // Newest commit in the range
+	Count  int    // Number of commits in the range
+	Replay int    // Number of commits newer than Top that must be replayed on top of the squash
+}
+
+// gitBranchWorktree returns the path of the worktree that has branch checked out, or ""
+func gitBranchWorktree(ctx context.Context, g GitRunner, branch string) (string, error) {
+	out, err := gitStdout(ctx, g, "worktree", "list", "--porcelain")
+	if err != nil {
+		return "", err
+	}
+	path := ""
+	for line := range strings.SplitSeq(out, "\n") {
+		if p, ok := strings.CutPrefix(line, "worktree "); ok {
+			path = p
+		} else if line == "branch refs/heads/"+branch {
+			return path, nil
+		}
+	}
+	return "", nil
+}
+
+// gitResolveCommit resolves ref to a full commit hash
+func gitResolveCommit(ctx context.Context, g GitRunner, ref string) (string, error) {
+	sha, err := gitStdout(ctx, g, "rev-parse", "-q", "--verify", ref+"^{commit}")
+	if err != nil {
+		return "", fmt.Errorf("ref %q does not exist or is not a commit", ref)
+	}
+	return sha, nil
+}
+
+// gitIsAncestor reports whether ancestor is reachable from descendant
+func gitIsAncestor(ctx context.Context, g GitRunner, ancestor, descendant string) bool {
+	_, err := gitStdout(ctx, g, "merge-base", "--is-ancestor", ancestor, descendant)
+	return err == nil
+}
+
+// gitFirstParentDistance returns N such that top~N is base.
+// It fails if base is not on the first-parent history of top.
+func gitFirstParentDistance(ctx context.Context, g GitRunner, base, top string) (int, error) {
+	out, err := gitStdout(ctx, g, "rev-list", "--first-parent", "--count", base+".."+top)
+	if err != nil {
+		return 0, err
+	}
+	n, err := strconv.Atoi(out)
+	if err != nil {
+		return 0, err
+	}
+	sha, err := gitStdout(ctx, g, "rev-parse", "-q", "--verify", fmt.Sprintf("%s~%d", top, n))
+	if err != nil || sha != base {
+		return 0, errors.New("not on the first-parent history")
+	}
+	return n, nil
+}
+
+// gitCountToRef returns the number of first-parent commits between ref (exclusive) and top.
+// It fails if ref does not resolve or is not on the first-parent history of top,
+// since the squash is performed by resetting to top~N.
+func gitCountToRef(ctx context.Context, g GitRunner, ref, top string) (int, error) {
+	refSHA, err := gitResolveCommit(ctx, g, ref)
+	if err != nil {
+		return 0, err
+	}
+	if !gitIsAncestor(ctx, g, refSHA, top) {
+		return 0, fmt.Errorf("ref %q is not an ancestor of %s", ref, top)
+	}
+	n, err := gitFirstParentDistance(ctx, g, refSHA, top)
+	if err != nil {
+		return 0, fmt.Errorf("ref %q is %w of %s", ref, err, top)
+	}
+	return n, nil
+}
+
+// wipBatch is how many subjects gitCountWIP reads per git log call
+const wipBatch = 100
+
+// gitCountWIP counts the consecutive first-parent commits from top whose subject matches wip.
+// The count is -1 if every commit down to the root matches, leaving nothing to fold them into.
+func gitCountWIP(ctx context.Context, g GitRunner, top string, wip *regexp.Regexp) (int, error) {
+	for skip := 0; ; skip += wipBatch {
+		out, err := gitStdout(ctx, g, "log", "--first-parent", "--format=%s", "--skip="+strconv.Itoa(skip), "-"+strconv.Itoa(wipBatch), top)
+		if err != nil {
+			return 0, err
+		}
+		subjects := strings.Split(out, "\n")
+		if out == "" {
+			subjects = nil
+		}
+		for i, subject := range subjects {
+			if !wip.MatchString(subject) {
+				return skip + i, nil
+			}
+		}
+		if len(subjects) < wipBatch {
+			return -1, nil
+		}
+	}
+}
+
+// gitResolveRange resolves the inclusive range fromRef..toRef (toRef defaults to HEAD).
+// Commits newer than toRef are counted so they can be replayed after the squash.
+func gitResolveRange(ctx context.Context, g GitRunner, fromRef, toRef string) (commitRange, error) {
+	if toRef == "" {
+		toRef = "HEAD"
+	}
+	topSHA, err := gitResolveCommit(ctx, g, toRef)
+	if err != nil {
+		return commitRange{}, err
+	}
+	fromSHA, err := gitResolveCommit(ctx, g, fromRef)
+	if err != nil {
+		return commitRange{}, err
+	}
+	if !gitIsAncestor(ctx, g, topSHA, "HEAD") {
+		return commitRange{}, fmt.Errorf("ref %q is not an ancestor of HEAD", toRef)
+	}
+	if !gitIsAncestor(ctx, g, fromSHA, topSHA) {
+		return commitRange{}, fmt.Errorf("-from %q is not an ancestor of -to %q", fromRef, toRef)
+	}
+	baseSHA, err := gitStdout(ctx, g, "rev-parse", "-q", "--verify", fromSHA+"^")
+	if err != nil {
+		return commitRange{}, fmt.Errorf("-from %q is the root commit; one commit must remain as the base", fromRef)
+	}
+	count, err := gitFirstParentDistance(ctx, g, baseSHA, topSHA)
+	if err != nil {
+		return commitRange{}, fmt.Errorf("-from %q is %w of -to %q", fromRef, err, toRef)
+	}
+
+	out, err := gitStdout(ctx, g, "rev-list", "--first-parent", "--count", topSHA+"..HEAD")
+	if err != nil {
+		return commitRange{}, err
+	}
+	replay, err := strconv.Atoi(out)
+	if err != nil {
+		return commitRange{}, err
+	}
+	merges, err := gitStdout(ctx, g, "rev-list", "--merges", topSHA+"..HEAD")
+	if err != nil {
+		return commitRange{}, err
+	}
+	if merges != "" {
+		return commitRange{}, fmt.Errorf("commits newer than %q include merge commits, which cannot be replayed", toRef)
+	}
+	return commitRange{Top: topSHA, Count: count, Replay: replay}, nil
+}
+
+// gitLogSingle retrieves a single piece of information from a commit
+func gitLogSingle(ctx context.Context, g GitRunner, ref, formatStr string) (string, error) {
+	return gitStdout(ctx, g, "log", "-1", "--format="+formatStr, ref)
+}
+
+// collectCommits retrieves the commits in resetRef..topRef, newest first, with a single git log call.
+// With withStats, the insertions and deletions of every commit are read in the same call.
+func collectCommits(ctx context.Context, g GitRunner, resetRef, topRef string, withStats bool) ([]CommitInfo, error) {
+	// Use --first-parent to match HEAD~N traversal used by git reset
+	const format = "%h%x00%cr%x00%an%x00%s"
+	args := []string{"log", "--first-parent", "--format=" + format}
+	if withStats {
+		// Mark the start of every record, since a shortstat line follows each commit line
+		args = []string{"log", "--first-parent", "--shortstat", "--format=%x01" + format}
+	}
+	out, err := gitStdout(ctx, g, append(args, resetRef+".."+topRef)...)
+	if err != nil {
+		return nil, err
+	}
+	if withStats {
+		return parseCommitStats(out), nil
+	}
+	return parseCommitList(out), nil
+}
+
+// parseCommitStats parses git log --shortstat output whose records start with \x01: a commit
+// line as read by parseCommitList, then a shortstat line that git leaves out for empty commits
+func parseCommitStats(out string) []CommitInfo {
+	var commits []CommitInfo
+	for record := range strings.SplitSeq(out, "\x01") {
+		line, stat, _ := strings.Cut(record, "\n")
+		parsed := parseCommitList(line)
+		if len(parsed) != 1 {
+			continue
+		}
+		s := parseShortStat(stat)
+		parsed[0].Additions, parsed[0].Deletions = s.Insertions, s.Deletions
+		commits = append(commits, parsed[0])
+	}
+	return commits
+}
+
+// parseCommitList parses one commit per line: short hash, relative date, author name and
+// subject separated by NUL bytes, which cannot occur in any of the fields
+func parseCommitList(out string) []CommitInfo {
+	lines := strings.Split(out, "\n")
+	commits := make([]CommitInfo, 0, len(lines))
+	for _, line := range lines {
+		if line == "" {
+			continue
+		}
+		parts := strings.SplitN(line, "\x00", 4)
+		if len(parts) == 4 {
+			commits = append(commits, CommitInfo{Hash: parts[0], Date: parts[1], Author: parts[2], Subject: parts[3]})
+		}
+	}
+	return commits
+}
+
+// gitLogMessages retrieves the full messages of count first-parent commits ending at topRef,
+// oldest first
+func gitLogMessages(ctx context.Context, g GitRunner, topRef string, count int) ([]string, error) {
+	// NUL-terminate each message since bodies may contain any other separator
+	out, err := gitStdout(ctx, g, "log", "--first-parent", "--reverse", "-"+strconv.Itoa(count), "--format=%B%x00", topRef)
+	if err != nil {
+		return nil, err
+	}
+	parts := strings.Split(out, "\x00")
+	messages := make([]string, 0, len(parts))
+	for _, p := range parts {
+		messages = append(messages, strings.TrimSpace(p))
+	}
+	return messages, nil
+}
+
+// commitOptions describes how the squashed commit is created
+type commitOptions struct {
+	Date       string // ISO committer date
+	AuthorDate string // ISO author date (defaults to Date)
+	Message    string // Full commit message
+	AllowEmpty bool   // Allow the commit to have no changes
+	Amend      bool   // Amend HEAD instead of creating a new commit
+	Author     string // Optional author override in "Name <email>" form
+	Committer  string // Optional committer override in "Name <email>" form
+	NoVerify   bool   // Skip the pre-commit and commit-msg hooks
+	Cleanup    string // git commit --cleanup mode, or "" for git's default
+	Sign       bool   // GPG-sign the commit with the default key
+	SignKey    string // GPG-sign the commit with this key id
+}
+
+// signArg returns the --gpg-sign argument, or "" if the commit is not signed
+func (o commitOptions) signArg() string {
+	switch {
+	case o.SignKey != "":
+		return "--gpg-sign=" + o.SignKey
+	case o.Sign:
+		return "--gpg-sign"
+	default:
+		return ""
+	}
+}
+
+// authorDate returns the author date, falling back to the committer date
+func (o commitOptions) authorDate() string {
+	if o.AuthorDate != "" {
+		return o.AuthorDate
+	}
+	return o.Date
+}
+
+// noEditorEnv keeps git from ever opening an editor for the commits locsquash creates, which would
+// hang in CI. The message is always passed with -m, and -edit runs the editor before git is involved.
+var noEditorEnv = []string{"GIT_EDITOR=true"}
+
+// commitArgs returns the git commit arguments for the options
+func (o commitOptions) commitArgs() []string {
+	args := []string{"commit"}
+	if o.Amend {
+		args = append(args, "--amend")
+	}
+	if o.Author != "" {
+		args = append(args, "--author", o.Author)
+	}
+	if o.AllowEmpty {
+		args = append(args, "--allow-empty")
+	}
+	if o.NoVerify {
+		args = append(args, "--no-verify")
+	}
+	if o.Cleanup != "" {
+		args = append(args, "--cleanup="+o.Cleanup)
+	}
+	if sign := o.signArg(); sign != "" {
+		args = append(args, sign)
+	}
+	return append(args, "-m", o.Message)
+}
+
+// commitEnv returns the extra environment for git commit.
+// Author and committer dates are set independently so they can differ.
+func (o commitOptions) commitEnv() []string {
+	return append([]string{"GIT_AUTHOR_DATE=" + o.authorDate(), "GIT_COMMITTER_DATE=" + o.Date}, o.committerEnv()...)
+}
+
+// committerEnv returns the environment that sets the committer identity, which git commit
+// has no flag for, or nil to let git take it from the config
+func (o commitOptions) committerEnv() []string {
+	name, email, err := parseAuthor(o.Committer)
+	if err != nil {
+		return nil
+	}
+	return []string{"GIT_COMMITTER_NAME=" + name, "GIT_COMMITTER_EMAIL=" + email}
+}
+
+// commitTreeArgs returns the git commit-tree arguments that build a commit from treeRef on top of parent
+func (o commitOptions) commitTreeArgs(treeRef, parent string) []string {
+	args := []string{"commit-tree", treeRef + "^{tree}", "-p", parent}
+	if sign := o.signArg(); sign != "" {
+		args = append(args, sign)
+	}
+	// commit-tree stores the message as given, so the cleanup git commit would do happens here
+	return append(args, "-m", cleanupForMode(o.Message, o.Cleanup))
+}
+
+// rebaseOntoArgs returns the git rebase arguments that move the commits after upstream onto newBase.
+// A signed squash also signs the commits the rebase rewrites, which would otherwise lose their signatures.
+func (o commitOptions) rebaseOntoArgs(newBase, upstream string) []string {
+	args := []string{"rebase"}
+	if sign := o.signArg(); sign != "" {
+		args = append(args, sign)
+	}
+	return append(args, "--onto", newBase, upstream)
+}
+
+// commitTreeEnv returns the extra environment for git commit-tree, which has no --date or --author flags
+func (o commitOptions) commitTreeEnv() []string {
+	env := []string{"GIT_AUTHOR_DATE=" + o.authorDate(), "GIT_COMMITTER_DATE=" + o.Date}
+	if name, email, err := parseAuthor(o.Author); err == nil {
+		env = append(env, "GIT_AUTHOR_NAME="+name, "GIT_AUTHOR_EMAIL="+email)
+	}
+	return append(env, o.committerEnv()...)
+}
+
+// authorRe matches "Name <email>"
+var authorRe = regexp.MustCompile(`^\s*([^<>]*[^<>\s])\s*<([^<>\s]+)>\s*$`)
+
+// parseAuthor splits an author in "Name <email>" form into its name and email
+func parseAuthor(author string) (string, string, error) {
+	m := authorRe.FindStringSubmatch(author)
+	if m == nil {
+		return "", "", fmt.Errorf("author %q must be in the form \"Name <email>\"", author)
+	}
+	return m[1], m[2], nil
+}
+
+// gitSignoffIdent returns "Name <email>" from user.name and user.email for a Signed-off-by trailer
+func gitSignoffIdent(ctx context.Context, g GitRunner) (string, error) {
+	name, nameErr := gitStdout(ctx, g, "config", "user.name")
+	email, emailErr := gitStdout(ctx, g, "config", "user.email")
+	if nameErr != nil || emailErr != nil || name == "" || email == "" {
+		return "", errors.New("user.name and user.email must be configured to add a sign-off")
+	}
+	return fmt.Sprintf("%s <%s>", name, email), nil
+}
+
+// gitCommitWithDates creates the squashed commit from the staged changes with the given author and committer dates
+func gitCommitWithDates(ctx context.Context, g GitRunner, opts commitOptions) error {
+	return g.Run(ctx, append(opts.commitEnv(), noEditorEnv...), opts.commitArgs()...)
+}
+
+// gitCommitTree creates a commit with the tree of treeRef on top of parent without
+// touching HEAD or the index, and returns the new commit hash
+func gitCommitTree(ctx context.Context, g GitRunner, treeRef, parent string, opts commitOptions) (string, error) {
+	return g.Stdout(ctx, append(opts.commitTreeEnv(), noEditorEnv...), opts.commitTreeArgs(treeRef, parent)...)
+}
+
+// BackupRef holds information about a backup branch or tag
+type BackupRef struct {
+	Name      string    // Short ref name (e.g., locsquash/backup-20240115-143022)
+	Tag       bool      // Whether the backup is a tag rather than a branch
+	CommitRef string    // Short commit hash the ref points to
+	Subject   string    // Commit subject
+	Created   time.Time // When the backup was created
+}
+
+// backupTimestampRe matches the timestamp (and optional collision suffix) at the end of a backup name
+var backupTimestampRe = regexp.MustCompile(`(\d{8}-\d{6})(-\d+)?$`)
+
+// listBackupRefs returns all branches and tags whose names start with prefix, newest first
+func listBackupRefs(ctx context.Context, g GitRunner, prefix string) ([]BackupRef, error) {
+	// Format: refname + tab + objectname:short + tab + creatordate (unix) + tab + subject
+	out, err := gitStdout(ctx, g, "for-each-ref",
+		"--format=%(refname)\t%(objectname:short)\t%(creatordate:unix)\t%(subject)",
+		"refs/heads/"+prefix+"*",
+		"refs/tags/"+prefix+"*")
+	if err != nil {
+		return nil, err
+	}
+	if out == "" {
+		return nil, nil
+	}
+	lines := strings.Split(out, "\n")
+	refs := make([]BackupRef, 0, len(lines))
+	for _, line := range lines {
+		parts := strings.SplitN(line, "\t", 4)
+		if len(parts) < 3 {
+			continue
+		}
+		b := BackupRef{CommitRef: parts[1]}
+		switch {
+		case strings.HasPrefix(parts[0], "refs/heads/"):
+			b.Name = strings.TrimPrefix(parts[0], "refs/heads/")
+		case strings.HasPrefix(parts[0], "refs/tags/"):
+			b.Name = strings.TrimPrefix(parts[0], "refs/tags/")
+			b.Tag = true
+		default:
+			continue
+		}
+		if len(parts) == 4 {
+			b.Subject = parts[3]
+		}
+		b.Created = backupCreated(b.Name, parts[2])
+		refs = append(refs, b)
+	}
+	sort.SliceStable(refs, func(i, j int) bool { return refs[i].Created.After(refs[j].Created) })
+	return refs, nil
+}
+
+// backupCreated derives a backup's creation time from the timestamp embedded in its name,
+// falling back to the ref's creator date (the commit date for branches and lightweight tags)
+func backupCreated(name, creatorUnix string) time.Time {
+	if m := backupTimestampRe.FindStringSubmatch(name); m != nil {
+		if t, err := time.Parse(backupTimeFormat, m[1]); err == nil {
+			return t
+		}
+	}
+	sec, err := strconv.ParseInt(creatorUnix, 10, 64)
+	if err != nil {
+		return time.Time{}
+	}
+	return time.Unix(sec, 0).UTC()
+}
+
+// deleteBackupRef deletes a backup branch or tag
+func deleteBackupRef(ctx context.Context, g GitRunner, b BackupRef) error {
+	if b.Tag {
+		_, err := gitStdout(ctx, g, "tag", "-d", b.Name)
+		return err
+	}
+	_, err := gitStdout(ctx, g, "branch", "-D", b.Name)
+	return err
+}
@@ -0,0 +1,148 @@
+package squash
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// historyFileName is the append-only log of rewrites kept inside the git directory
+const historyFileName = "locsquash-history.log"
+
+// HistoryEntry is one squash recorded in the history log
+type HistoryEntry struct {
+	Time         time.Time `json:"time"`             // When the squash finished
+	Count        int       `json:"count"`            // Number of commits rewritten
+	Backup       string    `json:"backup,omitempty"` // Backup created for the squash, empty with -no-backup
+	BackupExists bool      `json:"backup_exists"`    // Whether the backup branch or tag still exists
+	Before       string    `json:"before,omitempty"` // HEAD before the squash
+	After        string    `json:"after,omitempty"`  // HEAD after the squash
+}
+
+// historyPath returns the path of the history log in the current repository's git directory
+func historyPath(ctx context.Context, g GitRunner) (string, error) {
+	return gitDirPath(ctx, g, historyFileName)
+}
+
+// historyLine formats one history entry: UTC time, number of commits rewritten, backup name,
+// and HEAD before and after, separated by tabs. Missing values are written as "-".
+func historyLine(at time.Time, count int, backup, before, after string) string {
+	fields := []string{at.UTC().Format(time.RFC3339), strconv.Itoa(count), backup, before, after}
+	for i, f := range fields {
+		if f == "" {
+			fields[i] = "-"
+		}
+	}
+	return strings.Join(fields, "\t") + "\n"
+}
+
+// appendHistory adds an entry for the rewrite described by info to the history log, creating
+// the file if needed
+func appendHistory(ctx context.Context, g GitRunner, info *SquashInfo) error {
+	path, err := historyPath(ctx, g)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600) //nolint:gosec // path is inside the git directory
+	if err != nil {
+		return err
+	}
+	if _, err = fmt.Fprint(f, historyLine(time.Now(), info.SquashCount, info.BackupName, info.PreHead, info.ResultCommit)); err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// parseHistory reads the entries of a history log, oldest first. Lines that do not parse, such
+// as ones cut short by a crash, are skipped.
+func parseHistory(data string) []HistoryEntry {
+	var entries []HistoryEntry
+	for line := range strings.SplitSeq(data, "\n") {
+		fields := strings.Split(line, "\t")
+		if len(fields) != 5 {
+			continue
+		}
+		at, tErr := time.Parse(time.RFC3339, fields[0])
+		count, cErr := strconv.Atoi(fields[1])
+		if tErr != nil || cErr != nil {
+			continue
+		}
+		for i, f := range fields {
+			if f == "-" {
+				fields[i] = ""
+			}
+		}
+		entries = append(entries, HistoryEntry{Time: at, Count: count, Backup: fields[2], Before: fields[3], After: fields[4]})
+	}
+	return entries
+}
+
+// showHistory prints the squashes recorded in the history log, most recent first, noting which
+// backups still exist
+func showHistory(ctx context.Context, g GitRunner, input UserInput) error {
+	if err := ensureInsideGitRepo(ctx, g); err != nil {
+		return failCodef(exitUsage, "Error: %v", err)
+	}
+	path, err := historyPath(ctx, g)
+	if err != nil {
+		return failCodef(exitGit, "Error: %v", err)
+	}
+	data, err := os.ReadFile(path) //nolint:gosec // path is inside the git directory
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return failf("Error reading the squash history: %v", err)
+	}
+	entries := parseHistory(string(data))
+	slices.Reverse(entries)
+	for i, e := range entries {
+		if e.Backup != "" {
+			entries[i].BackupExists = refExists(ctx, g, "refs/heads/"+e.Backup) || refExists(ctx, g, "refs/tags/"+e.Backup)
+		}
+	}
+
+	if input.JSON {
+		if entries == nil {
+			entries = []HistoryEntry{}
+		}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err = enc.Encode(entries); err != nil {
+			return failf("Error encoding JSON output: %v", err)
+		}
+		return nil
+	}
+	if len(entries) == 0 {
+		statusln("No squashes recorded yet.")
+		return nil
+	}
+	statusf("%d recorded squash(es), most recent first:\n\n", len(entries))
+	for _, e := range entries {
+		backup := "no backup"
+		switch {
+		case e.BackupExists:
+			backup = colorize(colorGreen, sanitizeForTerminal(e.Backup)) + " (still exists)"
+		case e.Backup != "":
+			backup = sanitizeForTerminal(e.Backup) + " (deleted)"
+		}
+		statusf("  %s  %s  %s..%s  %s\n",
+			colorize(colorCyan, e.Time.Local().Format("2006-01-02 15:04")),
+			padLeft(strconv.Itoa(e.Count), 3)+" commits",
+			colorize(colorYellow, shortHash(e.Before)), colorize(colorYellow, shortHash(e.After)),
+			backup)
+	}
+	return nil
+}
+
+// shortHash abbreviates a full commit hash for display, or returns "-" if it is unknown
+func shortHash(hash string) string {
+	if hash == "" {
+		return "-"
+	}
+	return hash[:min(len(hash), 7)]
+}
@@ -0,0 +1,237 @@
+package squash
+
+import "strings"
+
+// UserInput holds CLI flags provided by the user
+type UserInput struct {
+	SquashCount      int      // Number of recent commits to squash
+	ToRef            string   // Ref to squash down to (exclusive); with FromRef, the newest commit of the range
+	FromRef          string   // Oldest commit of a range to squash (inclusive)
+	Unpushed         bool     // Squash the commits that are ahead of the upstream branch
+	SinceTag         string   // Squash the commits after this tag
+	SinceLatestTag   bool     // Squash the commits after the most recent tag
+	OntoRef          string   // Ref to move the squashed commit onto
+	Branch           string   // Branch to squash without checking it out
+	NewMessage       string   // Custom commit message
+	MessageFile      string   // File to read the commit message from ("-" for stdin)
+	MessageFrom      string   // Commit whose message is the default: "oldest" or "newest"
+	Author           string   // Author override for the squashed commit ("Name <email>")
+	Date             string   // Explicit date for the squashed commit (RFC 3339)
+	KeepAuthor       bool     // Preserve the oldest commit's author when Author is not set
+	KeepCommitter    bool     // Use the newest commit's committer instead of the git config
+	NoVerify         bool     // Skip pre-commit and commit-msg hooks
+	Sign             bool     // GPG-sign the squashed commit
+	SignKey          string   // GPG key id used to sign the squashed commit
+	ConcatMessages   bool     // Combine all squashed commit messages into the result
+	StripComments    bool     // Remove comment lines and repeated blank lines from the commit message
+	Wrap             int      // Word-wrap the commit message body at this many columns; 0 disables wrapping
+	Cleanup          string   // git commit --cleanup mode for the squashed commit message (default strip)
+	Signoff          bool     // Append a Signed-off-by trailer to the commit message
+	CollectCoauthors bool     // Append the Co-authored-by trailers of all squashed commits to the message
+	Trailers         []string // Extra "Key: value" trailers to append to the commit message
+	Conventional     bool     // Require the commit message subject to follow Conventional Commits
+	CommitTypes      string   // Comma-separated commit types accepted by Conventional
+	Edit             bool     // Edit the commit message in $EDITOR before committing
+	CommitTemplate   bool     // Start from the commit.template file instead of the oldest commit's message
+	Reword           bool     // Only rewrite the latest commit's message instead of squashing
+	Autofixup        bool     // Fold unpushed fixup!/squash! commits into their targets instead of squashing
+	AmendBase        bool     // Fold the selected commits into the commit below them instead of creating a new one
+	SquashMergesOnly bool     // Fold the commits after the newest merge into that merge, keeping its parents
+	SquashWIP        bool     // Fold the WIP commits at the tip into the first commit below them that is not WIP
+	WIPPattern       string   // Regular expression matched against subjects by SquashWIP
+	AllowStash       bool     // Auto-stash uncommitted changes before squashing
+	StashUntracked   bool     // Include untracked files in the auto-stash
+	StashAll         bool     // Include untracked and ignored files in the auto-stash
+	AllowEmpty       bool     // Allow empty commits if squashed changes cancel out
+	AllowMerges      bool     // Allow squashing across merge commits
+	ForcePushed      bool     // Allow squashing commits already pushed to a remote
+	MaxAge           string   // Refuse to squash if the oldest commit is older than this age
+	Protected        string   // Additional comma-separated protected branch names
+	Force            bool     // Override safety guards such as the protected-branch check
+	DryRun           bool     // Print planned commands without executing
+	DumpPlan         string   // Write the computed plan as JSON to this file; implies DryRun
+	Plan             string   // Execute the squash recorded in this -dump-plan file
+	DryRunExitCode   bool     // Dry run that signals through the exit code whether the squash is viable
+	ShowDiff         bool     // Include the combined diff in the dry-run output
+	Stat             bool     // Show insertions and deletions per commit in the commit list
+	JSON             bool     // Emit the dry-run plan and result summary as JSON
+	Quiet            bool     // Suppress progress messages
+	Verbose          bool     // Echo git commands before running them
+	PrintRecovery    bool     // Print recovery instructions and exit
+	NoBackup         bool     // Skip creating backup branch
+	BackupPrefix     string   // Prefix of backup ref names; a timestamp is appended
+	TagBackup        bool     // Create the backup as a lightweight tag instead of a branch
+	PushBackup       string   // Remote to push the backup to once it is created
+	KeepBackup       bool     // Keep the backup after a successful squash
+	NoHistory        bool     // Don't append successful squashes to the history log
+	Exec             string   // Shell command to run after a successful squash
+	NoAutoRecover    bool     // Leave the repository as-is on failure instead of resetting to the backup
+	Preview          bool     // Always show the diffstat before confirming
+	Yes              bool     // Skip confirmation prompt
+	YesIfClean       bool     // Skip the confirmation prompt when the working tree has no uncommitted changes
+	WarnThreshold    int      // Ask again before squashing more commits than this, unless -yes; 0 disables the check
+	ListBackups      bool     // List all backup branches and exit
+	PruneBackups     string   // Delete backups older than this age and exit
+	RenameBackup     string   // Backup to rename to RenameBackupTo, then exit
+	RenameBackupTo   string   // New name for RenameBackup
+	RecoverLast      bool     // Undo the most recent squash from its recovery manifest and exit
+	History          bool     // Print the squashes recorded in the history log and exit
+}
+
+// DefaultWIPPattern matches the subjects of the commits folded by -squash-wip unless overridden
+const DefaultWIPPattern = `^(wip|WIP|fixup!|tmp)`
+
+// DefaultBackupPrefix is prepended to the timestamp to build backup ref names
+const DefaultBackupPrefix = "locsquash/backup-"
+
+// backupTimeFormat is the UTC timestamp layout embedded in backup names
+const backupTimeFormat = "20060102-150405"
+
+// backupNamespace returns the part of the backup prefix up to its last slash ("locsquash/"
+// by default), which -rename-backup keeps backups under; a prefix without a slash is its own namespace
+func (input UserInput) backupNamespace() string {
+	prefix := input.backupPrefix()
+	if i := strings.LastIndex(prefix, "/"); i >= 0 {
+		return prefix[:i+1]
+	}
+	return prefix
+}
+
+// wipPattern returns the regular expression for -squash-wip, falling back to the default
+func (input UserInput) wipPattern() string {
+	if input.WIPPattern == "" {
+		return DefaultWIPPattern
+	}
+	return input.WIPPattern
+}
+
+// backupPrefix returns the prefix for backup ref names, falling back to the default
+func (input UserInput) backupPrefix() string {
+	if input.BackupPrefix == "" {
+		return DefaultBackupPrefix
+	}
+	return input.BackupPrefix
+}
+
+// CommitInfo holds information about a single commit
+type CommitInfo struct {
+	Hash      string `json:"hash"`      // Short commit hash
+	Date      string `json:"date"`      // Relative committer date (e.g. "2 hours ago")
+	Author    string `json:"author"`    // Author name
+	Subject   string `json:"subject"`   // First line of commit message
+	Additions int    `json:"additions"` // Lines inserted by the commit (-stat and -json only)
+	Deletions int    `json:"deletions"` // Lines deleted by the commit (-stat and -json only)
+}
+
+// SquashInfo extends UserInput with computed values relevant to the squash operation
+type SquashInfo struct {
+	UserInput
+	BackupName      string       // Name of the backup branch created before squashing
+	RecentDate      string       // ISO committer date of the most recent commit
+	AuthorDate      string       // ISO author date of the most recent commit
+	CommitAuthor    string       // Author for the squashed commit ("Name <email>"), empty to use git config
+	CommitCommitter string       // Committer for the squashed commit ("Name <email>"), empty to use git config
+	ResetRef        string       // Git ref to reset to (HEAD~N)
+	BaseCommit      string       // Full hash of ResetRef, recorded when the result is moved with OntoRef
+	TopRef          string       // Newest commit in the squash range (HEAD unless a range is replayed)
+	ReplayCount     int          // Number of commits newer than TopRef replayed after the squash
+	CommitMessage   string       // Final commit message for the squashed commit
+	Dirty           bool         // Whether working directory has uncommitted changes
+	Commits         []CommitInfo // List of commits that will be squashed
+	Diff            string       // Combined diff of the squashed commits (dry-run with -show-diff)
+	WouldFail       bool         // A check only passed because of the dry run; a real run would fail
+	ResultCommit    string       // Full hash of the squashed HEAD, once the squash succeeded
+	BranchTip       string       // Full hash of Branch's tip before the squash (-branch only)
+	Stats           *DiffStats   // Changes introduced by ResultCommit, once the squash succeeded
+	BackupPushed    bool         // Whether the backup was pushed to PushBackup
+	PreHead         string       // Full hash of the rewritten branch's tip before the squash
+	rewriting       bool         // The backup exists and history may be half-rewritten, so an interrupt needs recovery
+	confirmedClean  bool         // Yes was set by YesIfClean rather than by -yes
+	fromPlan        *Plan        // Plan being executed with -plan, checked against the computed squash
+}
+
+// backupKind returns the kind of ref used for the backup ("branch" or "tag")
+func (info SquashInfo) backupKind() string {
+	if info.TagBackup {
+		return "tag"
+	}
+	return "branch"
+}
+
+// messageFrom returns which commit provides the default message ("oldest" or "newest")
+func (input UserInput) messageFrom() string {
+	if input.MessageFrom == "" {
+		return "oldest"
+	}
+	return input.MessageFrom
+}
+
+// stashInclude returns the git stash push flag selecting which files besides tracked
+// changes go into the auto-stash ("-a", "-u" or empty)
+func (input UserInput) stashInclude() string {
+	switch {
+	case input.StashAll:
+		return "-a"
+	case input.StashUntracked:
+		return "-u"
+	}
+	return ""
+}
+
+// backupRef returns the fully qualified name of the backup ref
+func (info SquashInfo) backupRef() string {
+	if info.TagBackup {
+		return "refs/tags/" + info.BackupName
+	}
+	return "refs/heads/" + info.BackupName
+}
+
+// deleteBackupCommand returns the git command that deletes the backup ref
+func (info SquashInfo) deleteBackupCommand() string {
+	if info.TagBackup {
+		return "git tag -d " + info.BackupName
+	}
+	return "git branch -D " + info.BackupName
+}
+
+// restoreCommand returns the git command that moves the squashed branch back to the backup
+func (info SquashInfo) restoreCommand() string {
+	if info.Branch != "" {
+		return "git branch -f " + info.Branch + " " + info.BackupName
+	}
+	return "git reset --hard " + info.BackupName
+}
+
+// cleanupMode returns the git commit --cleanup mode, default unless -cleanup chose another
+func (info SquashInfo) cleanupMode() string {
+	if info.Cleanup == "" {
+		return "default"
+	}
+	return info.Cleanup
+}
+
+// commitCleanup returns the --cleanup mode passed to git, or "" to leave git's default, which is
+// whitespace for a message given with -m (-edit already cleaned up the message it returned)
+func (info SquashInfo) commitCleanup() string {
+	if mode := info.cleanupMode(); mode != "default" {
+		return mode
+	}
+	return ""
+}
+
+// commitOptions returns the options used to create the squashed commit
+func (info SquashInfo) commitOptions() commitOptions {
+	return commitOptions{
+		Date:       info.RecentDate,
+		AuthorDate: info.AuthorDate,
+		Message:    info.CommitMessage,
+		AllowEmpty: info.AllowEmpty || info.Reword, // Rewording never changes the tree
+		Amend:      info.Reword || info.AmendBase,
+		Author:     info.CommitAuthor,
+		Committer:  info.CommitCommitter,
+		NoVerify:   info.NoVerify,
+		Cleanup:    info.commitCleanup(),
+		Sign:       info.Sign,
+		SignKey:    info.SignKey,
+	}
+}
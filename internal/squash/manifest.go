@@ -0,0 +1,75 @@
+package squash
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+)
+
+// manifestFileName is the recovery manifest written inside the git directory on every squash
+const manifestFileName = "locsquash-last.json"
+
+// Manifest records the state needed to undo the most recent squash
+type Manifest struct {
+	Head         string    `json:"head"`                    // Full hash of HEAD before the squash
+	Branch       string    `json:"branch"`                  // Branch that was squashed ("HEAD" when detached)
+	NoCheckout   bool      `json:"no_checkout,omitempty"`   // The branch was squashed with -branch, without being checked out
+	Backup       string    `json:"backup,omitempty"`        // Backup branch or tag name, empty with -no-backup
+	BackupTag    bool      `json:"backup_tag,omitempty"`    // Whether the backup is a tag
+	BackupRemote string    `json:"backup_remote,omitempty"` // Remote the backup was pushed to with -push-backup
+	Stash        string    `json:"stash,omitempty"`         // Stash ref holding auto-stashed changes
+	StashHash    string    `json:"stash_hash,omitempty"`    // Commit hash of the stash, which survives stash reordering
+	Result       string    `json:"result,omitempty"`        // Full hash of the squashed commit, once the squash succeeded
+	Created      time.Time `json:"created"`                 // When the squash started
+}
+
+// manifestPath returns the path of the recovery manifest in the current repository's git directory
+func manifestPath(ctx context.Context, g GitRunner) (string, error) {
+	return gitDirPath(ctx, g, manifestFileName)
+}
+
+// writeManifest records the pre-squash state, replacing the manifest of any earlier run
+func writeManifest(ctx context.Context, g GitRunner, m Manifest) error {
+	path, err := manifestPath(ctx, g)
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0600)
+}
+
+// recordManifestResult adds the squashed commit to the manifest once the squash succeeded,
+// so -recover-last can tell whether the branch moved on since
+func recordManifestResult(ctx context.Context, g GitRunner, result string) error {
+	m, err := readManifest(ctx, g)
+	if err != nil {
+		return err
+	}
+	m.Result = result
+	return writeManifest(ctx, g, m)
+}
+
+// readManifest loads the manifest written by the most recent squash
+func readManifest(ctx context.Context, g GitRunner) (Manifest, error) {
+	var m Manifest
+	path, err := manifestPath(ctx, g)
+	if err != nil {
+		return m, err
+	}
+	data, err := os.ReadFile(path) //nolint:gosec // path is inside the git directory
+	if os.IsNotExist(err) {
+		return m, fmt.Errorf("no recovery manifest found at %s; has locsquash run in this repository?", path)
+	}
+	if err != nil {
+		return m, err
+	}
+	if err = json.Unmarshal(data, &m); err != nil {
+		return m, fmt.Errorf("invalid recovery manifest %s: %w", path, err)
+	}
+	return m, nil
+}
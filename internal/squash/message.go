@@ -0,0 +1,419 @@
+package squash
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"regexp"
+	"runtime"
+	"slices"
+	"strings"
+
+	"github.com/mattn/go-runewidth"
+)
+
+// concatMessages joins commit messages, oldest first, separated by blank lines.
+// A non-empty header is placed above the joined messages. Empty messages are skipped
+// and trailing whitespace is trimmed from every line.
+func concatMessages(header string, messages []string) string {
+	parts := make([]string, 0, len(messages)+1)
+	if h := trimTrailingSpace(header); h != "" {
+		parts = append(parts, h)
+	}
+	for _, m := range messages {
+		if m = trimTrailingSpace(m); m != "" {
+			parts = append(parts, m)
+		}
+	}
+	return strings.Join(parts, "\n\n")
+}
+
+// trimTrailingSpace removes trailing whitespace from each line and surrounding blank lines
+func trimTrailingSpace(s string) string {
+	lines := strings.Split(s, "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimRight(line, " \t\r")
+	}
+	return strings.Trim(strings.Join(lines, "\n"), "\n")
+}
+
+// placeholderRe matches a message placeholder such as {count}
+var placeholderRe = regexp.MustCompile(`\{[a-z]+\}`)
+
+// expandPlaceholders replaces placeholders such as {count} in message with their values.
+// Unknown placeholders are left as written, so messages with literal braces need no escaping.
+func expandPlaceholders(message string, values map[string]string) string {
+	return placeholderRe.ReplaceAllStringFunc(message, func(p string) string {
+		if v, ok := values[p[1:len(p)-1]]; ok {
+			return v
+		}
+		return p
+	})
+}
+
+// wrapBody word-wraps the body lines of message that are wider than width, leaving the subject alone.
+// Lines are split at spaces only, so a URL or other long token stays whole on a line of its own.
+// Blank lines, indented lines such as code, and trailers are kept as they are.
+func wrapBody(message string, width int) string {
+	subject, body, ok := strings.Cut(message, "\n")
+	if !ok || width <= 0 {
+		return message
+	}
+	lines := strings.Split(body, "\n")
+	wrapped := make([]string, 0, len(lines))
+	for _, line := range lines {
+		if runewidth.StringWidth(line) <= width || strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t") || trailerRe.MatchString(line) {
+			wrapped = append(wrapped, line)
+			continue
+		}
+		wrapped = append(wrapped, wrapLine(line, width)...)
+	}
+	return subject + "\n" + strings.Join(wrapped, "\n")
+}
+
+// wrapLine splits line at spaces into lines no wider than width where the words allow it.
+// Continuation lines of a "- " or "* " list item are indented to line up with its text.
+func wrapLine(line string, width int) []string {
+	indent := ""
+	if strings.HasPrefix(line, "- ") || strings.HasPrefix(line, "* ") {
+		indent = "  "
+	}
+	var lines []string
+	current := ""
+	for _, word := range strings.Fields(line) {
+		switch {
+		case current == "":
+			current = word
+		case runewidth.StringWidth(current)+1+runewidth.StringWidth(word) <= width:
+			current += " " + word
+		default:
+			lines = append(lines, current)
+			current = indent + word
+		}
+	}
+	return append(lines, current)
+}
+
+// trailerRe matches a git trailer line such as "Signed-off-by: Name <email>"
+var trailerRe = regexp.MustCompile(`^[A-Za-z0-9-]+:\s`)
+
+// appendSignoff adds a "Signed-off-by: <ident>" trailer to message like git commit -s:
+// it joins an existing trailer block at the end of the message, or starts a new paragraph.
+// The message is returned unchanged if it already contains the same sign-off.
+func appendSignoff(message, ident string) string {
+	signoff := "Signed-off-by: " + ident
+	for line := range strings.SplitSeq(message, "\n") {
+		if strings.TrimSpace(line) == signoff {
+			return message
+		}
+	}
+	return appendTrailer(message, signoff)
+}
+
+// appendTrailer adds trailer to the trailer block at the end of message, or starts a new paragraph
+func appendTrailer(message, trailer string) string {
+	switch {
+	case message == "":
+		return trailer
+	case trailerBlock(message) != nil:
+		return message + "\n" + trailer
+	default:
+		return message + "\n\n" + trailer
+	}
+}
+
+// trailerBlock returns the lines of the last paragraph of message if every one of them is a
+// trailer, or nil. A message consisting of a single paragraph has no trailer block.
+func trailerBlock(message string) []string {
+	lines := strings.Split(message, "\n")
+	last := lines[len(lines)-1:]
+	for i := len(lines) - 1; i >= 0 && strings.TrimSpace(lines[i]) != ""; i-- {
+		last = lines[i:]
+	}
+	if len(lines) == len(last) || strings.TrimSpace(last[0]) == "" {
+		return nil
+	}
+	for _, line := range last {
+		if !trailerRe.MatchString(line) {
+			return nil
+		}
+	}
+	return last
+}
+
+// trailerKeyRe matches a git trailer key such as "Reviewed-by"
+var trailerKeyRe = regexp.MustCompile(`^[A-Za-z0-9-]+$`)
+
+// parseTrailer checks that trailer has the "Key: value" shape and returns it normalized to a
+// single space after the colon
+func parseTrailer(trailer string) (string, error) {
+	key, value, ok := strings.Cut(trailer, ":")
+	value = strings.TrimSpace(value)
+	switch {
+	case !ok || value == "":
+		return "", errors.New(`expected "Key: value"`)
+	case !trailerKeyRe.MatchString(key):
+		return "", errors.New("the key may only contain letters, digits and '-'")
+	case strings.ContainsAny(value, "\r\n"):
+		return "", errors.New("the value must be a single line")
+	}
+	return key + ": " + value, nil
+}
+
+// appendTrailers adds each trailer to the trailer block of message, skipping ones the block
+// already contains
+func appendTrailers(message string, trailers []string) string {
+	for _, trailer := range trailers {
+		if !slices.Contains(trailerBlock(message), trailer) {
+			message = appendTrailer(message, trailer)
+		}
+	}
+	return message
+}
+
+// coauthorPrefix starts a co-author trailer; git trailer keys are case-insensitive
+const coauthorPrefix = "co-authored-by:"
+
+// coauthorKey returns the lowercased email of a "Co-authored-by: Name <email>" trailer, falling
+// back to the whole value when it has no email, or "" if line is not a co-author trailer
+func coauthorKey(line string) string {
+	line = strings.TrimSpace(line)
+	if len(line) < len(coauthorPrefix) || !strings.EqualFold(line[:len(coauthorPrefix)], coauthorPrefix) {
+		return ""
+	}
+	value := strings.TrimSpace(line[len(coauthorPrefix):])
+	if start := strings.LastIndex(value, "<"); start >= 0 {
+		if end := strings.Index(value[start:], ">"); end > 0 {
+			value = value[start+1 : start+end]
+		}
+	}
+	return strings.ToLower(value)
+}
+
+// appendCoauthors adds the Co-authored-by trailers found in messages to the trailer block of
+// message, in order of first appearance. Co-authors are deduplicated by email, including against
+// every Co-authored-by line already in message, such as those in bodies joined by -concat-messages.
+func appendCoauthors(message string, messages []string) string {
+	seen := make(map[string]bool)
+	for line := range strings.SplitSeq(message, "\n") {
+		if key := coauthorKey(line); key != "" {
+			seen[key] = true
+		}
+	}
+	for _, m := range messages {
+		for line := range strings.SplitSeq(m, "\n") {
+			key := coauthorKey(line)
+			if key == "" || seen[key] {
+				continue
+			}
+			seen[key] = true
+			message = appendTrailer(message, strings.TrimSpace(line))
+		}
+	}
+	return message
+}
+
+// readMessageFile reads a commit message from path, or from stdin if path is "-".
+// Only trailing whitespace is trimmed from each line; blank lines are preserved.
+func readMessageFile(path string) (string, error) {
+	var data []byte
+	var err error
+	if path == "-" {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(path) //nolint:gosec // path is chosen by the user
+	}
+	if err != nil {
+		return "", err
+	}
+	return trimTrailingSpace(string(data)), nil
+}
+
+// gitCommitTemplate returns the message in the file named by commit.template, without its
+// comment lines, or "" when no template is configured. The path is used as git config --path
+// expands it, so ~/ is taken from $HOME and a relative path from the current directory, the
+// same file git commit would read.
+func gitCommitTemplate(ctx context.Context, g GitRunner) (string, error) {
+	path, err := gitStdout(ctx, g, "config", "--path", "--get", "commit.template")
+	if err != nil || path == "" {
+		return "", nil //nolint:nilerr // git config exits non-zero when the key is unset
+	}
+	data, err := os.ReadFile(path) //nolint:gosec // the path comes from the user's git config
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(trimTrailingSpace(stripComments(string(data)))), nil
+}
+
+// DefaultConventionalTypes are the commit types accepted by -conventional unless overridden
+var DefaultConventionalTypes = []string{"build", "chore", "ci", "docs", "feat", "fix", "perf", "refactor", "revert", "style", "test"}
+
+// conventionalRe matches a Conventional Commits subject: type(scope)!: subject
+var conventionalRe = regexp.MustCompile(`^([a-zA-Z]+)(\([^()\s]+\))?!?: \S`)
+
+// checkConventional verifies that the first line of message follows Conventional Commits
+// with one of the given comma-separated types (the default set if empty)
+func checkConventional(message, types string) error {
+	allowed := DefaultConventionalTypes
+	if strings.TrimSpace(types) != "" {
+		allowed = nil
+		for t := range strings.SplitSeq(types, ",") {
+			if t = strings.TrimSpace(t); t != "" {
+				allowed = append(allowed, t)
+			}
+		}
+	}
+
+	subject, _, _ := strings.Cut(message, "\n")
+	m := conventionalRe.FindStringSubmatch(subject)
+	if m == nil {
+		return fmt.Errorf("subject %q does not match \"type(scope): subject\"", subject)
+	}
+	if !slices.Contains(allowed, m[1]) {
+		return fmt.Errorf("subject %q uses type %q; expected one of %s", subject, m[1], strings.Join(allowed, ", "))
+	}
+	return nil
+}
+
+// editorCommand returns the editor to use for message editing: $EDITOR, then $GIT_EDITOR, then vi
+func editorCommand() string {
+	for _, key := range []string{"EDITOR", "GIT_EDITOR"} {
+		if v := strings.TrimSpace(os.Getenv(key)); v != "" {
+			return v
+		}
+	}
+	return "vi"
+}
+
+// scissorsLine separates the message from the instructions below it in the editor, like git's
+// scissors line. Everything from it on is dropped when the editor is closed.
+const scissorsLine = "# ------------------------ >8 ------------------------"
+
+// editorContent returns the text the editor opens with for the -cleanup mode. The modes that keep
+// lines starting with '#' put the instructions below a scissors line instead.
+func editorContent(message, mode string) string {
+	switch mode {
+	case "strip", "default":
+		return message + "\n\n" +
+			"# Please enter the commit message for the squashed commit. Lines starting\n" +
+			"# with '#' will be ignored, and an empty message aborts the squash.\n"
+	default:
+		return message + "\n\n" + scissorsLine + "\n" +
+			"# Do not modify or remove the line above; everything below it is ignored.\n" +
+			"# Lines starting with '#' above it are kept, and an empty message aborts the squash.\n"
+	}
+}
+
+// cleanupEdited cleans the saved editor text like git commit --cleanup=mode does after an editor ran:
+// strip and default remove comment lines, the other modes cut at the scissors line
+func cleanupEdited(edited, mode string) string {
+	switch mode {
+	case "strip", "default":
+		return cleanupMessage(edited)
+	}
+	if edited, _, _ = strings.Cut("\n"+edited, "\n"+scissorsLine+"\n"); edited != "" {
+		edited = edited[1:]
+	}
+	if mode == "verbatim" {
+		// Only the blank lines separating the message from the scissors line are not the user's
+		return strings.TrimRight(edited, "\n")
+	}
+	return cleanupWhitespace(edited)
+}
+
+// editorShellCommand runs editor on path through the shell, the way git runs its editor, so it
+// may carry arguments and quoting, e.g. "code --wait" or '"/c/Program Files/Editor/editor" -w'
+func editorShellCommand(ctx context.Context, editor, path string) *exec.Cmd {
+	if runtime.GOOS == "windows" {
+		return shellCommand(ctx, editor+` "`+path+`"`)
+	}
+	return exec.CommandContext(ctx, "sh", "-c", editor+` "$@"`, editor, path) //nolint:gosec // editor is chosen by the user
+}
+
+// editMessage opens message in the user's editor and returns the saved result cleaned up
+// for the -cleanup mode. It fails if the resulting message is empty.
+func editMessage(ctx context.Context, message, mode string) (string, error) {
+	f, err := os.CreateTemp("", "locsquash-msg-*.txt")
+	if err != nil {
+		return "", err
+	}
+	path := f.Name()
+	defer func() { _ = os.Remove(path) }()
+
+	if _, err = f.WriteString(editorContent(message, mode)); err != nil {
+		_ = f.Close()
+		return "", err
+	}
+	if err = f.Close(); err != nil {
+		return "", err
+	}
+
+	editor := editorCommand()
+	cmd := editorShellCommand(ctx, editor, path)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err = cmd.Run(); err != nil {
+		return "", fmt.Errorf("editor %q failed: %w", editor, err)
+	}
+
+	edited, err := os.ReadFile(path) //nolint:gosec // path is the temp file created above
+	if err != nil {
+		return "", err
+	}
+	result := cleanupEdited(string(edited), mode)
+	if strings.TrimSpace(result) == "" {
+		return "", errors.New("empty commit message")
+	}
+	return result, nil
+}
+
+// cleanupModes lists the values git commit --cleanup accepts
+var cleanupModes = []string{"strip", "whitespace", "verbatim", "scissors", "default"}
+
+// cleanupForMode cleans message the way git commit --cleanup=mode does for a message given with -m,
+// for git commit-tree, which has no --cleanup. Without an editor, scissors, default and "" act as whitespace.
+func cleanupForMode(message, mode string) string {
+	switch mode {
+	case "verbatim":
+		return message
+	case "strip":
+		return cleanupMessage(message)
+	default:
+		return cleanupWhitespace(message)
+	}
+}
+
+// cleanupMessage cleans message like git commit --cleanup=strip: comment lines and trailing
+// whitespace are removed, runs of blank lines collapse into one, and leading and trailing blank lines go
+func cleanupMessage(message string) string {
+	return cleanupWhitespace(stripComments(message))
+}
+
+// cleanupWhitespace cleans message like git commit --cleanup=whitespace, which keeps comment lines
+func cleanupWhitespace(message string) string {
+	lines := strings.Split(trimTrailingSpace(message), "\n")
+	kept := lines[:0]
+	for i, line := range lines {
+		if line == "" && i > 0 && lines[i-1] == "" {
+			continue
+		}
+		kept = append(kept, line)
+	}
+	return trimTrailingSpace(strings.Join(kept, "\n"))
+}
+
+// stripComments removes lines beginning with '#'
+func stripComments(s string) string {
+	lines := strings.Split(s, "\n")
+	kept := lines[:0]
+	for _, line := range lines {
+		if !strings.HasPrefix(line, "#") {
+			kept = append(kept, line)
+		}
+	}
+	return strings.Join(kept, "\n")
+}
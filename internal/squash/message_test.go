@@ -0,0 +1,255 @@
+package squash
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestAppendSignoff(t *testing.T) {
+	const ident = "Jane Doe <jane@example.com>"
+	const signoff = "Signed-off-by: " + ident
+	tests := []struct {
+		name    string
+		message string
+		want    string
+	}{
+		{name: "subject only", message: "Add feature", want: "Add feature\n\n" + signoff},
+		{name: "subject with body", message: "Add feature\n\nDetails here.", want: "Add feature\n\nDetails here.\n\n" + signoff},
+		{name: "conventional subject", message: "feat: add feature", want: "feat: add feature\n\n" + signoff},
+		{name: "existing trailer block", message: "Add feature\n\nCo-authored-by: John <john@example.com>", want: "Add feature\n\nCo-authored-by: John <john@example.com>\n" + signoff},
+		{name: "already signed off", message: "Add feature\n\n" + signoff, want: "Add feature\n\n" + signoff},
+		{name: "other sign-off", message: "Add feature\n\nSigned-off-by: John <john@example.com>", want: "Add feature\n\nSigned-off-by: John <john@example.com>\n" + signoff},
+		{name: "empty", message: "", want: signoff},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := appendSignoff(tt.message, ident); got != tt.want {
+				t.Errorf("appendSignoff(%q) = %q, want %q", tt.message, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCleanupMessage(t *testing.T) {
+	tests := []struct {
+		name    string
+		message string
+		want    string
+	}{
+		{name: "clean", message: "Subject\n\nBody", want: "Subject\n\nBody"},
+		{name: "comment lines", message: "Subject\n# template hint\n\nBody\n#", want: "Subject\n\nBody"},
+		{name: "indented hash kept", message: "Subject\n\n  # not a comment\nissue #12", want: "Subject\n\n  # not a comment\nissue #12"},
+		{name: "blank runs", message: "Subject\n\n\n\nfirst\n \n\t\nsecond", want: "Subject\n\nfirst\n\nsecond"},
+		{name: "blank left by comment", message: "Subject\n\n# removed\n\nBody", want: "Subject\n\nBody"},
+		{name: "leading and trailing", message: "\n# hint\n\nSubject  \n\n\n", want: "Subject"},
+		{name: "only comments", message: "# one\n#two", want: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := cleanupMessage(tt.message); got != tt.want {
+				t.Errorf("cleanupMessage(%q) = %q, want %q", tt.message, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCleanupForMode(t *testing.T) {
+	const message = "\nSubject  \n\n\n# 12 is fixed\nBody\n"
+	tests := []struct {
+		mode string
+		want string
+	}{
+		{mode: "strip", want: "Subject\n\nBody"},
+		{mode: "whitespace", want: "Subject\n\n# 12 is fixed\nBody"},
+		{mode: "scissors", want: "Subject\n\n# 12 is fixed\nBody"},
+		{mode: "default", want: "Subject\n\n# 12 is fixed\nBody"},
+		{mode: "verbatim", want: message},
+	}
+
+	for _, tt := range tests {
+		if got := cleanupForMode(message, tt.mode); got != tt.want {
+			t.Errorf("cleanupForMode(%q, %s) = %q, want %q", message, tt.mode, got, tt.want)
+		}
+	}
+}
+
+func TestWrapBody(t *testing.T) {
+	const url = "https://example.com/a/very/long/path/that/does/not/fit"
+	tests := []struct {
+		name    string
+		message string
+		want    string
+	}{
+		{name: "subject untouched", message: "A subject line that is much longer than twenty columns", want: "A subject line that is much longer than twenty columns"},
+		{name: "body wrapped", message: "Subject\n\nthe quick brown fox jumps over the lazy dog", want: "Subject\n\nthe quick brown fox\njumps over the lazy\ndog"},
+		{name: "blank lines kept", message: "Subject\n\nfirst paragraph is long\n\n\nsecond", want: "Subject\n\nfirst paragraph is\nlong\n\n\nsecond"},
+		{name: "short lines kept", message: "Subject\n\none\ntwo", want: "Subject\n\none\ntwo"},
+		{name: "url not broken", message: "Subject\n\nsee " + url + " for details", want: "Subject\n\nsee\n" + url + "\nfor details"},
+		{name: "list item indented", message: "Subject\n\n- a list item that wraps around", want: "Subject\n\n- a list item that\n  wraps around"},
+		{name: "indented code kept", message: "Subject\n\n    code that is longer than twenty columns", want: "Subject\n\n    code that is longer than twenty columns"},
+		{name: "trailer kept", message: "Subject\n\nCo-authored-by: Jane Doe <jane@example.com>", want: "Subject\n\nCo-authored-by: Jane Doe <jane@example.com>"},
+		{name: "wide runes", message: "Subject\n\n日本語 日本語 日本語 日本語", want: "Subject\n\n日本語 日本語 日本語\n日本語"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := wrapBody(tt.message, 20); got != tt.want {
+				t.Errorf("wrapBody(%q) = %q, want %q", tt.message, got, tt.want)
+			}
+		})
+	}
+	if got := wrapBody("Subject\n\nthe quick brown fox jumps over the lazy dog", 0); got != "Subject\n\nthe quick brown fox jumps over the lazy dog" {
+		t.Errorf("wrapBody with width 0 = %q, want the message unchanged", got)
+	}
+}
+
+func TestCleanupEdited(t *testing.T) {
+	const message = "#42 subject\n\n\n# kept in the body  "
+	tests := []struct {
+		mode string
+		want string
+	}{
+		{mode: "default", want: ""},
+		{mode: "strip", want: ""},
+		{mode: "whitespace", want: "#42 subject\n\n# kept in the body"},
+		{mode: "scissors", want: "#42 subject\n\n# kept in the body"},
+		{mode: "verbatim", want: message},
+	}
+
+	for _, tt := range tests {
+		edited := editorContent(message, tt.mode)
+		if got := cleanupEdited(edited, tt.mode); got != tt.want {
+			t.Errorf("cleanupEdited(%q, %s) = %q, want %q", edited, tt.mode, got, tt.want)
+		}
+	}
+}
+
+func TestParseTrailer(t *testing.T) {
+	tests := []struct {
+		trailer string
+		want    string
+		wantErr bool
+	}{
+		{trailer: "Refs: #123", want: "Refs: #123"},
+		{trailer: "Reviewed-by:Jane <jane@example.com>  ", want: "Reviewed-by: Jane <jane@example.com>"},
+		{trailer: "Refs #123", wantErr: true},
+		{trailer: "Refs:", wantErr: true},
+		{trailer: ": value", wantErr: true},
+		{trailer: "Reviewed by: Jane", wantErr: true},
+		{trailer: "Refs: one\ntwo", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := parseTrailer(tt.trailer)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("parseTrailer(%q) = %q, %v; want %q, error %v", tt.trailer, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestAppendTrailers(t *testing.T) {
+	const refs = "Refs: #123"
+	const review = "Reviewed-by: Jane <jane@example.com>"
+	tests := []struct {
+		name    string
+		message string
+		want    string
+	}{
+		{name: "subject only", message: "Add feature", want: "Add feature\n\n" + refs + "\n" + review},
+		{name: "existing trailer block", message: "Add feature\n\nCo-authored-by: John <john@example.com>", want: "Add feature\n\nCo-authored-by: John <john@example.com>\n" + refs + "\n" + review},
+		{name: "already present", message: "Add feature\n\n" + refs, want: "Add feature\n\n" + refs + "\n" + review},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := appendTrailers(tt.message, []string{refs, review}); got != tt.want {
+				t.Errorf("appendTrailers(%q) = %q, want %q", tt.message, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAppendCoauthors(t *testing.T) {
+	const john = "Co-authored-by: John <john@example.com>"
+	const ann = "Co-authored-by: Ann <ann@example.com>"
+	tests := []struct {
+		name     string
+		message  string
+		messages []string
+		want     string
+	}{
+		{name: "none", message: "Add feature", messages: []string{"a", "b\n\nDetails."}, want: "Add feature"},
+		{name: "collected in order", message: "Add feature", messages: []string{"a\n\n" + john, "b\n\n" + ann}, want: "Add feature\n\n" + john + "\n" + ann},
+		{name: "overlapping", message: "Add feature", messages: []string{"a\n\n" + john + "\n" + ann, "b\n\n" + ann, "c\n\nCo-authored-by: Johnny <JOHN@example.com>"}, want: "Add feature\n\n" + john + "\n" + ann},
+		{name: "already in trailer block", message: "Add feature\n\n" + john, messages: []string{"a\n\n" + john, "b\n\n" + ann}, want: "Add feature\n\n" + john + "\n" + ann},
+		{name: "lowercase key", message: "Add feature", messages: []string{"a\n\nco-authored-by: Ann <ann@example.com>"}, want: "Add feature\n\nco-authored-by: Ann <ann@example.com>"},
+		{name: "already in body", message: "Add feature\n\n" + john + "\n\nMore details.", messages: []string{"a\n\n" + john, "b\n\n" + ann}, want: "Add feature\n\n" + john + "\n\nMore details.\n\n" + ann},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := appendCoauthors(tt.message, tt.messages); got != tt.want {
+				t.Errorf("appendCoauthors(%q) = %q, want %q", tt.message, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExpandPlaceholders(t *testing.T) {
+	values := map[string]string{"count": "5", "date": "2024-06-01", "oldest": "abc1234", "newest": "def5678"}
+	tests := []struct {
+		message string
+		want    string
+	}{
+		{message: "Squash {count} commits", want: "Squash 5 commits"},
+		{message: "Release ({date})", want: "Release (2024-06-01)"},
+		{message: "From {oldest}", want: "From abc1234"},
+		{message: "Up to {newest}", want: "Up to def5678"},
+		{message: "Squash {count} commits ({date})\n\n{oldest}..{newest}", want: "Squash 5 commits (2024-06-01)\n\nabc1234..def5678"},
+		{message: "Keep {unknown} and {Count} and {} literal", want: "Keep {unknown} and {Count} and {} literal"},
+		{message: "no placeholders", want: "no placeholders"},
+	}
+
+	for _, tt := range tests {
+		if got := expandPlaceholders(tt.message, values); got != tt.want {
+			t.Errorf("expandPlaceholders(%q) = %q, want %q", tt.message, got, tt.want)
+		}
+	}
+}
+
+func TestCheckConventional(t *testing.T) {
+	tests := []struct {
+		message string
+		types   string
+		wantErr string
+	}{
+		{message: "feat: add widgets"},
+		{message: "fix(parser): handle empty input"},
+		{message: "refactor!: drop legacy flags"},
+		{message: "feat(api)!: remove v1 endpoints\n\nfix: this body line is not checked"},
+		{message: "feat: add widgets\n\nAdd widgets\n\nanything goes here"},
+		{message: "Add widgets", wantErr: "does not match"},
+		{message: "feat:missing space", wantErr: "does not match"},
+		{message: "feat(): empty scope", wantErr: "does not match"},
+		{message: "feature: add widgets", wantErr: "expected one of"},
+		{message: "hotfix: patch prod", types: "hotfix, feat"},
+		{message: "fix: patch prod", types: "hotfix,feat", wantErr: "expected one of hotfix, feat"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.message, func(t *testing.T) {
+			err := checkConventional(tt.message, tt.types)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("expected error containing %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
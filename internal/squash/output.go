@@ -0,0 +1,565 @@
+package squash
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/mattn/go-runewidth"
+	"golang.org/x/term"
+)
+
+// ANSI color codes
+const (
+	colorReset  = "\033[0m"
+	colorRed    = "\033[31m"
+	colorGreen  = "\033[32m"
+	colorYellow = "\033[33m"
+	colorCyan   = "\033[36m"
+)
+
+// Color modes accepted by -color
+const (
+	ColorAuto   = "auto"
+	ColorAlways = "always"
+	ColorNever  = "never"
+)
+
+// colorMode decides whether ANSI colors are emitted; set once from flags and environment
+var colorMode = ColorAuto
+
+// ResolveColorMode combines -color, -no-color and the NO_COLOR environment variable.
+// -no-color always wins, an explicit -color=always/never beats NO_COLOR, and NO_COLOR beats auto.
+func ResolveColorMode(flagValue string, noColor bool) (string, error) {
+	switch flagValue {
+	case ColorAuto, ColorAlways, ColorNever:
+	default:
+		return "", fmt.Errorf("invalid -color value %q (expected auto, always or never)", flagValue)
+	}
+	if noColor {
+		return ColorNever, nil
+	}
+	if flagValue == ColorAuto && os.Getenv("NO_COLOR") != "" {
+		return ColorNever, nil
+	}
+	return flagValue, nil
+}
+
+// colorEnabled reports whether colors should be used for a stream with the given terminal check
+func colorEnabled(isTerminal func() bool) bool {
+	switch colorMode {
+	case ColorAlways:
+		return true
+	case ColorNever:
+		return false
+	default:
+		return isTerminal()
+	}
+}
+
+// jsonOutput is set by -json. Human-readable status then goes to stderr so stdout stays pure JSON.
+var jsonOutput bool
+
+// statusWriter returns the stream for human-readable status messages
+func statusWriter() io.Writer {
+	if jsonOutput {
+		return os.Stderr
+	}
+	return os.Stdout
+}
+
+// verboseOutput is set by -verbose. Every git invocation is then echoed to stderr.
+var verboseOutput bool
+
+// quietOutput is set by -quiet. Progress messages are then discarded; errors are unaffected.
+var quietOutput bool
+
+// progressWriter returns the stream for progress messages and git command output
+func progressWriter() io.Writer {
+	if quietOutput {
+		return io.Discard
+	}
+	return statusWriter()
+}
+
+// progressf prints a formatted progress message unless -quiet is set
+func progressf(format string, args ...any) {
+	fmt.Fprintf(progressWriter(), format, args...)
+}
+
+// progressln prints a progress message followed by a newline unless -quiet is set
+func progressln(args ...any) {
+	fmt.Fprintln(progressWriter(), args...)
+}
+
+// statusf prints a formatted status message
+func statusf(format string, args ...any) {
+	fmt.Fprintf(statusWriter(), format, args...)
+}
+
+// statusln prints a status message followed by a newline
+func statusln(args ...any) {
+	fmt.Fprintln(statusWriter(), args...)
+}
+
+// warnf prints a formatted warning in yellow to stderr, whatever the other output settings
+func warnf(format string, args ...any) {
+	fmt.Fprintln(os.Stderr, colorizeErr(colorYellow, "Warning: "+fmt.Sprintf(format, args...)))
+}
+
+// errorln prints an error for one item of a larger operation in red to stderr; errors that
+// end the run are returned instead and printed by Exit
+func errorln(msg string) {
+	fmt.Fprintln(os.Stderr, colorizeErr(colorRed, msg))
+}
+
+// fileIsTerminal checks if f is connected to a terminal. term.IsTerminal also recognizes
+// Windows consoles and rejects character devices such as /dev/null; the file mode is only
+// consulted when f has no usable descriptor.
+func fileIsTerminal(f *os.File) bool {
+	if f == nil {
+		return false
+	}
+	if fd := f.Fd(); fd != ^uintptr(0) {
+		return term.IsTerminal(int(fd)) //nolint:gosec // file descriptors fit in an int
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return fi.Mode()&os.ModeCharDevice != 0
+}
+
+// stdoutIsTerminal checks if stdout is connected to a terminal
+func stdoutIsTerminal() bool {
+	return fileIsTerminal(os.Stdout)
+}
+
+// stderrIsTerminal checks if stderr is connected to a terminal
+func stderrIsTerminal() bool {
+	return fileIsTerminal(os.Stderr)
+}
+
+// colorize wraps text with ANSI color codes if colors are enabled for stdout
+func colorize(color, text string) string {
+	if !colorEnabled(stdoutIsTerminal) {
+		return text
+	}
+	return color + text + colorReset
+}
+
+// colorizeErr wraps text with ANSI color codes if colors are enabled for stderr
+func colorizeErr(color, text string) string {
+	if !colorEnabled(stderrIsTerminal) {
+		return text
+	}
+	return color + text + colorReset
+}
+
+// printCommitList displays the commits that will be squashed
+func (info SquashInfo) printCommitList() {
+	if info.Reword {
+		statusln("The following commit will be reworded:")
+		statusln()
+	} else if info.SquashMergesOnly {
+		statusf("The following %d commit(s) will be folded into the merge commit %s, keeping its parents:\n\n", len(info.Commits), info.ResetRef)
+	} else if info.AmendBase {
+		statusf("The following %d commit(s) will be folded into their base commit %s:\n\n", len(info.Commits), info.ResetRef)
+	} else {
+		statusf("The following %d commits will be squashed:\n\n", len(info.Commits))
+	}
+	// Pad before colorizing so escape codes don't skew the column widths
+	dateWidth, authorWidth, addWidth, delWidth := 0, 0, 0, 0
+	for _, c := range info.Commits {
+		dateWidth = max(dateWidth, runewidth.StringWidth(sanitizeForTerminal(c.Date)))
+		authorWidth = max(authorWidth, runewidth.StringWidth(sanitizeForTerminal(c.Author)))
+		addWidth = max(addWidth, len(strconv.Itoa(c.Additions))+1)
+		delWidth = max(delWidth, len(strconv.Itoa(c.Deletions))+1)
+	}
+	for _, c := range info.Commits {
+		stat := ""
+		if info.Stat {
+			stat = colorize(colorGreen, padLeft("+"+strconv.Itoa(c.Additions), addWidth)) + " " +
+				colorize(colorRed, padLeft("-"+strconv.Itoa(c.Deletions), delWidth)) + "  "
+		}
+		statusf("  %s  %s  %s  %s%s\n",
+			colorize(colorYellow, c.Hash),
+			colorize(colorCyan, padRight(sanitizeForTerminal(c.Date), dateWidth)),
+			padRight(sanitizeForTerminal(c.Author), authorWidth),
+			stat,
+			sanitizeForTerminal(c.Subject))
+	}
+	statusln()
+	statusf("Result commit date: %s\n", describeDate(info.RecentDate, time.Now()))
+	statusf("Result commit message: %q\n\n", info.CommitMessage)
+}
+
+// describeDate renders an RFC 3339 date followed by its age relative to now, such as
+// "2024-06-01T12:00:00Z (3 hours ago)". A date that does not parse is returned as-is.
+func describeDate(iso string, now time.Time) string {
+	t, err := time.Parse(time.RFC3339, iso)
+	if err != nil {
+		return iso
+	}
+	return fmt.Sprintf("%s (%s)", iso, relativeTime(t, now))
+}
+
+// relativeTime describes how long before now t was, in the largest whole unit
+func relativeTime(t, now time.Time) string {
+	d := now.Sub(t)
+	if d < 0 {
+		return "in the future"
+	}
+	units := []struct {
+		name string
+		size time.Duration
+	}{
+		{"year", 365 * 24 * time.Hour},
+		{"month", 30 * 24 * time.Hour},
+		{"week", 7 * 24 * time.Hour},
+		{"day", 24 * time.Hour},
+		{"hour", time.Hour},
+		{"minute", time.Minute},
+	}
+	for _, u := range units {
+		if n := int(d / u.size); n >= 1 {
+			if n == 1 {
+				return "1 " + u.name + " ago"
+			}
+			return fmt.Sprintf("%d %ss ago", n, u.name)
+		}
+	}
+	return "just now"
+}
+
+// padRight pads s with spaces to width terminal columns; wide characters such as emoji
+// and CJK take two columns
+func padRight(s string, width int) string {
+	if n := runewidth.StringWidth(s); n < width {
+		return s + strings.Repeat(" ", width-n)
+	}
+	return s
+}
+
+// padLeft pads s with leading spaces to width terminal columns, right-aligning it
+func padLeft(s string, width int) string {
+	if n := runewidth.StringWidth(s); n < width {
+		return strings.Repeat(" ", width-n) + s
+	}
+	return s
+}
+
+// sanitizeForTerminal escapes control and bidirectional formatting characters (as \x1b, \t,
+// \u202e, ...) in text taken from commits, so it cannot inject escape sequences or reorder
+// what the terminal shows
+func sanitizeForTerminal(s string) string {
+	unsafe := func(r rune) bool { return unicode.IsControl(r) || unicode.Is(unicode.Bidi_Control, r) }
+	if !strings.ContainsFunc(s, unsafe) {
+		return s
+	}
+	var b strings.Builder
+	for _, r := range s {
+		if unsafe(r) {
+			q := strconv.QuoteRune(r)
+			b.WriteString(q[1 : len(q)-1])
+		} else {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// printDiffStat displays the net file changes that the squashed commit will contain
+func printDiffStat(stat string) {
+	if stat == "" {
+		return
+	}
+	statusln("Changes to be squashed:")
+	statusln()
+	for line := range strings.SplitSeq(stat, "\n") {
+		statusf("  %s\n", line)
+	}
+	statusln()
+}
+
+// safeArgRe matches arguments that can be printed without quoting
+var safeArgRe = regexp.MustCompile(`^[A-Za-z0-9_@%+=:,./^{}~-]+$`)
+
+// formatCommand renders a git invocation with its environment as a copy-paste friendly line
+func formatCommand(env, args []string) string {
+	parts := make([]string, 0, len(env)+len(args)+1)
+	for _, e := range env {
+		key, value, _ := strings.Cut(e, "=")
+		parts = append(parts, key+"="+quoteArg(value))
+	}
+	parts = append(parts, "git")
+	for _, a := range args {
+		parts = append(parts, quoteArg(a))
+	}
+	return strings.Join(parts, " ")
+}
+
+// quoteArg quotes an argument for display if it contains characters with special meaning to the shell
+func quoteArg(arg string) string {
+	if safeArgRe.MatchString(arg) {
+		return arg
+	}
+	return fmt.Sprintf("%q", arg)
+}
+
+// squashSummary is the JSON representation of a planned or completed squash
+type squashSummary struct {
+	DryRun        bool         `json:"dry_run"`
+	SquashCount   int          `json:"squash_count"`
+	BackupBranch  string       `json:"backup_branch,omitempty"`
+	ResultCommit  string       `json:"result_commit,omitempty"`
+	Stats         *DiffStats   `json:"stats,omitempty"`
+	ResetRef      string       `json:"reset_ref"`
+	CommitMessage string       `json:"commit_message"`
+	RecentDate    string       `json:"recent_date"`
+	Commits       []CommitInfo `json:"commits"`
+}
+
+// printJSON writes the squash summary as JSON to stdout
+func (info SquashInfo) printJSON() error {
+	summary := squashSummary{
+		DryRun:        info.DryRun,
+		SquashCount:   info.SquashCount,
+		BackupBranch:  info.BackupName,
+		ResultCommit:  info.ResultCommit,
+		Stats:         info.Stats,
+		ResetRef:      info.ResetRef,
+		CommitMessage: info.CommitMessage,
+		RecentDate:    info.RecentDate,
+		Commits:       info.Commits,
+	}
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(summary)
+}
+
+// printDryRun outputs the planned git commands without executing them
+func (info SquashInfo) printDryRun() error {
+	if info.JSON {
+		return info.printJSON()
+	}
+
+	statusln("Dry run. No changes will be made.")
+	statusln()
+
+	if info.SinceTag != "" {
+		statusf("Range since tag %s: %s..%s (%d commits)\n\n", info.SinceTag, info.SinceTag, info.TopRef, info.SquashCount)
+	}
+
+	info.printCommitList()
+
+	if info.ShowDiff {
+		statusf("# Combined diff (%s..%s):\n\n", info.ResetRef, info.TopRef)
+		statusln(info.Diff)
+		statusln()
+	}
+
+	statusln("# Planned operations (copy-paste friendly):")
+	statusln()
+
+	if !info.NoBackup {
+		statusf("# Backup %s\n", info.backupKind())
+		target := "HEAD"
+		if info.Branch != "" {
+			target = info.Branch
+		}
+		statusf("git %s %s %s\n\n", info.backupKind(), info.BackupName, target)
+		if info.PushBackup != "" {
+			statusf("# Push backup %s to %s\n", info.backupKind(), info.PushBackup)
+			statusf("git push --quiet %s %s:%s\n\n", info.PushBackup, info.backupRef(), info.backupRef())
+		}
+	}
+
+	if info.Dirty && info.AllowStash {
+		statusf("# Stash working tree\n")
+		statusf("%s\n", formatCommand(nil, stashPushArgs(info.stashInclude(), autoStashMessage)))
+		statusf("# (stash ref will be: stash@{0})\n\n")
+	}
+
+	if info.Branch != "" {
+		statusf("# Create squashed commit from %s without checking it out\n", info.Branch)
+		opts := info.commitOptions()
+		statusf("%s\n\n", formatCommand(opts.commitTreeEnv(), opts.commitTreeArgs(info.TopRef, info.ResetRef)))
+
+		statusf("# Move branch %s to the squashed commit\n", info.Branch)
+		statusf("git update-ref %s <squashed-commit> %s\n\n", info.TopRef, info.BranchTip)
+	} else if info.AmendBase {
+		statusf("# Rewrite history down to the base commit\n")
+		statusf("git reset --soft %s\n\n", info.ResetRef)
+
+		statusf("# Amend the base commit with the combined changes\n")
+		opts := info.commitOptions()
+		statusf("%s\n\n", formatCommand(opts.commitEnv(), opts.commitArgs()))
+	} else if info.Reword {
+		statusf("# Reword the latest commit\n")
+		opts := info.commitOptions()
+		statusf("%s\n\n", formatCommand(opts.commitEnv(), opts.commitArgs()))
+	} else if info.ReplayCount > 0 {
+		statusf("# Create squashed commit from the range\n")
+		opts := info.commitOptions()
+		statusf("%s\n\n", formatCommand(opts.commitTreeEnv(), opts.commitTreeArgs(info.TopRef, info.ResetRef)))
+
+		statusf("# Replay %d newer commit(s) onto the squashed commit\n", info.ReplayCount)
+		statusf("git %s\n\n", strings.Join(opts.rebaseOntoArgs("<squashed-commit>", info.TopRef), " "))
+	} else {
+		statusf("# Rewrite history\n")
+		statusf("git reset --soft %s\n\n", info.ResetRef)
+
+		statusf("# Create squashed commit\n")
+		opts := info.commitOptions()
+		statusf("%s\n\n", formatCommand(opts.commitEnv(), opts.commitArgs()))
+	}
+
+	if info.OntoRef != "" {
+		statusf("# Move the squashed commit onto %s\n", info.OntoRef)
+		statusf("git %s\n\n", strings.Join(info.commitOptions().rebaseOntoArgs(info.OntoRef, info.BaseCommit), " "))
+	}
+
+	if info.Dirty && info.AllowStash {
+		statusf("# Restore working tree\n")
+		statusf("git stash apply stash@{0}\n")
+		statusf("git stash drop stash@{0}\n\n")
+	}
+
+	if !info.NoBackup && !info.KeepBackup {
+		statusf("# Delete backup %s after success\n", info.backupKind())
+		statusf("%s\n\n", info.deleteBackupCommand())
+	}
+
+	statusln("# End of dry run")
+	return nil
+}
+
+// showsRecovery reports whether the recovery instructions are printed: with -print-recovery, and
+// after a text dry run, so that one invocation shows both how to squash and how to undo it
+func (info SquashInfo) showsRecovery() bool {
+	return info.PrintRecovery || (info.DryRun && !info.JSON)
+}
+
+// printRecovery outputs instructions for recovering from a failed or unwanted squash
+func (info SquashInfo) printRecovery() {
+	if info.DryRun {
+		statusln()
+	}
+	statusln("# Recovery instructions")
+	statusln("# These commands will restore the repository to its pre-run state")
+	statusln()
+
+	if info.NoBackup {
+		statusln("# WARNING: -no-backup was specified, no backup branch will be created")
+		statusln("# Recovery will only be possible via git reflog")
+		statusln("# git reflog")
+		statusln("# git reset --hard <commit-hash-before-squash>")
+	} else {
+		if info.PushBackup != "" {
+			statusf("# If the local backup is lost, fetch it from %s first\n", info.PushBackup)
+			statusf("git fetch %s %s:%s\n\n", info.PushBackup, info.backupRef(), info.backupRef())
+		}
+		if info.Branch != "" {
+			statusf("# Move branch %s back to the backup\n", info.Branch)
+		} else {
+			statusf("# Hard reset branch to backup\n")
+		}
+		statusf("%s\n\n", info.restoreCommand())
+		if info.SquashMergesOnly {
+			statusf("# -squash-merges-only also rewrites the merge commit %s; the backup holds the original\n", info.ResetRef)
+			statusln("# merge with both of its parents, so the reset above restores it too")
+			statusln()
+		} else if info.AmendBase {
+			statusf("# -amend-into-base also rewrites the base commit %s; the backup holds the original\n", info.ResetRef)
+			statusln("# base as well, so the reset above restores it too")
+			statusln()
+		}
+
+		if info.KeepBackup {
+			statusf("# Optional: delete backup %s after verification\n", info.backupKind())
+			statusf("%s\n\n", info.deleteBackupCommand())
+		} else {
+			statusf("# Note: -keep-backup-on-success=false deletes the backup %s once the squash succeeds;\n", info.backupKind())
+			statusln("# after that, recovery is only possible via git reflog")
+		}
+	}
+
+	statusln()
+	statusln("# If a stash was involved and conflicts occurred:")
+	statusln("# git stash list")
+	statusln("# git stash apply <stash-ref>")
+	statusln("# git stash drop <stash-ref>")
+	statusln()
+
+	statusln("# End of recovery instructions")
+}
+
+// backupNoun returns a noun describing the given backups (branch, tag or ref), pluralized
+func backupNoun(refs []BackupRef) string {
+	tags := 0
+	for _, b := range refs {
+		if b.Tag {
+			tags++
+		}
+	}
+	noun := "ref"
+	switch tags {
+	case 0:
+		noun = "branch"
+	case len(refs):
+		noun = "tag"
+	}
+	if len(refs) == 1 {
+		return noun
+	}
+	if noun == "branch" {
+		return "branches"
+	}
+	return noun + "s"
+}
+
+// printBackupRefs displays all backup branches and tags with colorized output
+func printBackupRefs(refs []BackupRef) {
+	if len(refs) == 0 {
+		statusln("No backup branches found.")
+		return
+	}
+	statusf("Found %d backup %s:\n\n", len(refs), backupNoun(refs))
+	hasTags := false
+	for _, b := range refs {
+		statusf("  %s\n", formatBackupRef(b))
+		hasTags = hasTags || b.Tag
+	}
+	statusln()
+	statusln("To restore a backup:")
+	statusf("  git reset --hard %s\n", colorize(colorCyan, "<name>"))
+	statusln()
+	statusln("To delete a backup:")
+	statusf("  git branch -D %s\n", colorize(colorCyan, "<branch-name>"))
+	if hasTags {
+		statusf("  git tag -d %s\n", colorize(colorCyan, "<tag-name>"))
+	}
+	statusln()
+	statusln("To delete backups older than a given age:")
+	statusf("  locsquash -prune-backups %s\n", colorize(colorCyan, "7d"))
+}
+
+// formatBackupRef renders a single backup as: name [tag] hash date subject
+func formatBackupRef(b BackupRef) string {
+	name := colorize(colorGreen, b.Name)
+	if b.Tag {
+		name += " [tag]"
+	}
+	created := "unknown date"
+	if !b.Created.IsZero() {
+		created = b.Created.Local().Format("2006-01-02 15:04")
+	}
+	return fmt.Sprintf("%s %s %s %s", name, colorize(colorYellow, b.CommitRef), colorize(colorCyan, created), sanitizeForTerminal(b.Subject))
+}
@@ -0,0 +1,206 @@
+package squash
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+)
+
+// planVersion is bumped whenever a Plan field is renamed, removed or changes meaning
+const planVersion = 1
+
+// Plan is the computed squash written by -dump-plan, for tools that render the plan before
+// running locsquash for real. Fields are only ever added within a version.
+type Plan struct {
+	Version       int          `json:"version"`                // Format version, currently 1
+	Mode          string       `json:"mode"`                   // squash, reword, amend-into-base, squash-merges-only, squash-wip or autofixup
+	TopRef        string       `json:"top_ref"`                // Newest selected commit (HEAD, or refs/heads/<name> with -branch)
+	Head          string       `json:"head"`                   // Full hash of top_ref when the plan was made; -plan refuses to run if it moved
+	ResetRef      string       `json:"reset_ref"`              // Commit the selection sits on, such as HEAD~3
+	SquashCount   int          `json:"squash_count"`           // Number of selected commits
+	ReplayCount   int          `json:"replay_count,omitempty"` // Newer commits replayed on top of the result (-from with -to)
+	Branch        string       `json:"branch,omitempty"`       // Branch squashed without checking it out (-branch)
+	Onto          string       `json:"onto,omitempty"`         // Ref the result is moved onto (-onto)
+	Commits       []CommitInfo `json:"commits"`                // Selected commits, newest first
+	BackupName    string       `json:"backup_name,omitempty"`  // Backup created before rewriting, empty with -no-backup
+	BackupKind    string       `json:"backup_kind,omitempty"`  // "branch" or "tag", empty with -no-backup
+	CommitMessage string       `json:"commit_message"`         // Message of the resulting commit
+	Cleanup       string       `json:"cleanup,omitempty"`      // git commit --cleanup mode the message is committed with (-cleanup)
+	Author        string       `json:"author,omitempty"`       // Author of the result ("Name <email>"), empty for the git config
+	Committer     string       `json:"committer,omitempty"`    // Committer of the result ("Name <email>"), empty for the git config
+	CommitDate    string       `json:"commit_date"`            // Committer date of the result (RFC 3339)
+	AuthorDate    string       `json:"author_date"`            // Author date of the result (RFC 3339)
+	Stash         bool         `json:"stash"`                  // Uncommitted changes are stashed and reapplied around the squash
+	WouldFail     bool         `json:"would_fail"`             // A check was only waived for the dry run; the real run would fail
+}
+
+// mode names the kind of rewrite info describes
+func (info SquashInfo) mode() string {
+	switch {
+	case info.Autofixup:
+		return "autofixup"
+	case info.Reword:
+		return "reword"
+	case info.SquashMergesOnly:
+		return "squash-merges-only"
+	case info.SquashWIP:
+		return "squash-wip"
+	case info.AmendBase:
+		return "amend-into-base"
+	default:
+		return "squash"
+	}
+}
+
+// plan returns the Plan for the computed squash
+func (info SquashInfo) plan() Plan {
+	p := Plan{
+		Version:       planVersion,
+		Mode:          info.mode(),
+		TopRef:        info.TopRef,
+		Head:          info.PreHead,
+		ResetRef:      info.ResetRef,
+		SquashCount:   info.SquashCount,
+		ReplayCount:   info.ReplayCount,
+		Branch:        info.Branch,
+		Onto:          info.OntoRef,
+		Commits:       info.Commits,
+		CommitMessage: info.CommitMessage,
+		Cleanup:       info.cleanupMode(),
+		Author:        info.CommitAuthor,
+		Committer:     info.CommitCommitter,
+		CommitDate:    info.RecentDate,
+		AuthorDate:    info.AuthorDate,
+		Stash:         info.Dirty && info.AllowStash,
+		WouldFail:     info.WouldFail,
+	}
+	if !info.NoBackup {
+		p.BackupName, p.BackupKind = info.BackupName, info.backupKind()
+	}
+	if p.Commits == nil {
+		p.Commits = []CommitInfo{}
+	}
+	return p
+}
+
+// writePlan writes the plan for the computed squash as JSON to info.DumpPlan
+func writePlan(ctx context.Context, g GitRunner, info *SquashInfo) error {
+	head, err := gitResolveCommit(ctx, g, info.TopRef)
+	if err != nil {
+		return err
+	}
+	info.PreHead = head
+	data, err := json.MarshalIndent(info.plan(), "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(info.DumpPlan, append(data, '\n'), 0600)
+}
+
+// readPlan loads a plan written by -dump-plan
+func readPlan(path string) (Plan, error) {
+	var p Plan
+	data, err := os.ReadFile(path) //nolint:gosec // path is chosen by the user
+	if err != nil {
+		return p, err
+	}
+	if err = json.Unmarshal(data, &p); err != nil {
+		return p, fmt.Errorf("invalid plan: %w", err)
+	}
+	switch {
+	case p.Version != planVersion:
+		return p, fmt.Errorf("unsupported plan version %d (this locsquash reads version %d)", p.Version, planVersion)
+	case p.Head == "" || p.SquashCount < 1:
+		return p, errors.New("the plan names no commits to squash")
+	case p.ReplayCount > 0:
+		return p, errors.New("plans that replay newer commits (-from with -to) cannot be executed; rerun with the original flags")
+	}
+	return p, nil
+}
+
+// applyTo returns input with the squash described by p: the selection, message, identities
+// and backup come from the plan, while flags such as -y, -no-verify or -force still apply
+func (p Plan) applyTo(input UserInput) (UserInput, error) {
+	if input.SquashCount != 0 || input.ToRef != "" || input.FromRef != "" || input.SinceTag != "" || input.SinceLatestTag ||
+		input.Unpushed || input.SquashMergesOnly || input.SquashWIP || input.Autofixup || input.AmendBase || input.Reword ||
+		input.Branch != "" || input.OntoRef != "" || input.NewMessage != "" || input.MessageFile != "" || input.DumpPlan != "" {
+		return input, errors.New("the plan already selects the commits and message, so it cannot be combined with the range options, -branch, -onto, -m, -F or -dump-plan")
+	}
+	switch p.Mode {
+	case "squash":
+		input.SquashCount = p.SquashCount
+	case "reword":
+		input.Reword = true
+	case "amend-into-base":
+		input.AmendBase, input.SquashCount = true, p.SquashCount
+	case "squash-merges-only":
+		input.SquashMergesOnly = true
+	case "squash-wip":
+		// The commits were checked against the plan, so the -wip-pattern used for it no longer matters
+		input.AmendBase, input.SquashCount = true, p.SquashCount
+	case "autofixup":
+		input.Autofixup = true
+	default:
+		return input, fmt.Errorf("unknown plan mode %q", p.Mode)
+	}
+	input.Branch, input.OntoRef = p.Branch, p.Onto
+	if p.Mode != "autofixup" {
+		// The message is final: placeholders, trailers and sign-off were applied when planning, so Run
+		// takes it from the plan as it is. NewMessage only satisfies the check that -reword has one.
+		input.NewMessage, input.Cleanup = p.CommitMessage, p.Cleanup
+		input.ConcatMessages, input.CollectCoauthors, input.Signoff, input.Trailers, input.Edit, input.CommitTemplate = false, false, false, nil, false, false
+		input.StripComments, input.Wrap = false, 0
+		input.Author, input.KeepAuthor, input.KeepCommitter = p.Author, false, p.Committer != ""
+		// Derived dates are the same while the commits are, so only a -date override needs carrying over
+		if p.CommitDate != "" && p.CommitDate == p.AuthorDate {
+			input.Date = p.CommitDate
+		}
+	}
+	input.NoBackup, input.TagBackup = p.BackupName == "", p.BackupKind == "tag"
+	input.AllowStash = input.AllowStash || p.Stash
+	return input, nil
+}
+
+// checkPlan refuses to execute info.plan unless the computed squash selects the same commits
+func checkPlan(info *SquashInfo) error {
+	p := info.fromPlan
+	hashes := func(commits []CommitInfo) []string {
+		out := make([]string, len(commits))
+		for i, c := range commits {
+			out[i] = c.Hash
+		}
+		return out
+	}
+	if info.SquashCount != p.SquashCount || info.ResetRef != p.ResetRef || !slices.Equal(hashes(info.Commits), hashes(p.Commits)) {
+		return failf("Error: the plan no longer matches the repository: it selects %d commit(s) on %s, now %d on %s. Create a new plan with -dump-plan.",
+			p.SquashCount, p.ResetRef, info.SquashCount, info.ResetRef)
+	}
+	return nil
+}
+
+// runPlan executes the squash recorded in the plan file named by input.Plan, after checking
+// that the commits it was made for are still in place
+func runPlan(ctx context.Context, g GitRunner, input UserInput) error {
+	p, err := readPlan(input.Plan)
+	if err != nil {
+		return failCodef(exitUsage, "Error: -plan %s: %v", input.Plan, err)
+	}
+	if input, err = p.applyTo(input); err != nil {
+		return failCodef(exitUsage, "Error: -plan: %v", err)
+	}
+	if err = ensureInsideGitRepo(ctx, g); err != nil {
+		return failCodef(exitUsage, "Error: %v", err)
+	}
+	head, err := gitResolveCommit(ctx, g, p.TopRef)
+	if err != nil {
+		return failf("Error: the plan no longer matches the repository: %v. Create a new plan with -dump-plan.", err)
+	}
+	if head != p.Head {
+		return failf("Error: the plan no longer matches the repository: %s is now %s, but the plan was made for %s. Create a new plan with -dump-plan.",
+			p.TopRef, shortHash(head), shortHash(p.Head))
+	}
+	return Run(ctx, g, &SquashInfo{UserInput: input, fromPlan: &p})
+}
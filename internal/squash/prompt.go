@@ -0,0 +1,82 @@
+package squash
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// isTerminal checks if stdin is connected to a terminal
+func isTerminal() bool {
+	return fileIsTerminal(os.Stdin)
+}
+
+// confirm is the confirmation prompt shown before destructive steps; tests replace it
+var confirm = promptConfirm
+
+// promptTimeout is set by -prompt-timeout. An unanswered prompt then counts as no once it
+// expires; zero waits forever.
+var promptTimeout time.Duration
+
+// errPromptTimeout is returned by readAnswer when no answer arrived in time
+var errPromptTimeout = errors.New("no answer before the prompt timed out")
+
+// promptConfirm shows prompt and returns true if the user answers yes. End of input counts
+// as no, and an interrupt (ctx canceled) is an error. If stdin is not a terminal (e.g., piped
+// input), it returns an error instead of prompting
+func promptConfirm(ctx context.Context, prompt string) (bool, error) {
+	if !isTerminal() {
+		return false, failCodef(exitUsage, "Error: stdin is not a terminal. Use -y to skip confirmation in non-interactive mode.")
+	}
+	statusf("%s [y/N] ", prompt)
+	line, err := readAnswer(ctx, os.Stdin, promptTimeout)
+	if ctx.Err() != nil {
+		statusln()
+		return false, failCodef(exitInterrupted, "Interrupted; nothing was changed.")
+	}
+	if errors.Is(err, errPromptTimeout) {
+		statusln()
+		statusf("No answer within %s; treating it as no.\n", promptTimeout)
+		return false, nil
+	}
+	if err != nil && line == "" {
+		statusln()
+		return false, nil
+	}
+	response := strings.ToLower(strings.TrimSpace(line))
+	return response == "y" || response == "yes", nil
+}
+
+// readAnswer reads one line from r. With a positive timeout or a cancelable ctx the read runs
+// in a goroutine, and errPromptTimeout or ctx's error is returned if no line arrives first; the
+// abandoned read is harmless since nothing else reads stdin afterwards.
+func readAnswer(ctx context.Context, r io.Reader, timeout time.Duration) (string, error) {
+	if timeout <= 0 && ctx.Done() == nil {
+		return bufio.NewReader(r).ReadString('\n')
+	}
+	type answer struct {
+		line string
+		err  error
+	}
+	answers := make(chan answer, 1)
+	go func() {
+		line, err := bufio.NewReader(r).ReadString('\n')
+		answers <- answer{line, err}
+	}()
+	var expired <-chan time.Time
+	if timeout > 0 {
+		expired = time.After(timeout)
+	}
+	select {
+	case a := <-answers:
+		return a.line, a.err
+	case <-expired:
+		return "", errPromptTimeout
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+}
@@ -0,0 +1,187 @@
+package squash
+
+import (
+	"context"
+	"fmt"
+	"os"
+)
+
+// headExists reports whether the recorded pre-squash HEAD is still a commit in the repository
+func headExists(ctx context.Context, g GitRunner, head string) bool {
+	_, err := gitResolveCommit(ctx, g, head)
+	return err == nil
+}
+
+// recoverLast undoes the most recent squash recorded in the recovery manifest: it resets the
+// branch to the pre-squash HEAD and reapplies any changes that were auto-stashed by that run
+func recoverLast(ctx context.Context, g GitRunner, input UserInput) error {
+	if err := ensureInsideGitRepo(ctx, g); err != nil {
+		return failCodef(exitUsage, "Error: %v", err)
+	}
+	if err := ensureNoInProgressOps(ctx, g); err != nil {
+		return failf("Error: %v", err)
+	}
+	m, err := readManifest(ctx, g)
+	if err != nil {
+		return failf("Error: %v", err)
+	}
+
+	// Validate that the recorded state can still be restored
+	target := m.Head
+	if m.Backup != "" {
+		namespace := "refs/heads/"
+		if m.BackupTag {
+			namespace = "refs/tags/"
+		}
+		sha, rErr := gitResolveCommit(ctx, g, namespace+m.Backup)
+		switch {
+		case rErr == nil && sha != m.Head:
+			return failf("Error: backup %s points at %s, but the recorded pre-squash HEAD is %s; recover manually.", m.Backup, sha, m.Head)
+		case rErr == nil:
+			target = m.Backup
+		case headExists(ctx, g, m.Head):
+			// The backup is gone, e.g. deleted after a successful squash, but the commit it named is still there
+			warnf("backup %s no longer exists; restoring the recorded pre-squash HEAD %s instead.", m.Backup, shortHash(m.Head))
+		case m.BackupRemote != "":
+			return failf("Error: backup %s no longer exists locally; fetch it with 'git fetch %s %s%s:%s%s' and rerun -recover-last.", m.Backup, m.BackupRemote, namespace, m.Backup, namespace, m.Backup)
+		default:
+			return failf("Error: backup %s no longer exists; recover manually with 'git reflog'.", m.Backup)
+		}
+	} else if !headExists(ctx, g, m.Head) {
+		return failf("Error: the recorded pre-squash HEAD %s no longer exists; recover manually with 'git reflog'.", m.Head)
+	}
+
+	branch, err := gitCurrentBranch(ctx, g)
+	if err != nil {
+		return failCodef(exitGit, "Error determining current branch: %v", err)
+	}
+	// A -branch squash is undone the same way when the branch is still not checked out
+	if m.NoCheckout && branch != m.Branch {
+		return recoverBranch(ctx, g, input, m, target)
+	}
+	if branch != m.Branch && !input.Force {
+		return failf("Error: the last squash was on %q, but %q is checked out. Switch branches or use -force.", m.Branch, branch)
+	}
+	if err = checkRecoverTip(ctx, g, input, m, "HEAD"); err != nil {
+		return err
+	}
+
+	dirty, err := hasUncommittedChanges(ctx, g)
+	if err != nil {
+		return failCodef(exitGit, "Error checking git status: %v", err)
+	}
+	if dirty && !input.AllowStash {
+		if !input.DryRun {
+			return failCodef(exitDirty, "Error: uncommitted changes detected. Commit/stash them or rerun with -stash.")
+		}
+		warnf("uncommitted changes detected; rerun with -stash to keep them while recovering.")
+	}
+
+	statusf("Recovering the squash from %s:\n\n", m.Created.Local().Format("2006-01-02 15:04:05"))
+	statusf("  git reset --hard %s\n", target)
+	if m.StashHash != "" {
+		statusf("  git stash apply %s  # changes auto-stashed by that run\n", m.StashHash)
+	}
+	statusln()
+	if input.DryRun {
+		statusln("Dry run. No changes were made.")
+		return nil
+	}
+	if !input.Yes {
+		ok, pErr := confirm(ctx, "Restore this state?")
+		if pErr != nil {
+			return pErr
+		}
+		if !ok {
+			statusln("Aborted.")
+			return nil
+		}
+	}
+
+	if dirty {
+		ref, sErr := stashPushAndGetRef(ctx, g, "-u", recoverStashMessage)
+		if sErr != nil {
+			return failCodef(exitGit, "Failed to stash changes: %v", sErr)
+		}
+		progressf("Stashed current changes as %s; they are kept there after recovery\n", colorize(colorCyan, ref))
+	}
+	if err = runGitCommand(ctx, g, "reset", "--hard", target); err != nil {
+		return failCodef(exitGit, "Failed to reset to %s: %v", target, err)
+	}
+	if m.StashHash != "" {
+		if err = runGitCommand(ctx, g, "stash", "apply", m.StashHash); err != nil {
+			return failCodef(exitGit, "Restored HEAD, but failed to reapply the auto-stashed changes from %s: %v", m.StashHash, err)
+		}
+	}
+
+	// The manifest describes a squash that no longer exists
+	if path, pErr := manifestPath(ctx, g); pErr == nil {
+		_ = os.Remove(path)
+	}
+	progressln(colorize(colorGreen, fmt.Sprintf("Recovered %s to %s.", m.Branch, target)))
+	return nil
+}
+
+// checkRecoverTip refuses to recover when ref no longer points at the squashed commit, since
+// moving it back would discard whatever was committed since. -force recovers anyway.
+// Manifests written before the result was recorded cannot be checked.
+func checkRecoverTip(ctx context.Context, g GitRunner, input UserInput, m Manifest, ref string) error {
+	if m.Result == "" || input.Force {
+		return nil
+	}
+	tip, err := gitResolveCommit(ctx, g, ref)
+	if err != nil {
+		return failCodef(exitGit, "Error resolving %s: %v", ref, err)
+	}
+	if tip != m.Result {
+		return failf("Error: %s is at %s, but the last squash left it at %s; recovering would discard the commits made since. Use -force to recover anyway.", m.Branch, shortHash(tip), shortHash(m.Result))
+	}
+	return nil
+}
+
+// recoverBranch undoes a -branch squash by moving the branch back to target with update-ref,
+// leaving the current checkout alone just like the squash did
+func recoverBranch(ctx context.Context, g GitRunner, input UserInput, m Manifest, target string) error {
+	worktree, err := gitBranchWorktree(ctx, g, m.Branch)
+	if err != nil {
+		return failCodef(exitGit, "Error listing worktrees: %v", err)
+	}
+	if worktree != "" {
+		return failf("Error: branch %q is checked out in the worktree at %s; run -recover-last from there instead.", m.Branch, worktree)
+	}
+
+	if err = checkRecoverTip(ctx, g, input, m, "refs/heads/"+m.Branch); err != nil {
+		return err
+	}
+
+	statusf("Recovering the squash of %s from %s:\n\n", m.Branch, m.Created.Local().Format("2006-01-02 15:04:05"))
+	statusf("  git branch -f %s %s\n\n", m.Branch, target)
+	if input.DryRun {
+		statusln("Dry run. No changes were made.")
+		return nil
+	}
+	if !input.Yes {
+		ok, pErr := confirm(ctx, "Restore this state?")
+		if pErr != nil {
+			return pErr
+		}
+		if !ok {
+			statusln("Aborted.")
+			return nil
+		}
+	}
+
+	// Passing the expected tip makes git refuse the update if the branch moved in the meantime
+	args := []string{"update-ref", "-m", "locsquash: recover-last", "refs/heads/" + m.Branch, m.Head}
+	if m.Result != "" && !input.Force {
+		args = append(args, m.Result)
+	}
+	if err = runGitCommand(ctx, g, args...); err != nil {
+		return failCodef(exitGit, "Failed to move %s back to %s: %v", m.Branch, target, err)
+	}
+	if path, pErr := manifestPath(ctx, g); pErr == nil {
+		_ = os.Remove(path)
+	}
+	progressln(colorize(colorGreen, fmt.Sprintf("Recovered %s to %s.", m.Branch, target)))
+	return nil
+}
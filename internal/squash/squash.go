@@ -0,0 +1,1265 @@
+package squash
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// exitError is an error whose message is already worded for the user; main prints it verbatim
+type exitError struct {
+	msg  string
+	code int // Process exit code; zero means exitGeneral
+}
+
+func (e *exitError) Error() string {
+	return e.msg
+}
+
+// Process exit codes
+const (
+	exitGeneral = 1 // Refused by a safety guard, or any failure without a more specific code
+	exitUsage   = 2 // Invalid flags or arguments, or not run inside a git repository
+	exitDirty   = 3 // Uncommitted changes without -stash
+	exitGit     = 4 // A git command failed
+	exitNoop    = 5 // -dry-run-exit-code: the squash would be rejected or change nothing
+
+	exitInterrupted = 130 // Interrupted by SIGINT or SIGTERM, after rolling back a squash in progress
+)
+
+// exitStatus returns the process exit code for an error returned by Run
+func exitStatus(err error) int {
+	var e *exitError
+	if errors.As(err, &e) && e.code != 0 {
+		return e.code
+	}
+	return exitGeneral
+}
+
+// withExitCode returns err as an exitError with the given exit code, keeping its message
+func withExitCode(err error, code int) error {
+	var e *exitError
+	if errors.As(err, &e) {
+		return &exitError{msg: e.msg, code: code}
+	}
+	return &exitError{msg: err.Error(), code: code}
+}
+
+// failf builds an exitError from a format string, mirroring Fatalf
+func failf(format string, args ...any) error {
+	return &exitError{msg: fmt.Sprintf(format, args...)}
+}
+
+// failCodef is failf for errors with a more specific exit code than exitGeneral
+func failCodef(code int, format string, args ...any) error {
+	return &exitError{msg: fmt.Sprintf(format, args...), code: code}
+}
+
+// warnPreview reports a check that only passes because this is a preview (dry run or
+// -print-recovery); a real run would stop with an error instead
+func (info *SquashInfo) warnPreview(msg string) {
+	info.WouldFail = true
+	warnf("%s", msg)
+}
+
+// validateInput checks flag combinations that can be rejected without touching the repository
+func validateInput(input UserInput) error {
+	rangeRefs := input.ToRef != "" || input.FromRef != ""
+	autoRange := input.SinceTag != "" || input.SinceLatestTag || input.Unpushed || input.SquashMergesOnly || input.SquashWIP
+	for _, t := range input.Trailers {
+		if _, err := parseTrailer(t); err != nil {
+			return failCodef(exitUsage, "Error: invalid -trailer %q: %v", t, err)
+		}
+	}
+	if input.SquashWIP {
+		if input.AmendBase || input.Branch != "" || input.OntoRef != "" || input.MessageFrom == "newest" {
+			return failCodef(exitUsage, "Error: -squash-wip amends the first commit below the WIP ones in place, so it cannot be combined with -amend-into-base, -branch, -onto or -message-from newest.")
+		}
+		if _, err := regexp.Compile(input.wipPattern()); err != nil {
+			return failCodef(exitUsage, "Error: invalid -wip-pattern: %v", err)
+		}
+	}
+	if input.SquashMergesOnly && (input.AmendBase || input.Branch != "" || input.OntoRef != "" || input.MessageFrom == "newest") {
+		return failCodef(exitUsage, "Error: -squash-merges-only amends the newest merge commit in place, so it cannot be combined with -amend-into-base, -branch, -onto or -message-from newest.")
+	}
+	if input.AmendBase && (input.Autofixup || input.Reword || input.Branch != "" || input.OntoRef != "" || input.FromRef != "" || input.MessageFrom == "newest") {
+		return failCodef(exitUsage, "Error: -amend-into-base amends the commit below the selected ones in place, so it cannot be combined with -autofixup, -reword, -branch, -onto, -from or -message-from newest.")
+	}
+	if input.Autofixup {
+		if input.SquashCount != 0 || rangeRefs || autoRange || input.OntoRef != "" || input.Branch != "" || input.Reword ||
+			input.NewMessage != "" || input.MessageFile != "" || input.Edit || input.ConcatMessages {
+			return failCodef(exitUsage, "Error: -autofixup finds its own commits and keeps their messages, so it cannot be combined with -n, -to/-from, -since-tag, -since-latest-tag, -unpushed, -onto, -branch, -reword, -m, -F, -edit or -concat-messages.")
+		}
+		// The rebase signs the commits it rewrites with -sign/-S, but has no way to change the rest
+		if input.Author != "" || input.Date != "" || input.KeepCommitter || input.NoVerify || input.Signoff || len(input.Trailers) > 0 ||
+			input.CollectCoauthors || input.Wrap > 0 || input.StripComments || (input.Cleanup != "" && input.Cleanup != "default") {
+			return failCodef(exitUsage, "Error: -autofixup replays the commits with git rebase, which keeps their authors, dates and messages, so it cannot be combined with -author, -date, -keep-committer, -no-verify, -signoff, -trailer, -collect-coauthors, -wrap, -strip-comments or -cleanup.")
+		}
+	}
+	if input.Reword {
+		if input.SquashCount != 0 || rangeRefs || autoRange || input.OntoRef != "" || input.Branch != "" || input.ConcatMessages {
+			return failCodef(exitUsage, "Error: -reword only rewrites the latest commit's message, so it cannot be combined with -n, -to/-from, -since-tag, -since-latest-tag, -unpushed, -onto, -branch or -concat-messages.")
+		}
+		if input.NewMessage == "" && input.MessageFile == "" && !input.Edit {
+			return failCodef(exitUsage, "Error: -reword needs the new message: use -m, -F or -edit.")
+		}
+	}
+	if rangeRefs && input.SquashCount != 0 {
+		return failCodef(exitUsage, "Error: -n is mutually exclusive with -to and -from; use one or the other.")
+	}
+	selectors := 0
+	if input.SinceTag != "" && input.SinceLatestTag {
+		return failCodef(exitUsage, "Error: -since-tag and -since-latest-tag are mutually exclusive; use only one of them.")
+	}
+	for _, set := range []bool{input.SquashCount != 0 || rangeRefs, input.SinceTag != "" || input.SinceLatestTag, input.Unpushed, input.SquashMergesOnly, input.SquashWIP} {
+		if set {
+			selectors++
+		}
+	}
+	if selectors > 1 {
+		return failCodef(exitUsage, "Error: -n, -to/-from, -since-tag/-since-latest-tag, -unpushed, -squash-merges-only and -squash-wip are mutually exclusive; use only one of them.")
+	}
+	if input.AmendBase && !autoRange && !rangeRefs && input.SquashCount < 1 {
+		return failCodef(exitUsage, "Error: -n (Number of last commits to fold into the base commit) must be at least 1 with -amend-into-base.")
+	}
+	if !autoRange && !rangeRefs && !input.Reword && !input.AmendBase && !input.Autofixup && input.SquashCount < 2 {
+		return failCodef(exitUsage, "Error: -n (Number of last commits to squash) must be at least 2.")
+	}
+	if input.Branch != "" && (input.FromRef != "" || input.Unpushed || input.OntoRef != "") {
+		return failCodef(exitUsage, "Error: -branch cannot be combined with -from, -unpushed or -onto.")
+	}
+	if input.Wrap < 0 {
+		return failCodef(exitUsage, "Error: -wrap must not be negative.")
+	}
+	if input.WarnThreshold < 0 {
+		return failCodef(exitUsage, "Error: -warn-threshold must not be negative.")
+	}
+	if input.PushBackup != "" && input.NoBackup {
+		return failCodef(exitUsage, "Error: -push-backup needs a backup to push, so it cannot be combined with -no-backup.")
+	}
+	if input.StashAll && !input.StashUntracked {
+		return failCodef(exitUsage, "Error: -stash-all also stashes untracked files, so it cannot be combined with -stash-untracked=false.")
+	}
+	if input.MessageFile != "" && input.NewMessage != "" {
+		return failCodef(exitUsage, "Error: -m and -F are mutually exclusive; use one or the other.")
+	}
+	if input.Cleanup != "" && !slices.Contains(cleanupModes, input.Cleanup) {
+		return failCodef(exitUsage, "Error: invalid -cleanup %q: expected one of %s.", input.Cleanup, strings.Join(cleanupModes, ", "))
+	}
+	if input.MessageFrom != "" && input.MessageFrom != "oldest" && input.MessageFrom != "newest" {
+		return failCodef(exitUsage, "Error: invalid -message-from %q: expected oldest or newest.", input.MessageFrom)
+	}
+	if input.MessageFile == "-" && !input.Yes && !input.DryRun && !input.PrintRecovery {
+		return failCodef(exitUsage, "Error: -F - reads the message from stdin, so the confirmation prompt cannot be answered. Add -y.")
+	}
+	if input.Author != "" {
+		if _, _, err := parseAuthor(input.Author); err != nil {
+			return failCodef(exitUsage, "Error: invalid -author: %v", err)
+		}
+	}
+	if input.MaxAge != "" {
+		if _, err := parseAge(input.MaxAge); err != nil {
+			return failCodef(exitUsage, "Error: invalid -max-age value: %v", err)
+		}
+	}
+	if err := validateBackupPrefix(input.BackupPrefix); err != nil {
+		return failCodef(exitUsage, "Error: invalid -backup-prefix %q: %v", input.BackupPrefix, err)
+	}
+	if input.Date != "" {
+		if _, err := time.Parse(time.RFC3339, input.Date); err != nil {
+			return failCodef(exitUsage, "Error: invalid -date %q: expected RFC 3339 format such as 2024-06-01T12:00:00Z", input.Date)
+		}
+	}
+	return nil
+}
+
+// validateBackupPrefix checks that prefix followed by a timestamp forms a legal git ref name
+// (see git-check-ref-format)
+func validateBackupPrefix(prefix string) error {
+	switch {
+	case strings.HasPrefix(prefix, "/") || strings.HasPrefix(prefix, "-"):
+		return errors.New("must not start with '/' or '-'")
+	case strings.Contains(prefix, ".."), strings.Contains(prefix, "//"), strings.Contains(prefix, "@{"):
+		return errors.New("must not contain '..', '//' or '@{'")
+	case strings.ContainsFunc(prefix, func(r rune) bool { return r <= ' ' || r == 0x7f || strings.ContainsRune("~^:?*[\\", r) }):
+		return errors.New("must not contain spaces, control characters or any of ~ ^ : ? * [ \\")
+	}
+	for part := range strings.SplitSeq(prefix, "/") {
+		if strings.HasPrefix(part, ".") || strings.HasSuffix(part, ".lock") {
+			return errors.New("path components must not start with '.' or end with '.lock'")
+		}
+	}
+	return nil
+}
+
+// checkBackupName asks git whether info's backup ref can be created, so a name that slips past
+// validateBackupPrefix is refused before anything changes rather than when the backup is made
+func checkBackupName(ctx context.Context, g GitRunner, info *SquashInfo) error {
+	if info.NoBackup {
+		return nil
+	}
+	args := []string{"check-ref-format", "--branch", info.BackupName}
+	if info.TagBackup {
+		args = []string{"check-ref-format", info.backupRef()}
+	}
+	if _, err := gitStdout(ctx, g, args...); err != nil {
+		return failCodef(exitUsage, "Error: the backup name %q is not a valid ref name; choose a different -backup-prefix.", info.BackupName)
+	}
+	return nil
+}
+
+// Run validates the request described by info, fills in the derived fields and
+// performs the squash (or prints the dry-run/recovery plan). Errors are returned
+// ready to be shown to the user
+func Run(ctx context.Context, g GitRunner, info *SquashInfo) (err error) {
+	// Until execute starts rewriting, an interrupt only cancels reads and leaves the repository
+	// as it was; report that instead of whichever git command happened to be stopped
+	defer func() {
+		if err != nil && ctx.Err() != nil && !info.rewriting {
+			err = failCodef(exitInterrupted, "Interrupted; nothing was changed.")
+		}
+	}()
+
+	// -dump-plan records the dry run's plan, so it never executes
+	if info.DumpPlan != "" {
+		info.DryRun = true
+	}
+	if err := validateInput(info.UserInput); err != nil {
+		return err
+	}
+
+	// Check if in git repo
+	if err := ensureInsideGitRepo(ctx, g); err != nil {
+		return failCodef(exitUsage, "Error: %v", err)
+	}
+
+	// Without a commit every later step fails with a confusing git error; -branch squashes
+	// another branch, which does not need one on HEAD
+	if info.Branch == "" && headIsUnborn(ctx, g) {
+		return failf("Error: the repository has no commits yet, so there is nothing to squash.")
+	}
+
+	// In a shallow clone rev-list only sees the fetched commits, so the bounds checks
+	// below are unreliable and HEAD~N may run into the shallow boundary
+	shallow, err := gitIsShallow(ctx, g)
+	if err != nil {
+		return failCodef(exitGit, "Error checking for a shallow clone: %v", err)
+	}
+	if shallow {
+		msg := "this is a shallow clone, so the commit history is incomplete and the squash range cannot be checked reliably."
+		if info.DryRun || info.PrintRecovery {
+			info.warnPreview(msg + " Run 'git fetch --unshallow' before squashing.")
+		} else {
+			return failf("Error: %s Run 'git fetch --unshallow' first.", msg)
+		}
+	}
+
+	// -branch squashes another branch in place; the range is relative to its tip
+	info.TopRef = "HEAD"
+	if info.Branch != "" {
+		current, cErr := gitCurrentBranch(ctx, g)
+		if cErr != nil {
+			return failCodef(exitGit, "Error determining current branch: %v", cErr)
+		}
+		if info.Branch == current {
+			info.Branch = "" // The checked-out branch is squashed the usual way
+		} else {
+			if !refExists(ctx, g, "refs/heads/"+info.Branch) {
+				return failCodef(exitUsage, "Error: -branch: branch %q does not exist.", info.Branch)
+			}
+			// Moving a branch under another worktree would leave that worktree's index stale
+			worktree, wErr := gitBranchWorktree(ctx, g, info.Branch)
+			if wErr != nil {
+				return failCodef(exitGit, "Error listing worktrees: %v", wErr)
+			}
+			if worktree != "" {
+				return failf("Error: branch %q is checked out in the worktree at %s; squash it from there instead.", info.Branch, worktree)
+			}
+			info.TopRef = "refs/heads/" + info.Branch
+			if info.BranchTip, err = gitResolveCommit(ctx, g, info.TopRef); err != nil {
+				return failCodef(exitGit, "Error: %v", err)
+			}
+		}
+	}
+
+	// -reword selects HEAD alone and amends it
+	if info.Reword {
+		info.SquashCount = 1
+	}
+	// -squash-merges-only and -squash-wip are -amend-into-base with the base found for them
+	if info.SquashMergesOnly || info.SquashWIP {
+		info.AmendBase = true
+	}
+
+	// Derive the squash count from -from/-to before anything else relies on it
+	switch {
+	case info.FromRef != "":
+		r, rErr := gitResolveRange(ctx, g, info.FromRef, info.ToRef)
+		if rErr != nil {
+			return failCodef(exitUsage, "Error: %v", rErr)
+		}
+		if r.Count < 2 {
+			return failf("Error: -from %s selects %d commit(s); at least 2 are needed to squash.", info.FromRef, r.Count)
+		}
+		info.SquashCount = r.Count
+		if r.Replay > 0 {
+			info.TopRef = r.Top
+			info.ReplayCount = r.Replay
+		}
+	case info.Unpushed:
+		upstream, uErr := gitStdout(ctx, g, "rev-parse", "--abbrev-ref", "--symbolic-full-name", "@{upstream}")
+		if uErr != nil {
+			return failf("Error: the current branch has no upstream. Set one with 'git branch --set-upstream-to=<remote>/<branch>' or use -n.")
+		}
+		// Count from the merge base so a diverged upstream still selects only local commits
+		base, bErr := gitStdout(ctx, g, "merge-base", upstream, "HEAD")
+		if bErr != nil {
+			return failCodef(exitGit, "Error: cannot find a common ancestor with %s: %v", upstream, bErr)
+		}
+		count, cErr := gitCountToRef(ctx, g, base, info.TopRef)
+		if cErr != nil {
+			return failCodef(exitUsage, "Error: %v", cErr)
+		}
+		if count < 2 {
+			return failf("Error: only %d commit(s) are ahead of %s; nothing meaningful to squash.", count, upstream)
+		}
+		info.SquashCount = count
+	case info.SinceTag != "" || info.SinceLatestTag:
+		if info.SinceLatestTag {
+			tag, tErr := gitStdout(ctx, g, "describe", "--tags", "--abbrev=0")
+			if tErr != nil {
+				return failf("Error: no tags found to squash since. Use -n or -to to select the commits instead.")
+			}
+			info.SinceTag = tag
+		}
+		count, cErr := gitCountToRef(ctx, g, info.SinceTag, info.TopRef)
+		if cErr != nil {
+			return failCodef(exitUsage, "Error: %v", cErr)
+		}
+		if count < 2 {
+			return failf("Error: -since-tag %s selects %d commit(s); at least 2 are needed to squash.", info.SinceTag, count)
+		}
+		info.SquashCount = count
+	case info.SquashMergesOnly:
+		merge, mErr := gitStdout(ctx, g, "rev-list", "--first-parent", "--merges", "-1", info.TopRef)
+		if mErr != nil {
+			return failCodef(exitGit, "Error looking for a merge commit: %v", mErr)
+		}
+		if merge == "" {
+			return failf("Error: -squash-merges-only found no merge commit on the current branch.")
+		}
+		// Everything above the newest merge is a plain commit, so the range holds no merges
+		count, cErr := gitCountToRef(ctx, g, merge, info.TopRef)
+		if cErr != nil {
+			return failCodef(exitGit, "Error: %v", cErr)
+		}
+		if count < 1 {
+			return failf("Error: %s is the newest merge commit and has no follow-up commits to fold into it.", info.TopRef)
+		}
+		info.SquashCount = count
+	case info.SquashWIP:
+		wip := regexp.MustCompile(info.wipPattern()) // validated in validateInput
+		count, cErr := gitCountWIP(ctx, g, info.TopRef, wip)
+		if cErr != nil {
+			return failCodef(exitGit, "Error reading commit subjects: %v", cErr)
+		}
+		if count < 0 {
+			return failf("Error: every commit on the branch matches -wip-pattern %q, so there is no commit to fold them into.", info.wipPattern())
+		}
+		if count == 0 {
+			statusf("Nothing to squash: the subject of %s does not match -wip-pattern %q.\n", info.TopRef, info.wipPattern())
+			return nil
+		}
+		info.SquashCount = count
+	case info.ToRef != "":
+		count, cErr := gitCountToRef(ctx, g, info.ToRef, info.TopRef)
+		if cErr != nil {
+			return failCodef(exitUsage, "Error: %v", cErr)
+		}
+		if count < 2 {
+			return failf("Error: -to %s selects %d commit(s); at least 2 are needed to squash.", info.ToRef, count)
+		}
+		info.SquashCount = count
+	}
+
+	// Check if git has an operation in progress
+	if err := ensureNoInProgressOps(ctx, g); err != nil {
+		return failf("Error: %v", err)
+	}
+
+	// A leftover auto-stash means an earlier run never restored its changes; stacking
+	// another one on top makes that harder to untangle
+	leftover, err := gitLeftoverAutoStashes(ctx, g)
+	if err != nil {
+		return failCodef(exitGit, "Error listing stashes: %v", err)
+	}
+	if len(leftover) > 0 && !info.Force {
+		msg := fmt.Sprintf("%s holds an auto-stash left behind by an earlier locsquash run, with changes that may never have been restored.", strings.Join(leftover, ", "))
+		if info.DryRun || info.PrintRecovery {
+			info.warnPreview(msg + " Rerun with -force to proceed.")
+		} else {
+			return failf("Error: %s Inspect it with 'git stash show -p %s' and apply or drop it, or use -force to squash anyway.", msg, leftover[0])
+		}
+	}
+
+	totalCommits, err := gitCommitCount(ctx, g, info.TopRef)
+	if err != nil {
+		return failCodef(exitGit, "Error retrieving commit count: %v", err)
+	}
+	if totalCommits < 2 {
+		return failf("Error: repository only has %d commit; need at least 2 commits to squash.", totalCommits)
+	}
+	if info.SquashCount >= totalCommits {
+		return failf("Error: repository has %d commits; -n must be at most %d (one commit must remain as the base).", totalCommits, totalCommits-1)
+	}
+
+	// A squash on a detached HEAD moves no branch, so the result is only reachable via the reflog
+	detached, err := gitIsDetached(ctx, g)
+	if err != nil {
+		return failCodef(exitGit, "Error checking for detached HEAD: %v", err)
+	}
+	if detached && info.Branch == "" {
+		msg := "HEAD is detached; the squashed commit will not be on any branch and will only be reachable via the reflog."
+		switch {
+		case info.NoBackup:
+			return failf("Error: %s Refusing to squash a detached HEAD with -no-backup.", msg)
+		case info.Force:
+		case info.DryRun || info.PrintRecovery:
+			info.warnPreview(msg + " Rerun with -force to proceed.")
+		default:
+			return failf("Error: %s Check out a branch first, or use -force to squash anyway.", msg)
+		}
+	}
+
+	// Guard shared branches such as main/master against accidental rewrites
+	branch, err := gitCurrentBranch(ctx, g)
+	if err != nil {
+		return failCodef(exitGit, "Error determining current branch: %v", err)
+	}
+	if info.Branch != "" {
+		branch = info.Branch
+	}
+	if slices.Contains(protectedBranches(info.Protected), branch) && !info.Force {
+		msg := fmt.Sprintf("branch %q is protected; squashing would rewrite shared history.", branch)
+		if info.DryRun || info.PrintRecovery {
+			info.warnPreview(msg + " Rerun with -force to proceed.")
+		} else {
+			return failf("Error: %s Use -force to squash anyway, or switch to a feature branch.", msg)
+		}
+	}
+
+	// Check for uncommitted changes; -branch leaves the checkout alone, so they don't matter there
+	if info.Branch == "" {
+		info.Dirty, err = hasUncommittedChanges(ctx, g)
+		if err != nil {
+			return failCodef(exitGit, "Error checking git status: %v", err)
+		}
+		if info.Dirty && !info.AllowStash {
+			if info.DryRun || info.PrintRecovery {
+				info.warnPreview("uncommitted changes detected. Preview may not reflect a clean working tree; use -stash to simulate a clean state.")
+			} else {
+				return failCodef(exitDirty, "Error: uncommitted changes detected. Commit/stash them or rerun with -stash.")
+			}
+		}
+		// Untracked files that are not stashed are left alone by the squash, so only
+		// tracked changes make a stash necessary
+		if info.Dirty && info.AllowStash && info.stashInclude() == "" {
+			info.Dirty, err = hasTrackedChanges(ctx, g)
+			if err != nil {
+				return failCodef(exitGit, "Error checking git status: %v", err)
+			}
+		}
+	}
+
+	// -yes-if-clean skips the prompt only when there is nothing uncommitted at stake; -yes always does
+	if info.YesIfClean && !info.Yes {
+		dirty, dErr := hasUncommittedChanges(ctx, g)
+		if dErr != nil {
+			return failCodef(exitGit, "Error checking git status: %v", dErr)
+		}
+		info.Yes, info.confirmedClean = !dirty, !dirty
+	}
+
+	// -autofixup picks its own commits, so the range checks below don't apply
+	if info.Autofixup {
+		return runAutofixup(ctx, g, info)
+	}
+
+	// The count above includes every reachable commit, but the squash walks first parents only:
+	// with a second root merged in, or grafts, TopRef~N can run past the root even below that count
+	if _, err = gitResolveCommit(ctx, g, fmt.Sprintf("%s~%d", info.TopRef, info.SquashCount)); err != nil {
+		out, dErr := gitStdout(ctx, g, "rev-list", "--count", "--first-parent", info.TopRef)
+		depth, aErr := strconv.Atoi(out)
+		if dErr != nil || aErr != nil {
+			return failCodef(exitGit, "Error: %s~%d does not resolve: %v", info.TopRef, info.SquashCount, err)
+		}
+		return failf("Error: %s~%d does not exist: %s has only %d commits along its first parents, so at most %d can be squashed (one commit must remain as the base).",
+			info.TopRef, info.SquashCount, info.TopRef, depth, depth-1)
+	}
+
+	// Compute result commit; with -amend-into-base the base commit is the oldest one it contains
+	oldestCommitRef := fmt.Sprintf("%s~%d", info.TopRef, info.SquashCount-1)
+	if info.AmendBase {
+		oldestCommitRef = fmt.Sprintf("%s~%d", info.TopRef, info.SquashCount)
+	}
+	// The default message comes from the oldest commit unless -message-from newest
+	messageRef := oldestCommitRef
+	if info.MessageFrom == "newest" {
+		messageRef = info.TopRef
+	}
+	defaultMessage, err := gitLogSingle(ctx, g, messageRef, "%B")
+	if err != nil {
+		return failCodef(exitGit, "Failed to retrieve %s commit message: %v", info.messageFrom(), err)
+	}
+	defaultMessage = strings.TrimSpace(defaultMessage)
+
+	if info.MessageFile != "" {
+		message, fErr := readMessageFile(info.MessageFile)
+		if fErr != nil {
+			return failCodef(exitUsage, "Error: -F: %v", fErr)
+		}
+		info.NewMessage = message
+	}
+	// -commit-template starts from the same message as a plain git commit would
+	if info.CommitTemplate && info.NewMessage == "" {
+		template, tErr := gitCommitTemplate(ctx, g)
+		if tErr != nil {
+			return failCodef(exitUsage, "Error: -commit-template: cannot read commit.template: %v", tErr)
+		}
+		if template != "" {
+			defaultMessage = template
+		}
+	}
+	// Guard against an -n that reaches further back than intended
+	if info.MaxAge != "" {
+		maxAge, _ := parseAge(info.MaxAge) // validated in validateInput
+		oldestDate, dErr := gitLogSingle(ctx, g, oldestCommitRef, "%cI")
+		if dErr != nil {
+			return failCodef(exitGit, "Failed to retrieve oldest commit date: %v", dErr)
+		}
+		committed, pErr := time.Parse(time.RFC3339, strings.TrimSpace(oldestDate))
+		if pErr != nil {
+			return failf("Error: cannot parse oldest commit date %q: %v", oldestDate, pErr)
+		}
+		if age := time.Since(committed); age > maxAge {
+			msg := fmt.Sprintf("the oldest selected commit is %s old, older than -max-age %s.", age.Round(time.Minute), info.MaxAge)
+			if info.DryRun || info.PrintRecovery {
+				info.warnPreview(msg + " Select fewer commits or raise -max-age.")
+			} else {
+				return failf("Error: %s Select fewer commits or raise -max-age.", msg)
+			}
+		}
+	}
+
+	// Read both dates of the newest commit in one call; {date} in the message needs them.
+	// The committer identity comes along for -keep-committer. An amended base commit keeps
+	// its own dates and committer instead.
+	datesRef := info.TopRef
+	if info.AmendBase {
+		datesRef = oldestCommitRef
+	}
+	dates, err := gitLogSingle(ctx, g, datesRef, "%cI%x00%aI%x00%cn <%ce>")
+	if err != nil {
+		return failCodef(exitGit, "Failed to retrieve %s commit dates: %v", datesRef, err)
+	}
+	recentDate, rest, _ := strings.Cut(dates, "\x00")
+	authorDate, committer, _ := strings.Cut(rest, "\x00")
+	info.RecentDate = strings.TrimSpace(recentDate)
+	info.AuthorDate = strings.TrimSpace(authorDate)
+	if info.KeepCommitter {
+		info.CommitCommitter = strings.TrimSpace(committer)
+	}
+
+	// Amending rewrites the commit object, so a signature on it only survives if it is signed again
+	if opts := info.commitOptions(); opts.Amend && opts.signArg() == "" {
+		amendedRef := info.TopRef
+		if info.AmendBase {
+			amendedRef = oldestCommitRef
+		}
+		if signed, sErr := gitCommitIsSigned(ctx, g, amendedRef); sErr == nil && signed {
+			warnf("%s is GPG-signed, and amending it drops the signature. Pass -sign or -S <keyid> to sign the amended commit.", amendedRef)
+		}
+	}
+
+	if info.Date != "" {
+		info.RecentDate = info.Date
+		info.AuthorDate = info.Date
+	}
+
+	// A plan carries the final message; building it again would expand placeholders and append trailers twice
+	if info.fromPlan != nil {
+		info.CommitMessage = info.fromPlan.CommitMessage
+	} else if err = buildCommitMessage(ctx, g, info, oldestCommitRef, defaultMessage); err != nil {
+		return err
+	}
+	// git commit would abort on the empty message only after history was rewritten; -edit checks its own result
+	if !info.Edit {
+		cleaned := cleanupForMode(info.CommitMessage, info.cleanupMode())
+		if strings.TrimSpace(cleaned) == "" {
+			return failf("Error: the commit message is empty once -cleanup %s is applied. Use -m to provide a message, or -cleanup whitespace to keep lines starting with '#'.", info.cleanupMode())
+		}
+		// A subject such as "#42 fix the parser" would silently give way to the first body line
+		if subject, _, _ := strings.Cut(strings.TrimSpace(info.CommitMessage), "\n"); strings.HasPrefix(subject, "#") && !strings.HasPrefix(cleaned, subject) {
+			return failf("Error: -cleanup %s would remove the subject line %q because it starts with '#'. Use -cleanup whitespace to keep it.", info.cleanupMode(), subject)
+		}
+	}
+
+	// Attribute the result to the author of the oldest commit unless overridden
+	info.CommitAuthor = info.Author
+	if info.CommitAuthor == "" && info.KeepAuthor {
+		author, aErr := gitLogSingle(ctx, g, oldestCommitRef, "%an <%ae>")
+		if aErr != nil {
+			return failCodef(exitGit, "Failed to retrieve oldest commit author: %v", aErr)
+		}
+		info.CommitAuthor = author
+	}
+
+	info.BackupName = info.backupPrefix() + time.Now().UTC().Format(backupTimeFormat)
+	if err = checkBackupName(ctx, g, info); err != nil {
+		return err
+	}
+	info.ResetRef = fmt.Sprintf("%s~%d", info.TopRef, info.SquashCount)
+
+	if info.OntoRef != "" {
+		if _, oErr := gitResolveCommit(ctx, g, info.OntoRef); oErr != nil {
+			return failCodef(exitUsage, "Error: -onto: %v", oErr)
+		}
+		// ResetRef is relative to HEAD, so pin it before HEAD moves
+		info.BaseCommit, err = gitResolveCommit(ctx, g, info.ResetRef)
+		if err != nil {
+			return failCodef(exitGit, "Error: %v", err)
+		}
+	}
+
+	// Refuse to silently flatten merges: a soft reset drops the second parent's history
+	merges, err := gitMergeCommits(ctx, g, info.ResetRef, info.TopRef)
+	if err != nil {
+		return failCodef(exitGit, "Error checking for merge commits: %v", err)
+	}
+	// Amending keeps the parents, so a reworded merge stays a merge
+	if len(merges) > 0 && !info.AllowMerges && !info.Reword {
+		msg := fmt.Sprintf("the selected range contains %d merge commit(s) (%s); squashing would flatten their history.", len(merges), strings.Join(merges, ", "))
+		if info.DryRun || info.PrintRecovery {
+			info.warnPreview(msg + " Rerun with -allow-merges to proceed.")
+		} else {
+			return failf("Error: %s Use -allow-merges to squash anyway.", msg)
+		}
+	}
+
+	// Rewriting commits that are already on a remote requires a force-push
+	remotes, err := gitRemoteBranchesContaining(ctx, g, oldestCommitRef)
+	if err != nil {
+		return failCodef(exitGit, "Error checking remote branches: %v", err)
+	}
+	if len(remotes) > 0 && !info.ForcePushed {
+		msg := fmt.Sprintf("some of the selected commits already exist on %s; squashing them will require a force-push.", strings.Join(remotes, ", "))
+		if info.DryRun || info.PrintRecovery {
+			info.warnPreview(msg + " Rerun with -force-pushed to proceed.")
+		} else {
+			return failf("Error: %s Use -force-pushed to squash anyway.", msg)
+		}
+	}
+
+	// Preflight the net change of the whole range; empty commits inside it don't matter since
+	// only the result's emptiness is governed by -allow-empty
+	hasChanges, err := gitHasChangesBetween(ctx, g, info.ResetRef, info.TopRef)
+	if err != nil {
+		return failCodef(exitGit, "Error checking commit diff: %v", err)
+	}
+	if !hasChanges && !info.AllowEmpty && !info.Reword {
+		return failf("Error: selected commits result in no net changes. Use -allow-empty to create an empty commit.")
+	}
+
+	// Retrieve commit list for preview
+	info.Commits, err = collectCommits(ctx, g, info.ResetRef, info.TopRef, info.Stat || info.JSON || info.DumpPlan != "")
+	if err != nil {
+		return failCodef(exitGit, "Error retrieving commit list: %v", err)
+	}
+	if info.fromPlan != nil {
+		if err = checkPlan(info); err != nil {
+			return err
+		}
+	}
+
+	if info.DryRun && info.ShowDiff && !info.JSON {
+		colorArg := "--color=never"
+		if colorEnabled(stdoutIsTerminal) {
+			colorArg = "--color=always"
+		}
+		info.Diff, err = gitStdout(ctx, g, "diff", colorArg, info.ResetRef, info.TopRef)
+		if err != nil {
+			return failCodef(exitGit, "Error retrieving combined diff: %v", err)
+		}
+	}
+
+	if info.DryRun {
+		if err = info.printDryRun(); err != nil {
+			return failCodef(exitGeneral, "Error encoding JSON output: %v", err)
+		}
+	}
+	if info.DumpPlan != "" {
+		if err = writePlan(ctx, g, info); err != nil {
+			return failf("Error: -dump-plan: cannot write the plan: %v", err)
+		}
+	}
+
+	if info.showsRecovery() {
+		info.printRecovery()
+	}
+
+	if info.DryRun && info.DryRunExitCode && info.WouldFail {
+		return &exitError{code: exitNoop}
+	}
+	if info.DryRun || info.PrintRecovery {
+		return nil
+	}
+
+	// Let the user edit the message before anything is rewritten
+	if info.Edit {
+		edited, eErr := editMessage(ctx, info.CommitMessage, info.cleanupMode())
+		if eErr != nil {
+			return failf("Aborting squash: %v", eErr)
+		}
+		info.CommitMessage = edited
+		if info.Conventional {
+			if cErr := checkConventional(info.CommitMessage, info.CommitTypes); cErr != nil {
+				return failf("Aborting squash: edited message is not a Conventional Commit: %v", cErr)
+			}
+		}
+	}
+
+	// Guard against a mistyped count such as -n 50 for -n 5: only an explicit -yes skips this
+	if info.WarnThreshold > 0 && info.SquashCount > info.WarnThreshold && (!info.Yes || info.confirmedClean) {
+		subject, sErr := gitLogSingle(ctx, g, oldestCommitRef, "%s")
+		if sErr != nil {
+			return failCodef(exitGit, "Failed to retrieve oldest commit subject: %v", sErr)
+		}
+		warnf("this will squash %d commits, more than -warn-threshold %d. The oldest is %q; make sure that is where you meant to start.",
+			info.SquashCount, info.WarnThreshold, sanitizeForTerminal(strings.TrimSpace(subject)))
+		info.Yes = false // -yes-if-clean does not cover this, so the prompt below is shown
+	}
+
+	// Show commits and prompt for confirmation (unless -yes); -preview shows them even with -yes
+	if !info.Yes || info.Preview {
+		info.printCommitList()
+		// The diffstat is only a nicety on terminals, but -preview asks for it explicitly
+		if info.Preview {
+			stat, sErr := gitDiffStat(ctx, g, info.ResetRef, info.TopRef)
+			if sErr != nil {
+				return failCodef(exitGit, "Error retrieving diffstat: %v", sErr)
+			}
+			printDiffStat(stat)
+		} else if stdoutIsTerminal() {
+			if stat, sErr := gitDiffStat(ctx, g, info.ResetRef, info.TopRef); sErr == nil {
+				printDiffStat(stat)
+			}
+		}
+	}
+	if !info.Yes {
+		ok, pErr := confirm(ctx, "Proceed?")
+		if pErr != nil {
+			return pErr
+		}
+		if !ok {
+			statusln("Aborted.")
+			return nil
+		}
+	}
+
+	return execute(ctx, g, info)
+}
+
+// execute rewrites history for a fully prepared SquashInfo: stash, backup, squash, unstash
+func execute(ctx context.Context, g GitRunner, info *SquashInfo) error {
+	if info.Branch != "" {
+		return executeOnBranch(ctx, g, info)
+	}
+
+	// Stash if needed
+	stashedRef := ""
+	var stashedPaths []string
+	if info.Dirty && info.AllowStash {
+		// Remember what is being stashed so the reapplied result can be verified
+		paths, err := gitStatusPaths(ctx, g)
+		if err != nil {
+			return failCodef(exitGit, "Error checking git status: %v", err)
+		}
+		stashedPaths = paths
+		ref, err := stashPushAndGetRef(ctx, g, info.stashInclude(), autoStashMessage)
+		if err != nil {
+			return failCodef(exitGit, "Failed to stash changes: %v", err)
+		}
+		stashedRef = ref
+		progressf("Stashed working directory changes as %s\n", colorize(colorCyan, stashedRef))
+	}
+
+	// Create recovery branch before rewriting history (unless -no-backup)
+	if !info.NoBackup {
+		createdName, err := createBackupRef(ctx, g, info.BackupName, "HEAD", info.TagBackup)
+		if err != nil {
+			return failCodef(exitGit, "Failed to create backup %s %q: %v%s", info.backupKind(), info.BackupName, err, restoreStash(ctx, g, stashedRef))
+		}
+		info.BackupName = createdName
+		progressf("Created backup %s: %s (recovery point)\n", info.backupKind(), colorize(colorGreen, info.BackupName))
+		pushBackup(ctx, g, info)
+	} else {
+		info.BackupName = "" // Clear so recoveryHint knows no backup exists
+	}
+
+	// From here on an interrupt leaves history half-rewritten, so it has to go through recoverFromBackup
+	info.rewriting = true
+
+	// Record how to undo this run before rewriting anything
+	if err := recordManifest(ctx, g, info, stashedRef); err != nil {
+		warnf("failed to write recovery manifest: %v", err)
+	}
+
+	if info.ReplayCount > 0 {
+		// Build the squashed commit off to the side, then replay the newer commits onto it
+		progressln("Creating squashed commit...")
+		squashed, err := gitCommitTree(ctx, g, info.TopRef, info.ResetRef, info.commitOptions())
+		if err != nil {
+			return recoverFromBackup(ctx, g, info, stashedRef, fmt.Sprintf("Failed to create squashed commit: %v", err))
+		}
+		progressf("Replaying %d newer commit(s) onto the squashed commit...\n", info.ReplayCount)
+		if err = runGitCommand(ctx, g, info.commitOptions().rebaseOntoArgs(squashed, info.TopRef)...); err != nil {
+			_ = runGitCommand(ctx, g, "rebase", "--abort")
+			return recoverFromBackup(ctx, g, info, stashedRef, fmt.Sprintf("Failed to replay commits newer than the range (rebase aborted): %v", err))
+		}
+	} else if info.Autofixup {
+		progressf("Folding %d fixup commit(s) with git rebase --autosquash...\n", info.SquashCount)
+		if err := g.Run(ctx, autosquashEnv, info.autosquashArgs()...); err != nil {
+			_ = runGitCommand(ctx, g, "rebase", "--abort")
+			return recoverFromBackup(ctx, g, info, stashedRef, fmt.Sprintf("Failed to fold the fixup commits (rebase aborted): %v", err))
+		}
+	} else if info.AmendBase {
+		// The soft reset leaves the base commit as HEAD with the whole range staged on top of it
+		progressf("Performing soft reset to %s...\n", info.ResetRef)
+		if err := runGitCommand(ctx, g, "reset", "--soft", info.ResetRef); err != nil {
+			return recoverFromBackup(ctx, g, info, stashedRef, fmt.Sprintf("Failed to perform soft reset: %v", err))
+		}
+		progressln("Amending the base commit...")
+		if err := gitCommitWithDates(ctx, g, info.commitOptions()); err != nil {
+			return recoverFromBackup(ctx, g, info, stashedRef, fmt.Sprintf("Failed to amend the base commit: %v", err))
+		}
+	} else if info.Reword {
+		// Amend keeps HEAD's tree and parents; the index was checked or stashed clean above
+		progressln("Rewording the latest commit...")
+		if err := gitCommitWithDates(ctx, g, info.commitOptions()); err != nil {
+			return recoverFromBackup(ctx, g, info, stashedRef, fmt.Sprintf("Failed to reword the latest commit: %v", err))
+		}
+	} else {
+		// Soft reset to HEAD~N
+		progressf("Performing soft reset to %s...\n", info.ResetRef)
+		if err := runGitCommand(ctx, g, "reset", "--soft", info.ResetRef); err != nil {
+			return recoverFromBackup(ctx, g, info, stashedRef, fmt.Sprintf("Failed to perform soft reset: %v", err))
+		}
+
+		// Commit staged changes as one, with date = most recent commit date
+		progressln("Creating squashed commit...")
+		if err := gitCommitWithDates(ctx, g, info.commitOptions()); err != nil {
+			return recoverFromBackup(ctx, g, info, stashedRef, fmt.Sprintf("Failed to create squashed commit: %v", err))
+		}
+	}
+
+	// Move the result onto the requested base; the squashed commit is the first one after BaseCommit
+	if info.OntoRef != "" {
+		progressf("Moving the squashed commit onto %s...\n", info.OntoRef)
+		if err := runGitCommand(ctx, g, info.commitOptions().rebaseOntoArgs(info.OntoRef, info.BaseCommit)...); err != nil {
+			_ = runGitCommand(ctx, g, "rebase", "--abort")
+			return recoverFromBackup(ctx, g, info, stashedRef, fmt.Sprintf("Failed to move the squashed commit onto %s (rebase aborted): %v", info.OntoRef, err))
+		}
+	}
+
+	// Record the result right away, before anything else can move HEAD
+	if head, err := gitStdout(ctx, g, "rev-parse", "HEAD"); err == nil {
+		info.ResultCommit = head
+	} else {
+		warnf("could not read the squashed commit hash: %v", err)
+	}
+
+	// Reapply stash if we created one: apply first, then drop only if success
+	if stashedRef != "" {
+		progressf("Reapplying stashed changes from %s...\n", stashedRef)
+		if err := runGitCommand(ctx, g, "stash", "apply", stashedRef); err != nil {
+			return recoverFromBackup(ctx, g, info, stashedRef, fmt.Sprintf("Failed to reapply stashed changes from %s: %v", stashedRef, err))
+		}
+		// Only drop the stash once every stashed path shows up again in the working tree
+		missing, err := missingStatusPaths(ctx, g, stashedPaths)
+		switch {
+		case err != nil:
+			warnf("could not verify the reapplied changes (%v); keeping %s. Drop it with 'git stash drop %s' once you have checked the working tree.", err, stashedRef, stashedRef)
+		case len(missing) > 0:
+			warnf("after reapplying %s, these stashed paths show no changes: %s; keeping the stash. Drop it with 'git stash drop %s' once you have checked the working tree.", stashedRef, strings.Join(missing, ", "), stashedRef)
+		default:
+			if err = runGitCommand(ctx, g, "stash", "drop", stashedRef); err != nil {
+				return failCodef(exitGit, "Applied stash but failed to drop %s: %v\nYou can drop it manually later.%s", stashedRef, err, recoveryHint(info.BackupName))
+			}
+		}
+	}
+
+	return finishSquash(ctx, g, info)
+}
+
+// executeOnBranch squashes info.Branch without checking it out: the squashed commit is built
+// with commit-tree and the branch is moved with update-ref, so HEAD and the working tree stay put
+func executeOnBranch(ctx context.Context, g GitRunner, info *SquashInfo) error {
+	if !info.NoBackup {
+		createdName, err := createBackupRef(ctx, g, info.BackupName, info.BranchTip, info.TagBackup)
+		if err != nil {
+			return failCodef(exitGit, "Failed to create backup %s %q: %v", info.backupKind(), info.BackupName, err)
+		}
+		info.BackupName = createdName
+		progressf("Created backup %s: %s (recovery point)\n", info.backupKind(), colorize(colorGreen, info.BackupName))
+		pushBackup(ctx, g, info)
+	} else {
+		info.BackupName = ""
+	}
+
+	if err := recordManifest(ctx, g, info, ""); err != nil {
+		warnf("failed to write recovery manifest: %v", err)
+	}
+
+	progressln("Creating squashed commit...")
+	squashed, err := gitCommitTree(ctx, g, info.TopRef, info.ResetRef, info.commitOptions())
+	if err != nil {
+		return failCodef(exitGit, "Failed to create squashed commit: %v\nBranch %s was not changed.", err, info.Branch)
+	}
+	// Passing the old tip makes git refuse the update if the branch moved in the meantime
+	progressf("Moving branch %s to the squashed commit...\n", info.Branch)
+	reason := fmt.Sprintf("locsquash: squash %d commits", info.SquashCount)
+	if err = runGitCommand(ctx, g, "update-ref", "-m", reason, info.TopRef, squashed, info.BranchTip); err != nil {
+		return failCodef(exitGit, "Failed to update branch %s: %v\nThe branch was not changed.", info.Branch, err)
+	}
+	info.ResultCommit = squashed
+
+	return finishSquash(ctx, g, info)
+}
+
+// buildCommitMessage sets info.CommitMessage from -m/-F (with placeholders expanded) or
+// defaultMessage, then applies -concat-messages, the cleanup, -wrap and the trailers
+func buildCommitMessage(ctx context.Context, g GitRunner, info *SquashInfo, oldestCommitRef, defaultMessage string) error {
+	if strings.Contains(info.NewMessage, "{") {
+		values, vErr := messagePlaceholders(ctx, g, info, oldestCommitRef)
+		if vErr != nil {
+			return failCodef(exitGit, "Failed to expand message placeholders: %v", vErr)
+		}
+		info.NewMessage = expandPlaceholders(info.NewMessage, values)
+	}
+	info.CommitMessage = strings.TrimSpace(info.NewMessage)
+	if info.ConcatMessages {
+		messages, mErr := gitLogMessages(ctx, g, info.TopRef, info.SquashCount)
+		if mErr != nil {
+			return failCodef(exitGit, "Failed to retrieve commit messages: %v", mErr)
+		}
+		info.CommitMessage = concatMessages(info.CommitMessage, messages)
+	}
+	if info.CommitMessage == "" {
+		info.CommitMessage = defaultMessage
+	}
+	// Clean up before anything is appended, and before -edit, so the editor starts from the clean text
+	if info.StripComments {
+		if info.CommitMessage = cleanupMessage(info.CommitMessage); info.CommitMessage == "" {
+			return failf("Error: the commit message is empty once -strip-comments removed its comment lines. Use -m to provide a message.")
+		}
+	}
+	// Wrap before the trailers are appended, which must stay on one line each
+	if info.Wrap > 0 {
+		info.CommitMessage = wrapBody(info.CommitMessage, info.Wrap)
+	}
+	if info.CollectCoauthors {
+		messages, mErr := gitLogMessages(ctx, g, info.TopRef, info.SquashCount)
+		if mErr != nil {
+			return failCodef(exitGit, "Failed to retrieve commit messages: %v", mErr)
+		}
+		info.CommitMessage = appendCoauthors(info.CommitMessage, messages)
+	}
+	if info.Conventional {
+		if cErr := checkConventional(info.CommitMessage, info.CommitTypes); cErr != nil {
+			return failf("Error: commit message is not a Conventional Commit: %v. Use -m to provide a conforming message.", cErr)
+		}
+	}
+	if len(info.Trailers) > 0 {
+		trailers := make([]string, 0, len(info.Trailers))
+		for _, t := range info.Trailers {
+			trailer, _ := parseTrailer(t) // validated in validateInput
+			trailers = append(trailers, trailer)
+		}
+		info.CommitMessage = appendTrailers(info.CommitMessage, trailers)
+	}
+	if info.Signoff {
+		ident, sErr := gitSignoffIdent(ctx, g)
+		if sErr != nil {
+			return failCodef(exitUsage, "Error: -signoff: %v", sErr)
+		}
+		info.CommitMessage = appendSignoff(info.CommitMessage, ident)
+	}
+	return nil
+}
+
+// finishSquash logs the squash, drops the backup if asked to, reports the result and runs -exec
+func finishSquash(ctx context.Context, g GitRunner, info *SquashInfo) error {
+	if info.ResultCommit != "" {
+		if err := recordManifestResult(ctx, g, info.ResultCommit); err != nil {
+			warnf("failed to update the recovery manifest: %v", err)
+		}
+	}
+
+	// Log the rewrite while the backup name is still known
+	if !info.NoHistory {
+		if err := appendHistory(ctx, g, info); err != nil {
+			warnf("failed to append to the squash history: %v", err)
+		}
+	}
+
+	// Drop the backup once the squash succeeded, if asked to
+	deletedBackup := ""
+	if info.BackupName != "" && !info.KeepBackup {
+		if _, err := gitStdout(ctx, g, "status", "--porcelain"); err != nil {
+			warnf("git status failed after the squash; keeping backup %s %s: %v", info.backupKind(), info.BackupName, err)
+		} else if err = deleteBackupRef(ctx, g, BackupRef{Name: info.BackupName, Tag: info.TagBackup}); err != nil {
+			warnf("failed to delete backup %s %s: %v", info.backupKind(), info.BackupName, err)
+		} else {
+			deletedBackup, info.BackupName = info.BackupName, ""
+		}
+	}
+
+	// A commit that looks signed but does not verify is worse than an unsigned one, so check it with -verbose
+	if verboseOutput && info.commitOptions().signArg() != "" && info.ResultCommit != "" {
+		signedRef := shortHash(info.ResultCommit)
+		if info.ReplayCount > 0 {
+			signedRef = fmt.Sprintf("%s~%d", signedRef, info.ReplayCount)
+		}
+		if err := gitVerifyCommit(ctx, g, signedRef); err != nil {
+			warnf("the signature of the squashed commit %s does not verify: %v", signedRef, err)
+		} else {
+			progressf("Verified the GPG signature of %s\n", signedRef)
+		}
+	}
+
+	// Summarize what the new commit contains, as a check that nothing was lost
+	if info.ResultCommit != "" && !info.Autofixup {
+		if stats, err := gitCommitStats(ctx, g, info.ResultCommit); err == nil {
+			info.Stats = &stats
+		} else {
+			warnf("could not summarize the squashed commit: %v", err)
+		}
+	}
+
+	if info.JSON {
+		if err := info.printJSON(); err != nil {
+			return failCodef(exitGeneral, "Error encoding JSON output: %v", err)
+		}
+	} else {
+		done := fmt.Sprintf("Successfully squashed the last %d commits.", info.SquashCount)
+		switch {
+		case info.Autofixup:
+			done = fmt.Sprintf("Successfully folded %d fixup commit(s).", info.SquashCount)
+		case info.Reword:
+			done = "Successfully reworded the latest commit."
+		case info.SquashMergesOnly:
+			done = fmt.Sprintf("Successfully folded the last %d commit(s) into the merge commit.", info.SquashCount)
+		case info.AmendBase:
+			done = fmt.Sprintf("Successfully folded the last %d commit(s) into their base commit.", info.SquashCount)
+		case info.Branch != "":
+			done = fmt.Sprintf("Successfully squashed the last %d commits of %s.", info.SquashCount, info.Branch)
+		}
+		progressln(colorize(colorGreen, done))
+		if info.ResultCommit != "" {
+			label := "Squashed commit"
+			switch {
+			case info.Reword:
+				label = "Reworded commit"
+			case info.SquashMergesOnly:
+				label = "Amended merge commit"
+			case info.AmendBase:
+				label = "Amended base commit"
+			case info.Autofixup:
+				label = "New HEAD"
+			}
+			progressf("%s: %s\n", label, colorize(colorYellow, info.ResultCommit))
+		}
+		if info.Stats != nil {
+			progressf("Changes: %s\n", info.Stats)
+		}
+		progressf("Commit date: %s\n", describeDate(info.RecentDate, time.Now()))
+		switch {
+		case info.BackupName != "" && info.BackupPushed:
+			progressf("Backup %s: %s (also on %s)\n", info.backupKind(), colorize(colorCyan, info.BackupName), info.PushBackup)
+		case info.BackupName != "":
+			progressf("Backup %s: %s\n", info.backupKind(), colorize(colorCyan, info.BackupName))
+		case deletedBackup != "":
+			progressf("Deleted backup %s %s; no backup remains, so recovery is only possible via 'git reflog'.\n", info.backupKind(), deletedBackup)
+		}
+	}
+	return runExec(ctx, g, info)
+}
+
+// runExec runs the -exec command through the shell from the repository root once the
+// squash has succeeded. A failing command is reported, but the squash is kept.
+func runExec(ctx context.Context, g GitRunner, info *SquashInfo) error {
+	if info.Exec == "" {
+		return nil
+	}
+	root, err := gitStdout(ctx, g, "rev-parse", "--show-toplevel")
+	if err != nil {
+		return failf("Error: cannot run -exec command: %v", err)
+	}
+
+	progressf("Running %s...\n", info.Exec)
+	cmd := shellCommand(ctx, info.Exec)
+	cmd.Dir = root
+	if env := absoluteGitEnv(); len(env) > 0 {
+		cmd.Env = append(os.Environ(), env...)
+	}
+	cmd.Stdout = statusWriter()
+	cmd.Stderr = os.Stderr
+	if err = cmd.Run(); err != nil {
+		hint := recoveryHint(info.BackupName)
+		if info.Branch != "" && info.BackupName != "" {
+			hint = "\nRecovery: " + info.restoreCommand()
+		}
+		return failf("Error: -exec command %q failed: %v\nThe squash was kept.%s", info.Exec, err, hint)
+	}
+	return nil
+}
+
+// shellCommand builds a command that runs command through the platform shell
+func shellCommand(ctx context.Context, command string) *exec.Cmd {
+	if runtime.GOOS == "windows" {
+		return exec.CommandContext(ctx, "cmd", "/C", command) //nolint:gosec // command is chosen by the user
+	}
+	return exec.CommandContext(ctx, "sh", "-c", command) //nolint:gosec // command is chosen by the user
+}
+
+// messagePlaceholders returns the values of the placeholders that -m and -F may contain
+func messagePlaceholders(ctx context.Context, g GitRunner, info *SquashInfo, oldestRef string) (map[string]string, error) {
+	oldest, err := gitStdout(ctx, g, "rev-parse", "--short", oldestRef)
+	if err != nil {
+		return nil, err
+	}
+	newest, err := gitStdout(ctx, g, "rev-parse", "--short", info.TopRef)
+	if err != nil {
+		return nil, err
+	}
+	date := info.RecentDate
+	if t, pErr := time.Parse(time.RFC3339, date); pErr == nil {
+		date = t.Format(time.DateOnly)
+	}
+	return map[string]string{
+		"count":  strconv.Itoa(info.SquashCount),
+		"date":   date,
+		"oldest": oldest,
+		"newest": newest,
+	}, nil
+}
+
+// pushBackup pushes the new backup to the -push-backup remote so the recovery point survives
+// the loss of the local repository. A failed push only warns, since the local backup remains.
+func pushBackup(ctx context.Context, g GitRunner, info *SquashInfo) {
+	if info.PushBackup == "" || info.BackupName == "" {
+		return
+	}
+	progressf("Pushing backup %s to %s...\n", info.BackupName, info.PushBackup)
+	ref := info.backupRef()
+	if err := runGitCommand(ctx, g, "push", "--quiet", info.PushBackup, ref+":"+ref); err != nil {
+		warnf("failed to push backup %s to %s: %v; continuing with the local backup only.", info.BackupName, info.PushBackup, err)
+		return
+	}
+	info.BackupPushed = true
+}
+
+// recordManifest writes the recovery manifest for the squash about to run
+func recordManifest(ctx context.Context, g GitRunner, info *SquashInfo, stashedRef string) error {
+	if info.Branch != "" {
+		info.PreHead = info.BranchTip
+		m := Manifest{Head: info.BranchTip, Branch: info.Branch, NoCheckout: true, Backup: info.BackupName, BackupTag: info.TagBackup && info.BackupName != "", Created: time.Now().UTC()}
+		if info.BackupPushed {
+			m.BackupRemote = info.PushBackup
+		}
+		return writeManifest(ctx, g, m)
+	}
+	head, err := gitResolveCommit(ctx, g, "HEAD")
+	if err != nil {
+		return err
+	}
+	info.PreHead = head
+	branch, err := gitCurrentBranch(ctx, g)
+	if err != nil {
+		return err
+	}
+	m := Manifest{Head: head, Branch: branch, Backup: info.BackupName, Created: time.Now().UTC()}
+	if info.BackupName != "" {
+		m.BackupTag = info.TagBackup
+	}
+	if info.BackupPushed {
+		m.BackupRemote = info.PushBackup
+	}
+	if stashedRef != "" {
+		m.Stash = stashedRef
+		if m.StashHash, err = gitResolveCommit(ctx, g, stashedRef); err != nil {
+			return err
+		}
+	}
+	return writeManifest(ctx, g, m)
+}
+
+// recoverFromBackup reports a failure that happened after the backup was created.
+// Unless -no-auto-recover is set, it first resets to the backup and reapplies the
+// stash so the repository is left as it was before the squash started.
+// Without a backup it can only point the user at the reflog.
+func recoverFromBackup(ctx context.Context, g GitRunner, info *SquashInfo, stashedRef, msg string) error {
+	// An interrupt cancels ctx, which is what got us here; restoring must still run to completion
+	code := exitGit
+	if ctx.Err() != nil {
+		msg = "Interrupted. " + msg
+		code = exitInterrupted
+	}
+	ctx = context.WithoutCancel(ctx)
+	if info.BackupName == "" || info.NoAutoRecover {
+		if stashedRef != "" {
+			msg += "\nYour uncommitted changes are preserved in " + stashedRef + "; restore them with 'git stash pop' after recovering."
+		}
+		return failCodef(code, "%s%s", msg, recoveryHint(info.BackupName))
+	}
+
+	statusf("Restoring the repository from backup %s %s...\n", info.backupKind(), info.BackupName)
+	if err := runGitCommand(ctx, g, "reset", "--hard", info.BackupName); err != nil {
+		return failCodef(code, "%s\nAutomatic recovery failed: %v%s", msg, err, recoveryHint(info.BackupName))
+	}
+	if stashedRef != "" {
+		if err := runGitCommand(ctx, g, "stash", "pop", stashedRef); err != nil {
+			return failCodef(code, "%s\nRestored HEAD from %s, but reapplying the stash failed (stash preserved as %s): %v", msg, info.BackupName, stashedRef, err)
+		}
+	}
+	return failCodef(code, "%s\nThe repository was restored to %s; nothing was squashed.", msg, info.BackupName)
+}
+
+// restoreStash pops stashedRef back onto the untouched working tree when the squash is
+// abandoned before history was rewritten. It returns a note to append to the error message.
+func restoreStash(ctx context.Context, g GitRunner, stashedRef string) string {
+	if stashedRef == "" {
+		return ""
+	}
+	ctx = context.WithoutCancel(ctx)
+	if err := runGitCommand(ctx, g, "stash", "pop", stashedRef); err != nil {
+		return fmt.Sprintf("\nFailed to restore stashed changes (stash preserved as %s): %v", stashedRef, err)
+	}
+	return "\nStashed changes were restored."
+}
+
+// recoveryHint returns a recovery message based on whether backup branch exists
+func recoveryHint(backupName string) string {
+	if backupName == "" {
+		return "\nRecovery: use 'git reflog' to find the commit hash before the squash, then 'git reset --hard <hash>'"
+	}
+	return "\nRecovery: git reset --hard " + backupName
+}
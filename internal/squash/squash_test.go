@@ -0,0 +1,1039 @@
+package squash
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeGit is a scripted GitRunner. Commands without a scripted result succeed with no output.
+type fakeGit struct {
+	results map[string]fakeResult // Keyed by the space-joined git arguments
+	calls   []string
+	// onCall, if set, sees every command first and may answer it instead of results
+	onCall func(cmd string) (fakeResult, bool)
+}
+
+type fakeResult struct {
+	out string
+	err error
+}
+
+func (f *fakeGit) call(args []string) fakeResult {
+	cmd := strings.Join(args, " ")
+	f.calls = append(f.calls, cmd)
+	if f.onCall != nil {
+		if r, ok := f.onCall(cmd); ok {
+			return r
+		}
+	}
+	return f.results[cmd]
+}
+
+func (f *fakeGit) Stdout(_ context.Context, _ []string, args ...string) (string, error) {
+	r := f.call(args)
+	return r.out, r.err
+}
+
+func (f *fakeGit) Run(_ context.Context, _ []string, args ...string) error {
+	return f.call(args).err
+}
+
+// fakeExitError mimics the exit status carried by *exec.ExitError
+type fakeExitError int
+
+func (e fakeExitError) Error() string { return "exit status " + strconv.Itoa(int(e)) }
+func (e fakeExitError) ExitCode() int { return int(e) }
+
+func quietForTest(t *testing.T) {
+	t.Helper()
+	prev := quietOutput
+	quietOutput = true
+	t.Cleanup(func() { quietOutput = prev })
+}
+
+func TestValidateInput(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   UserInput
+		wantErr string
+	}{
+		{name: "count", input: UserInput{SquashCount: 2}},
+		{name: "to ref", input: UserInput{ToRef: "main"}},
+		{name: "from ref", input: UserInput{FromRef: "abc123"}},
+		{name: "count too small", input: UserInput{SquashCount: 1}, wantErr: "must be at least 2"},
+		{name: "no selection", input: UserInput{}, wantErr: "must be at least 2"},
+		{name: "count with to", input: UserInput{SquashCount: 3, ToRef: "main"}, wantErr: "mutually exclusive"},
+		{name: "since latest tag", input: UserInput{SinceLatestTag: true}},
+		{name: "unpushed", input: UserInput{Unpushed: true}},
+		{name: "unpushed with since tag", input: UserInput{Unpushed: true, SinceLatestTag: true}, wantErr: "mutually exclusive"},
+		{name: "since tag with count", input: UserInput{SinceTag: "v1.0", SquashCount: 2}, wantErr: "mutually exclusive"},
+		{name: "count with from", input: UserInput{SquashCount: 3, FromRef: "abc123"}, wantErr: "mutually exclusive"},
+		{name: "reword", input: UserInput{Reword: true, NewMessage: "new"}},
+		{name: "reword with edit", input: UserInput{Reword: true, Edit: true}},
+		{name: "reword without message", input: UserInput{Reword: true}, wantErr: "-reword needs the new message"},
+		{name: "reword with count", input: UserInput{Reword: true, SquashCount: 2, NewMessage: "new"}, wantErr: "-reword only rewrites"},
+		{name: "reword with concat", input: UserInput{Reword: true, ConcatMessages: true, NewMessage: "new"}, wantErr: "-reword only rewrites"},
+		{name: "amend into base with one commit", input: UserInput{AmendBase: true, SquashCount: 1}},
+		{name: "amend into base without count", input: UserInput{AmendBase: true}, wantErr: "must be at least 1 with -amend-into-base"},
+		{name: "amend into base with onto", input: UserInput{AmendBase: true, SquashCount: 2, OntoRef: "main"}, wantErr: "-amend-into-base amends"},
+		{name: "squash merges only", input: UserInput{SquashMergesOnly: true}},
+		{name: "squash wip", input: UserInput{SquashWIP: true}},
+		{name: "squash wip with merges only", input: UserInput{SquashWIP: true, SquashMergesOnly: true}, wantErr: "mutually exclusive"},
+		{name: "squash wip with onto", input: UserInput{SquashWIP: true, OntoRef: "main"}, wantErr: "-squash-wip amends"},
+		{name: "squash wip with bad pattern", input: UserInput{SquashWIP: true, WIPPattern: "(wip"}, wantErr: "invalid -wip-pattern"},
+		{name: "squash merges only with count", input: UserInput{SquashMergesOnly: true, SquashCount: 2}, wantErr: "mutually exclusive"},
+		{name: "squash merges only with amend into base", input: UserInput{SquashMergesOnly: true, AmendBase: true}, wantErr: "-squash-merges-only amends"},
+		{name: "squash merges only with branch", input: UserInput{SquashMergesOnly: true, Branch: "other"}, wantErr: "-squash-merges-only amends"},
+		{name: "valid author", input: UserInput{SquashCount: 2, Author: "Jane Doe <jane@example.com>"}},
+		{name: "invalid author", input: UserInput{SquashCount: 2, Author: "Jane Doe"}, wantErr: "invalid -author"},
+		{name: "valid trailer", input: UserInput{SquashCount: 2, Trailers: []string{"Refs: #123", "Reviewed-by: Jane <jane@example.com>"}}},
+		{name: "trailer without colon", input: UserInput{SquashCount: 2, Trailers: []string{"Refs #123"}}, wantErr: "invalid -trailer"},
+		{name: "trailer without value", input: UserInput{SquashCount: 2, Trailers: []string{"Refs:  "}}, wantErr: "invalid -trailer"},
+		{name: "trailer key with space", input: UserInput{SquashCount: 2, Trailers: []string{"Reviewed by: Jane"}}, wantErr: "invalid -trailer"},
+		{name: "cleanup verbatim", input: UserInput{SquashCount: 2, Cleanup: "verbatim"}},
+		{name: "cleanup scissors", input: UserInput{SquashCount: 2, Cleanup: "scissors"}},
+		{name: "autofixup", input: UserInput{Autofixup: true, Sign: true}},
+		{name: "autofixup with author", input: UserInput{Autofixup: true, Author: "Jane <jane@example.com>"}, wantErr: "-autofixup replays the commits"},
+		{name: "autofixup with no-verify", input: UserInput{Autofixup: true, NoVerify: true}, wantErr: "-autofixup replays the commits"},
+		{name: "autofixup with cleanup", input: UserInput{Autofixup: true, Cleanup: "strip"}, wantErr: "-autofixup replays the commits"},
+		{name: "autofixup with bad backup prefix", input: UserInput{Autofixup: true, BackupPrefix: "my backup-"}, wantErr: "invalid -backup-prefix"},
+		{name: "since tag", input: UserInput{SinceTag: "v1.0"}},
+		{name: "since tag and latest tag", input: UserInput{SinceTag: "v1.0", SinceLatestTag: true}, wantErr: "-since-latest-tag are mutually exclusive"},
+		{name: "wrap", input: UserInput{SquashCount: 2, Wrap: 72}},
+		{name: "negative wrap", input: UserInput{SquashCount: 2, Wrap: -1}, wantErr: "-wrap must not be negative"},
+		{name: "unknown cleanup", input: UserInput{SquashCount: 2, Cleanup: "comments"}, wantErr: "invalid -cleanup"},
+		{name: "valid date", input: UserInput{SquashCount: 2, Date: "2024-06-01T12:00:00Z"}},
+		{name: "invalid date", input: UserInput{SquashCount: 2, Date: "yesterday"}, wantErr: "invalid -date"},
+		{name: "stash all", input: UserInput{SquashCount: 2, StashUntracked: true, StashAll: true}},
+		{name: "stash all without untracked", input: UserInput{SquashCount: 2, StashAll: true}, wantErr: "-stash-all"},
+		{name: "backup prefix", input: UserInput{SquashCount: 2, BackupPrefix: "team/squash-backup-"}},
+		{name: "backup prefix with space", input: UserInput{SquashCount: 2, BackupPrefix: "my backup-"}, wantErr: "invalid -backup-prefix"},
+		{name: "backup prefix with dots", input: UserInput{SquashCount: 2, BackupPrefix: "team..backup-"}, wantErr: "invalid -backup-prefix"},
+		{name: "backup prefix with leading slash", input: UserInput{SquashCount: 2, BackupPrefix: "/backup-"}, wantErr: "invalid -backup-prefix"},
+		{name: "backup prefix with lock component", input: UserInput{SquashCount: 2, BackupPrefix: "team.lock/backup-"}, wantErr: "invalid -backup-prefix"},
+		{name: "backup prefix with hidden component", input: UserInput{SquashCount: 2, BackupPrefix: "team/.backup-"}, wantErr: "invalid -backup-prefix"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateInput(tt.input)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("expected error containing %q, got %v", tt.wantErr, err)
+			}
+			if code := exitStatus(err); code != exitUsage {
+				t.Errorf("expected exit code %d, got %d", exitUsage, code)
+			}
+		})
+	}
+}
+
+func TestParseAge(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    string
+		wantErr bool
+	}{
+		{in: "7d", want: "168h0m0s"},
+		{in: "2w", want: "336h0m0s"},
+		{in: "36h", want: "36h0m0s"},
+		{in: "-1d", wantErr: true},
+		{in: "soon", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := parseAge(tt.in)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected error for %q, got %v", tt.in, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got.String() != tt.want {
+				t.Errorf("parseAge(%q) = %v, want %s", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExecute_StashApplyFailurePreservesStash(t *testing.T) {
+	quietForTest(t)
+	g := &fakeGit{results: map[string]fakeResult{
+		"stash apply stash@{0}": {err: errors.New("conflict")},
+	}}
+	info := &SquashInfo{
+		UserInput:     UserInput{SquashCount: 2, AllowStash: true, NoBackup: true},
+		Dirty:         true,
+		TopRef:        "HEAD",
+		ResetRef:      "HEAD~2",
+		CommitMessage: "squashed",
+	}
+
+	err := execute(context.Background(), g, info)
+	if err == nil || !strings.Contains(err.Error(), "uncommitted changes are preserved in stash@{0}") {
+		t.Fatalf("expected stash apply failure, got %v", err)
+	}
+	if !strings.Contains(err.Error(), "git reflog") {
+		t.Errorf("expected reflog recovery hint without a backup, got %v", err)
+	}
+	if slices.Contains(g.calls, "stash drop stash@{0}") {
+		t.Errorf("stash must not be dropped after a failed apply; calls: %v", g.calls)
+	}
+}
+
+func TestExecute_CommitFailureMentionsBackup(t *testing.T) {
+	quietForTest(t)
+	g := &fakeGit{results: map[string]fakeResult{
+		"show-ref --verify --quiet refs/heads/locsquash/backup-20240101-000000": {err: fakeExitError(1)},
+		"commit -m squashed": {err: errors.New("hook failed")},
+	}}
+	info := &SquashInfo{
+		UserInput:     UserInput{SquashCount: 2, NoAutoRecover: true},
+		TopRef:        "HEAD",
+		ResetRef:      "HEAD~2",
+		BackupName:    "locsquash/backup-20240101-000000",
+		CommitMessage: "squashed",
+	}
+
+	err := execute(context.Background(), g, info)
+	if err == nil || !strings.Contains(err.Error(), "Failed to create squashed commit") {
+		t.Fatalf("expected commit failure, got %v", err)
+	}
+	if !strings.Contains(err.Error(), "git reset --hard locsquash/backup-20240101-000000") {
+		t.Errorf("expected backup recovery hint, got %v", err)
+	}
+}
+
+func TestExecute_AutoRecoverRestoresBackupAndStash(t *testing.T) {
+	quietForTest(t)
+	g := &fakeGit{results: map[string]fakeResult{
+		"show-ref --verify --quiet refs/heads/locsquash/backup-20240101-000000": {err: fakeExitError(1)},
+		"commit -m squashed": {err: errors.New("hook failed")},
+	}}
+	info := &SquashInfo{
+		UserInput:     UserInput{SquashCount: 2, AllowStash: true},
+		Dirty:         true,
+		TopRef:        "HEAD",
+		ResetRef:      "HEAD~2",
+		BackupName:    "locsquash/backup-20240101-000000",
+		CommitMessage: "squashed",
+	}
+
+	err := execute(context.Background(), g, info)
+	if err == nil || !strings.Contains(err.Error(), "restored to locsquash/backup-20240101-000000") {
+		t.Fatalf("expected recovery message, got %v", err)
+	}
+	resetAt := slices.Index(g.calls, "reset --hard locsquash/backup-20240101-000000")
+	popAt := slices.Index(g.calls, "stash pop stash@{0}")
+	if resetAt < 0 || popAt < resetAt {
+		t.Errorf("expected reset to the backup followed by stash pop; calls: %v", g.calls)
+	}
+}
+
+func TestExecute_BackupFailureRestoresStash(t *testing.T) {
+	quietForTest(t)
+	// Every candidate backup name already exists, so creating the backup fails
+	g := &fakeGit{}
+	info := &SquashInfo{
+		UserInput:  UserInput{SquashCount: 2, AllowStash: true},
+		Dirty:      true,
+		TopRef:     "HEAD",
+		ResetRef:   "HEAD~2",
+		BackupName: "locsquash/backup-20240101-000000",
+	}
+
+	err := execute(context.Background(), g, info)
+	if err == nil || !strings.Contains(err.Error(), "Stashed changes were restored") {
+		t.Fatalf("expected backup failure with restored stash, got %v", err)
+	}
+	if !slices.Contains(g.calls, "stash pop stash@{0}") {
+		t.Errorf("expected stash pop; calls: %v", g.calls)
+	}
+	if slices.Contains(g.calls, "reset --soft HEAD~2") {
+		t.Errorf("history must not be rewritten without a backup; calls: %v", g.calls)
+	}
+}
+
+// scriptedRepo returns fakeGit results for a clean three-commit repository on branch work
+func scriptedRepo() map[string]fakeResult {
+	return map[string]fakeResult{
+		"rev-parse --is-inside-work-tree":                                 {out: "true"},
+		"rev-list --count HEAD":                                           {out: "3"},
+		"symbolic-ref -q HEAD":                                            {out: "refs/heads/work"},
+		"log -1 --format=%B HEAD~1":                                       {out: "b"},
+		"diff --quiet HEAD~2 HEAD":                                        {err: fakeExitError(1)},
+		"log --first-parent --format=%h%x00%cr%x00%an%x00%s HEAD~2..HEAD": {out: "ccccccc\x00now\x00Test\x00c\nbbbbbbb\x00now\x00Test\x00b"},
+	}
+}
+
+func TestRun_DeclinedPromptLeavesTreeUntouched(t *testing.T) {
+	quietForTest(t)
+	prev := confirm
+	confirm = func(context.Context, string) (bool, error) { return false, nil }
+	t.Cleanup(func() { confirm = prev })
+
+	g := &fakeGit{results: scriptedRepo()}
+	g.results["status --porcelain"] = fakeResult{out: "?? dirty.txt"}
+	info := &SquashInfo{UserInput: UserInput{SquashCount: 2, AllowStash: true, StashUntracked: true}}
+
+	if err := Run(context.Background(), g, info); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, call := range g.calls {
+		if (strings.HasPrefix(call, "stash") && !strings.HasPrefix(call, "stash list")) || strings.HasPrefix(call, "reset") || strings.HasPrefix(call, "branch") {
+			t.Errorf("declined prompt must not touch the repository, got call %q", call)
+		}
+	}
+}
+
+func TestRun_WarnThresholdAsksUnlessYes(t *testing.T) {
+	quietForTest(t)
+	prev := confirm
+	t.Cleanup(func() { confirm = prev })
+
+	tests := []struct {
+		name      string
+		input     UserInput
+		wantAsked bool
+	}{
+		{name: "below threshold with yes-if-clean", input: UserInput{SquashCount: 2, YesIfClean: true, WarnThreshold: 2}},
+		{name: "above threshold with yes-if-clean", input: UserInput{SquashCount: 2, YesIfClean: true, WarnThreshold: 1}, wantAsked: true},
+		{name: "above threshold with yes", input: UserInput{SquashCount: 2, Yes: true, WarnThreshold: 1}},
+		{name: "disabled", input: UserInput{SquashCount: 2, YesIfClean: true}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			asked := false
+			confirm = func(context.Context, string) (bool, error) { asked = true; return false, nil }
+			g := &fakeGit{results: scriptedRepo()}
+			g.results["log -1 --format=%s HEAD~1"] = fakeResult{out: "b"}
+			// Runs that are not stopped by the prompt go ahead and need a free backup name
+			g.onCall = func(cmd string) (fakeResult, bool) {
+				if strings.HasPrefix(cmd, "show-ref --verify --quiet refs/heads/locsquash/backup-") {
+					return fakeResult{err: fakeExitError(1)}, true
+				}
+				return fakeResult{}, false
+			}
+
+			if err := Run(context.Background(), g, &SquashInfo{UserInput: tt.input}); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if asked != tt.wantAsked {
+				t.Errorf("prompted = %v, want %v", asked, tt.wantAsked)
+			}
+		})
+	}
+}
+
+func TestRun_PreviewShowsDiffStatBeforeSinglePrompt(t *testing.T) {
+	quietForTest(t)
+	var prompts []string
+	prev := confirm
+	confirm = func(_ context.Context, prompt string) (bool, error) {
+		prompts = append(prompts, prompt)
+		return false, nil
+	}
+	t.Cleanup(func() { confirm = prev })
+
+	g := &fakeGit{results: scriptedRepo()}
+	g.results["diff --stat HEAD~2 HEAD"] = fakeResult{out: " b | 1 +\n 1 file changed, 1 insertion(+)"}
+	info := &SquashInfo{UserInput: UserInput{SquashCount: 2, Preview: true}}
+
+	if err := Run(context.Background(), g, info); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !slices.Contains(g.calls, "diff --stat HEAD~2 HEAD") {
+		t.Errorf("expected -preview to read the diffstat, got calls %q", g.calls)
+	}
+	if len(prompts) != 1 {
+		t.Errorf("expected exactly one prompt, got %q", prompts)
+	}
+}
+
+func TestRun_EmptySquashRejectedBeforeRewrite(t *testing.T) {
+	quietForTest(t)
+	g := &fakeGit{results: scriptedRepo()}
+	g.results["diff --quiet HEAD~2 HEAD"] = fakeResult{} // no net changes
+	info := &SquashInfo{UserInput: UserInput{SquashCount: 2, Yes: true}}
+
+	err := Run(context.Background(), g, info)
+	if err == nil || !strings.Contains(err.Error(), "no net changes") {
+		t.Fatalf("expected no net changes error, got %v", err)
+	}
+	for _, call := range g.calls {
+		if strings.HasPrefix(call, "reset") || strings.HasPrefix(call, "branch") || strings.HasPrefix(call, "commit") {
+			t.Errorf("empty squash must be rejected before rewriting, got call %q", call)
+		}
+	}
+}
+
+func TestRun_InterruptDuringCommitRestoresBackup(t *testing.T) {
+	quietForTest(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	g := &fakeGit{results: scriptedRepo()}
+	// Ctrl-C arrives while git commit runs (say, a slow hook), after the soft reset
+	g.onCall = func(cmd string) (fakeResult, bool) {
+		switch {
+		case strings.HasPrefix(cmd, "show-ref --verify --quiet refs/heads/locsquash/backup-"):
+			return fakeResult{err: fakeExitError(1)}, true
+		case strings.HasPrefix(cmd, "commit "):
+			cancel()
+			return fakeResult{err: errors.New("signal: interrupt")}, true
+		}
+		return fakeResult{}, false
+	}
+	info := &SquashInfo{UserInput: UserInput{SquashCount: 2, Yes: true}}
+
+	err := Run(ctx, g, info)
+	if exitStatus(err) != exitInterrupted || !strings.Contains(err.Error(), "Interrupted.") {
+		t.Fatalf("expected an interrupted squash to exit with %d, got %v", exitInterrupted, err)
+	}
+	if last := g.calls[len(g.calls)-1]; last != "reset --hard "+info.BackupName {
+		t.Errorf("expected the backup to be restored last, got calls %q", g.calls)
+	}
+}
+
+func TestRun_InvalidBackupNameRejectedBeforeRewrite(t *testing.T) {
+	quietForTest(t)
+	for _, tag := range []bool{false, true} {
+		g := &fakeGit{results: scriptedRepo()}
+		var checked string
+		g.onCall = func(cmd string) (fakeResult, bool) {
+			if strings.HasPrefix(cmd, "check-ref-format ") {
+				checked = cmd
+				return fakeResult{err: fakeExitError(128)}, true
+			}
+			return fakeResult{}, false
+		}
+		info := &SquashInfo{UserInput: UserInput{SquashCount: 2, Yes: true, BackupPrefix: "odd/", TagBackup: tag}}
+
+		err := Run(t.Context(), g, info)
+		if exitStatus(err) != exitUsage || !strings.Contains(err.Error(), `backup name "odd/`) {
+			t.Fatalf("expected a usage error naming the backup, got %v", err)
+		}
+		want := "check-ref-format --branch odd/"
+		if tag {
+			want = "check-ref-format refs/tags/odd/"
+		}
+		if !strings.HasPrefix(checked, want) {
+			t.Errorf("expected %q..., got %q", want, checked)
+		}
+		for _, call := range g.calls {
+			if strings.HasPrefix(call, "branch ") || strings.HasPrefix(call, "tag ") || strings.HasPrefix(call, "reset ") {
+				t.Errorf("expected nothing to change, got %q", call)
+			}
+		}
+	}
+}
+
+func TestRun_InterruptBeforeRewriteChangesNothing(t *testing.T) {
+	quietForTest(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	g := &fakeGit{results: scriptedRepo()}
+	g.onCall = func(cmd string) (fakeResult, bool) {
+		if cmd == "status --porcelain" {
+			cancel()
+			return fakeResult{err: errors.New("signal: interrupt")}, true
+		}
+		return fakeResult{}, false
+	}
+	info := &SquashInfo{UserInput: UserInput{SquashCount: 2, Yes: true}}
+
+	err := Run(ctx, g, info)
+	if exitStatus(err) != exitInterrupted || !strings.Contains(err.Error(), "nothing was changed") {
+		t.Fatalf("expected a clean interrupted exit, got %v", err)
+	}
+	for _, call := range g.calls {
+		if strings.HasPrefix(call, "reset") || strings.HasPrefix(call, "branch") || strings.HasPrefix(call, "commit") {
+			t.Errorf("an interrupt before the rewrite must not touch the repository, got call %q", call)
+		}
+	}
+}
+
+func TestCreateBackupRef_SkipsExistingNames(t *testing.T) {
+	// show-ref finds the base name but not the -2 suffix, so the suffixed name must be used
+	g := &fakeGit{results: map[string]fakeResult{
+		"show-ref --verify --quiet refs/heads/locsquash/backup-x-2": {err: fakeExitError(1)},
+	}}
+	name, err := createBackupRef(context.Background(), g, "locsquash/backup-x", "HEAD", false)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if name != "locsquash/backup-x-2" {
+		t.Errorf("expected locsquash/backup-x-2, got %s", name)
+	}
+	if !slices.Contains(g.calls, "branch locsquash/backup-x-2 HEAD") {
+		t.Errorf("expected branch to be created; calls: %v", g.calls)
+	}
+}
+
+// chdirToNewRepo creates a repository with one commit and makes it the working directory
+func chdirToNewRepo(t testing.TB) {
+	t.Helper()
+	t.Chdir(t.TempDir())
+	for _, args := range [][]string{
+		{"init", "-q"},
+		{"-c", "user.name=Test", "-c", "user.email=test@test.local", "commit", "-q", "--allow-empty", "-m", "init"},
+	} {
+		if out, err := exec.CommandContext(t.Context(), "git", args...).CombinedOutput(); err != nil {
+			t.Fatalf("git %v failed: %v\n%s", args, err, out)
+		}
+	}
+}
+
+func TestNewRepoGitRunsFromTheRepositoryRoot(t *testing.T) {
+	chdirToNewRepo(t)
+	root, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sub := filepath.Join(root, "sub", "dir")
+	if err = os.MkdirAll(sub, 0o750); err != nil {
+		t.Fatal(err)
+	}
+	want, err := gitStdout(t.Context(), realGit{}, "rev-parse", "--show-toplevel")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Started elsewhere, a runner for a subdirectory still targets its repository
+	t.Chdir(t.TempDir())
+	if g := newRepoGit(t.Context(), sub); g.dir != want {
+		t.Errorf("newRepoGit(%q).dir = %q, want the repository root %q", sub, g.dir, want)
+	}
+	if _, err = gitStdout(t.Context(), newRepoGit(t.Context(), root), "rev-parse", "--verify", "HEAD"); err != nil {
+		t.Errorf("expected git to run in the repository given by dir: %v", err)
+	}
+	// Outside a repository the runner stays in dir, so the squash reports the error
+	if g := newRepoGit(t.Context(), ""); g.dir != "" {
+		t.Errorf("expected the current directory outside a repository, got %q", g.dir)
+	}
+
+	// A relative GIT_DIR is made absolute for the runner's commands only
+	t.Chdir(root)
+	t.Setenv("GIT_DIR", ".git")
+	g := newRepoGit(t.Context(), sub)
+	if got := os.Getenv("GIT_DIR"); got != ".git" {
+		t.Errorf("expected the process environment to be left alone, GIT_DIR = %q", got)
+	}
+	if want := "GIT_DIR=" + filepath.Join(root, ".git"); !slices.Contains(g.env, want) {
+		t.Errorf("newRepoGit env = %q, want it to contain %q", g.env, want)
+	}
+	if _, err = gitStdout(t.Context(), g, "rev-parse", "--verify", "HEAD"); err != nil {
+		t.Errorf("expected git to find the repository through the absolute GIT_DIR: %v", err)
+	}
+}
+
+func TestUniqueBackupName(t *testing.T) {
+	chdirToNewRepo(t)
+	g := realGit{}
+	for _, name := range []string{"locsquash/backup-x", "locsquash/backup-x-2", "locsquash/backup-y"} {
+		if _, err := gitStdout(t.Context(), g, "branch", name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := gitStdout(t.Context(), g, "tag", "locsquash/backup-z"); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		namespace string
+		base      string
+		want      string
+	}{
+		{namespace: "refs/heads/", base: "locsquash/backup-x", want: "locsquash/backup-x-3"},
+		{namespace: "refs/heads/", base: "locsquash/backup-y", want: "locsquash/backup-y-2"},
+		{namespace: "refs/heads/", base: "locsquash/backup-new", want: "locsquash/backup-new"},
+		{namespace: "refs/heads/", base: "locsquash/backup-z", want: "locsquash/backup-z"},
+		{namespace: "refs/tags/", base: "locsquash/backup-z", want: "locsquash/backup-z-2"},
+	}
+	for _, tt := range tests {
+		got, err := uniqueBackupName(t.Context(), g, tt.namespace, tt.base)
+		if err != nil {
+			t.Fatalf("uniqueBackupName(%s%s): %v", tt.namespace, tt.base, err)
+		}
+		if got != tt.want {
+			t.Errorf("uniqueBackupName(%s%s) = %s, want %s", tt.namespace, tt.base, got, tt.want)
+		}
+	}
+}
+
+func TestUniqueBackupName_GivesUpAfterCap(t *testing.T) {
+	// Unscripted show-ref calls succeed, so every candidate appears taken
+	g := &fakeGit{}
+	if _, err := uniqueBackupName(context.Background(), g, "refs/heads/", "locsquash/backup-x"); err == nil {
+		t.Fatal("expected an error once every suffix is taken")
+	}
+	if len(g.calls) != maxBackupSuffix {
+		t.Errorf("expected %d lookups, got %d", maxBackupSuffix, len(g.calls))
+	}
+}
+
+func TestGitHasChangesBetween_ExitCodes(t *testing.T) {
+	tests := []struct {
+		name    string
+		err     error
+		want    bool
+		wantErr bool
+	}{
+		{name: "no changes", err: nil, want: false},
+		{name: "changes", err: fakeExitError(1), want: true},
+		{name: "failure", err: fakeExitError(128), wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := &fakeGit{results: map[string]fakeResult{"diff --quiet A B": {err: tt.err}}}
+			got, err := gitHasChangesBetween(context.Background(), g, "A", "B")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCollectCommits_ParsesSingleLogCall(t *testing.T) {
+	out := strings.Join([]string{
+		"aaaaaaa\x003 hours ago\x00Ada Lovelace\x00fix: handle \"quoted\" paths\ttabs and \\ slashes",
+		"bbbbbbb\x002 days ago\x00José Müller\x00feat: 日本語 %s %x00 and | pipes",
+		"",
+		"ccccccc\x001 week ago\x00\x00",
+	}, "\n")
+	g := &fakeGit{results: map[string]fakeResult{
+		"log --first-parent --format=%h%x00%cr%x00%an%x00%s HEAD~3..HEAD": {out: out},
+	}}
+
+	got, err := collectCommits(context.Background(), g, "HEAD~3", "HEAD", false)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := []CommitInfo{
+		{Hash: "aaaaaaa", Date: "3 hours ago", Author: "Ada Lovelace", Subject: "fix: handle \"quoted\" paths\ttabs and \\ slashes"},
+		{Hash: "bbbbbbb", Date: "2 days ago", Author: "José Müller", Subject: "feat: 日本語 %s %x00 and | pipes"},
+		{Hash: "ccccccc", Date: "1 week ago", Author: "", Subject: ""},
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+	if len(g.calls) != 1 {
+		t.Errorf("expected a single git call, got %q", g.calls)
+	}
+}
+
+func TestCollectCommits_WithStats(t *testing.T) {
+	// Real git output: a blank line before each shortstat, and none at all for the empty commit
+	out := "\x01aaaaaaa\x00now\x00Ada\x00big change\n\n 3 files changed, 120 insertions(+), 7 deletions(-)\n" +
+		"\x01bbbbbbb\x00now\x00Ada\x00empty\n" +
+		"\x01ccccccc\x00now\x00Ada\x00removal\n\n 1 file changed, 4 deletions(-)"
+	g := &fakeGit{results: map[string]fakeResult{
+		"log --first-parent --shortstat --format=%x01%h%x00%cr%x00%an%x00%s HEAD~3..HEAD": {out: out},
+	}}
+
+	got, err := collectCommits(context.Background(), g, "HEAD~3", "HEAD", true)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := []CommitInfo{
+		{Hash: "aaaaaaa", Date: "now", Author: "Ada", Subject: "big change", Additions: 120, Deletions: 7},
+		{Hash: "bbbbbbb", Date: "now", Author: "Ada", Subject: "empty"},
+		{Hash: "ccccccc", Date: "now", Author: "Ada", Subject: "removal", Deletions: 4},
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+	if len(g.calls) != 1 {
+		t.Errorf("expected a single git call, got %q", g.calls)
+	}
+}
+
+func TestCachingGit_ReusesReadsUntilMutation(t *testing.T) {
+	fake := &fakeGit{results: map[string]fakeResult{
+		"rev-parse -q --verify MERGE_HEAD": {err: fakeExitError(1)},
+	}}
+	g := newCachingGit(fake)
+	ctx := context.Background()
+
+	for range 2 {
+		_, _ = gitStdout(ctx, g, "log", "-1", "--format=%B", "HEAD")
+		_, _ = gitStdout(ctx, g, "rev-parse", "-q", "--verify", "MERGE_HEAD")
+	}
+	if len(fake.calls) != 2 {
+		t.Fatalf("expected repeated reads to be cached, got calls %q", fake.calls)
+	}
+	if _, err := gitStdout(ctx, g, "rev-parse", "-q", "--verify", "MERGE_HEAD"); exitCode(err) != 1 {
+		t.Errorf("expected the cached failure to be returned, got %v", err)
+	}
+
+	// status is not cached, but neither it nor another read invalidates the cache
+	_, _ = gitStdout(ctx, g, "status", "--porcelain")
+	_, _ = gitStdout(ctx, g, "status", "--porcelain")
+	_, _ = gitStdout(ctx, g, "stash", "list")
+	_ = runGitCommand(ctx, g, "show-ref", "--verify", "--quiet", "refs/heads/main")
+	_, _ = gitStdout(ctx, g, "log", "-1", "--format=%B", "HEAD")
+	if len(fake.calls) != 6 {
+		t.Fatalf("expected reads to keep the cache, got calls %q", fake.calls)
+	}
+
+	// A command that may move refs invalidates it
+	_ = runGitCommand(ctx, g, "reset", "--soft", "HEAD~2")
+	_, _ = gitStdout(ctx, g, "log", "-1", "--format=%B", "HEAD")
+	if len(fake.calls) != 8 {
+		t.Errorf("expected reads after a mutation to reach git, got calls %q", fake.calls)
+	}
+}
+
+// BenchmarkRunDryRun measures a dry run over 100 commits with and without the read cache
+func BenchmarkRunDryRun(b *testing.B) {
+	chdirToNewRepo(b)
+	for i := range 100 {
+		msg := "commit " + strconv.Itoa(i)
+		if out, err := exec.CommandContext(b.Context(), "git", "-c", "user.name=Test", "-c", "user.email=test@test.local",
+			"commit", "-q", "--allow-empty", "-m", msg).CombinedOutput(); err != nil {
+			b.Fatalf("git commit failed: %v\n%s", err, out)
+		}
+	}
+	devNull, err := os.Open(os.DevNull)
+	if err != nil {
+		b.Fatal(err)
+	}
+	prev := os.Stdout
+	os.Stdout = devNull
+	b.Cleanup(func() {
+		os.Stdout = prev
+		_ = devNull.Close()
+	})
+
+	input := UserInput{SquashCount: 50, DryRun: true, Force: true, AllowEmpty: true, KeepAuthor: true, ConcatMessages: true}
+	for _, bc := range []struct {
+		name string
+		git  func() GitRunner
+	}{
+		{name: "uncached", git: func() GitRunner { return realGit{} }},
+		{name: "cached", git: func() GitRunner { return newCachingGit(realGit{}) }},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			for b.Loop() {
+				if rErr := Run(b.Context(), bc.git(), &SquashInfo{UserInput: input}); rErr != nil {
+					b.Fatal(rErr)
+				}
+			}
+		})
+	}
+}
+
+func TestPrintJSONReturnsWriteError(t *testing.T) {
+	closed, err := os.Create(filepath.Join(t.TempDir(), "stdout"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = closed.Close()
+	prev := os.Stdout
+	os.Stdout = closed
+	t.Cleanup(func() { os.Stdout = prev })
+
+	if err = (SquashInfo{UserInput: UserInput{JSON: true, DryRun: true}}).printDryRun(); err == nil {
+		t.Error("expected the write error to be returned")
+	}
+}
+
+func TestParseStatusPaths(t *testing.T) {
+	out := strings.Join([]string{
+		"1 .M N... 100644 100644 100644 3f3f3f3 3f3f3f3 src/main.go",
+		"1 A. N... 000000 100644 100644 0000000 4e4e4e4 dir with spaces/new file.txt",
+		"2 R. N... 100644 100644 100644 5a5a5a5 5a5a5a5 R100 renamed.txt",
+		"original.txt",
+		"u UU N... 100644 100644 100644 100644 1111111 2222222 3333333 conflict.txt",
+		"? untracked -> odd.txt",
+		"",
+	}, "\x00")
+
+	got := parseStatusPaths(out)
+	want := []string{"src/main.go", "dir with spaces/new file.txt", "renamed.txt", "conflict.txt", "untracked -> odd.txt"}
+	if !slices.Equal(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestParseShortStat(t *testing.T) {
+	tests := []struct {
+		line string
+		want DiffStats
+	}{
+		{" 3 files changed, 10 insertions(+), 2 deletions(-)", DiffStats{FilesChanged: 3, Insertions: 10, Deletions: 2}},
+		{" 1 file changed, 1 insertion(+)", DiffStats{FilesChanged: 1, Insertions: 1}},
+		{" 2 files changed, 4 deletions(-)", DiffStats{FilesChanged: 2, Deletions: 4}},
+		{"", DiffStats{}},
+	}
+	for _, tt := range tests {
+		if got := parseShortStat(tt.line); got != tt.want {
+			t.Errorf("parseShortStat(%q) = %+v, want %+v", tt.line, got, tt.want)
+		}
+	}
+	if got := (DiffStats{FilesChanged: 1, Insertions: 2, Deletions: 1}).String(); got != "1 file changed, 2 insertions(+), 1 deletion(-)" {
+		t.Errorf("unexpected String(): %q", got)
+	}
+}
+
+func TestMissingStatusPaths(t *testing.T) {
+	g := &fakeGit{results: map[string]fakeResult{
+		"status --porcelain=v2 -z": {out: "1 .M N... 100644 100644 100644 aaaaaaa aaaaaaa a.txt\x00? b.txt\x00"},
+	}}
+	missing, err := missingStatusPaths(context.Background(), g, []string{"a.txt", "b.txt", "c.txt"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !slices.Equal(missing, []string{"c.txt"}) {
+		t.Errorf("expected only c.txt to be missing, got %q", missing)
+	}
+}
+
+func TestGitLeftoverAutoStashes(t *testing.T) {
+	g := &fakeGit{results: map[string]fakeResult{
+		"stash list --format=%gd%x00%gs": {out: strings.Join([]string{
+			"stash@{0}\x00On work: my locsquash auto-stash notes",
+			"stash@{1}\x00On work: locsquash auto-stash",
+			"stash@{2}\x00WIP on work: 1234567 locsquash auto-stash",
+			"stash@{3}\x00On feature: locsquash recover-last",
+			"stash@{4}\x00On main: locsquash auto-stash",
+		}, "\n")},
+	}}
+	got, err := gitLeftoverAutoStashes(context.Background(), g)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want := []string{"stash@{1}", "stash@{4}"}; !slices.Equal(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestGitCurrentBranch(t *testing.T) {
+	g := &fakeGit{results: map[string]fakeResult{
+		"symbolic-ref -q HEAD": {out: "refs/heads/feature/trunk"},
+	}}
+	if branch, err := gitCurrentBranch(context.Background(), g); err != nil || branch != "feature/trunk" {
+		t.Errorf("expected feature/trunk, got %q (err %v)", branch, err)
+	}
+
+	g.results["symbolic-ref -q HEAD"] = fakeResult{err: fakeExitError(1)}
+	if branch, err := gitCurrentBranch(context.Background(), g); err != nil || branch != "HEAD" {
+		t.Errorf("expected HEAD when detached, got %q (err %v)", branch, err)
+	}
+
+	g.results["symbolic-ref -q HEAD"] = fakeResult{err: fakeExitError(128)}
+	if _, err := gitCurrentBranch(context.Background(), g); err == nil {
+		t.Error("expected an error when git fails")
+	}
+}
+
+func TestDescribeDate(t *testing.T) {
+	now := time.Date(2024, 6, 1, 15, 0, 0, 0, time.UTC)
+	tests := []struct {
+		iso  string
+		want string
+	}{
+		{"2024-06-01T14:59:30Z", "2024-06-01T14:59:30Z (just now)"},
+		{"2024-06-01T14:59:00Z", "2024-06-01T14:59:00Z (1 minute ago)"},
+		{"2024-06-01T12:00:00Z", "2024-06-01T12:00:00Z (3 hours ago)"},
+		{"2024-06-01T14:00:00+02:00", "2024-06-01T14:00:00+02:00 (3 hours ago)"},
+		{"2024-05-30T15:00:00Z", "2024-05-30T15:00:00Z (2 days ago)"},
+		{"2024-05-18T15:00:00Z", "2024-05-18T15:00:00Z (2 weeks ago)"},
+		{"2024-02-01T15:00:00Z", "2024-02-01T15:00:00Z (4 months ago)"},
+		{"2021-06-01T15:00:00Z", "2021-06-01T15:00:00Z (3 years ago)"},
+		{"2024-06-02T15:00:00Z", "2024-06-02T15:00:00Z (in the future)"},
+		{"yesterday", "yesterday"},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := describeDate(tt.iso, now); got != tt.want {
+			t.Errorf("describeDate(%q) = %q, want %q", tt.iso, got, tt.want)
+		}
+	}
+}
+
+func TestSanitizeForTerminal(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"plain subject", "plain subject"},
+		{"red \x1b[31mtext\x1b[0m", `red \x1b[31mtext\x1b[0m`},
+		{"tab\there", `tab\there`},
+		{"carriage\rreturn", `carriage\rreturn`},
+		{"bidi \u202eevil", `bidi \u202eevil`},
+		{"emoji 🚀 and 日本語", "emoji 🚀 and 日本語"},
+	}
+	for _, tt := range tests {
+		if got := sanitizeForTerminal(tt.in); got != tt.want {
+			t.Errorf("sanitizeForTerminal(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestPadRight_WideCharacters(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"ab", "ab    "},
+		{"日本", "日本  "},
+		{"🚀x", "🚀x   "},
+		{"toolong", "toolong"},
+	}
+	for _, tt := range tests {
+		if got := padRight(tt.in, 6); got != tt.want {
+			t.Errorf("padRight(%q, 6) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestReadAnswer(t *testing.T) {
+	line, err := readAnswer(t.Context(), strings.NewReader("yes\nmore"), 0)
+	if err != nil || line != "yes\n" {
+		t.Errorf("expected the first line without a timeout, got %q (err %v)", line, err)
+	}
+
+	line, err = readAnswer(t.Context(), strings.NewReader("y\n"), time.Minute)
+	if err != nil || line != "y\n" {
+		t.Errorf("expected the answer before the timeout, got %q (err %v)", line, err)
+	}
+
+	// Nothing is ever written, like a prompt nobody answers
+	r, w := io.Pipe()
+	t.Cleanup(func() { _ = w.Close() })
+	start := time.Now()
+	if _, err = readAnswer(t.Context(), r, 20*time.Millisecond); !errors.Is(err, errPromptTimeout) {
+		t.Errorf("expected errPromptTimeout, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("readAnswer waited %s despite the timeout", elapsed)
+	}
+	// An interrupt cancels the context and ends the wait without an answer
+	ctx, cancel := context.WithCancel(t.Context())
+	cancel()
+	if _, err = readAnswer(ctx, r, 0); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+}
+
+func TestParseGitVersion(t *testing.T) {
+	tests := []struct {
+		out     string
+		want    gitVersion
+		wantErr bool
+	}{
+		{out: "git version 2.43.0", want: gitVersion{2, 43, 0}},
+		{out: "git version 2.39.3 (Apple Git-146)", want: gitVersion{2, 39, 3}},
+		{out: "git version 2.45.1.windows.1", want: gitVersion{2, 45, 1}},
+		{out: "git version 2.31.0.rc1\n", want: gitVersion{2, 31, 0}},
+		{out: "git version 3.0", want: gitVersion{3, 0, 0}},
+		{out: "git version 1.8.3.1", want: gitVersion{1, 8, 3}},
+		{out: "hub version 2.14.2", wantErr: true},
+		{out: "", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := parseGitVersion(tt.out)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("parseGitVersion(%q) = %v, %v; want %v, error %v", tt.out, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestCheckGitVersion(t *testing.T) {
+	quietForTest(t)
+	g := &fakeGit{results: map[string]fakeResult{"--version": {out: "git version 2.19.2"}}}
+	err := checkGitVersion(t.Context(), g)
+	if err == nil || !strings.Contains(err.Error(), "git 2.19.2 is too old") || exitStatus(err) != exitGit {
+		t.Errorf("expected an upgrade error, got %v", err)
+	}
+
+	g.results["--version"] = fakeResult{out: "git version 2.20.0"}
+	if err = checkGitVersion(t.Context(), g); err != nil {
+		t.Errorf("expected the minimum version to be accepted, got %v", err)
+	}
+}
+
+func TestGitCountWIP(t *testing.T) {
+	wip := regexp.MustCompile(DefaultWIPPattern)
+	page := func(subjects ...string) fakeResult { return fakeResult{out: strings.Join(subjects, "\n")} }
+	full := make([]string, wipBatch)
+	for i := range full {
+		full[i] = "wip " + strconv.Itoa(i)
+	}
+	tests := []struct {
+		name  string
+		pages []fakeResult
+		want  int
+	}{
+		{name: "head not wip", pages: []fakeResult{page("feat: real", "wip")}, want: 0},
+		{name: "mixed", pages: []fakeResult{page("WIP: more", "tmp", "fixup! feat", "feat: real", "wip older")}, want: 3},
+		{name: "case sensitive", pages: []fakeResult{page("Wip", "wip")}, want: 0},
+		{name: "all wip", pages: []fakeResult{page("wip", "tmp")}, want: -1},
+		{name: "past one batch", pages: []fakeResult{page(full...), page("wip", "feat: real")}, want: wipBatch + 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := &fakeGit{results: map[string]fakeResult{}}
+			for i, p := range tt.pages {
+				g.results["log --first-parent --format=%s --skip="+strconv.Itoa(i*wipBatch)+" -"+strconv.Itoa(wipBatch)+" HEAD"] = p
+			}
+			got, err := gitCountWIP(t.Context(), g, "HEAD", wip)
+			if err != nil || got != tt.want {
+				t.Errorf("gitCountWIP() = %d, %v; want %d", got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestHistoryLine(t *testing.T) {
+	at := time.Date(2024, 6, 1, 14, 0, 0, 0, time.FixedZone("CEST", 2*60*60))
+	got := historyLine(at, 3, "locsquash/backup-x", "aaa", "bbb")
+	if want := "2024-06-01T12:00:00Z\t3\tlocsquash/backup-x\taaa\tbbb\n"; got != want {
+		t.Errorf("historyLine() = %q, want %q", got, want)
+	}
+	if got = historyLine(at, 2, "", "aaa", "bbb"); !strings.Contains(got, "\t2\t-\taaa\t") {
+		t.Errorf("expected a missing backup to be written as -, got %q", got)
+	}
+}
+
+func TestParseHistory(t *testing.T) {
+	data := "2024-06-01T12:00:00Z\t3\tlocsquash/backup-x\taaa\tbbb\n" +
+		"garbage\n" +
+		"2024-06-02T12:00:00Z\t2\t-\tbbb\tccc\n" +
+		"2024-06-03T12:00:00Z\tx\t-\tccc\t"
+	got := parseHistory(data)
+	if len(got) != 2 {
+		t.Fatalf("expected the two well-formed entries, got %+v", got)
+	}
+	if got[0].Count != 3 || got[0].Backup != "locsquash/backup-x" || got[0].Before != "aaa" || got[0].After != "bbb" ||
+		!got[0].Time.Equal(time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)) {
+		t.Errorf("unexpected first entry %+v", got[0])
+	}
+	if got[1].Backup != "" || got[1].Count != 2 {
+		t.Errorf("expected - to read back as no backup, got %+v", got[1])
+	}
+}
@@ -0,0 +1,296 @@
+// Package squash implements locsquash: selecting commits, planning and executing the squash
+// with its backup, stash and recovery steps, and maintaining the backups it leaves behind.
+package squash
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Options holds the output settings that the locsquash command takes from its flags
+type Options struct {
+	JSON          bool          // Emit the dry-run plan and result summary as JSON on stdout
+	Quiet         bool          // Suppress progress messages
+	Verbose       bool          // Echo git commands before running them
+	Color         string        // ColorAuto, ColorAlways or ColorNever, as returned by ResolveColorMode
+	PromptTimeout time.Duration // Decline an unanswered confirmation prompt after this long; 0 waits forever
+}
+
+// Configure applies opts to all output of the package. Output goes to the process's stdout
+// and stderr, so the settings are process-wide rather than per Squasher.
+func Configure(opts Options) {
+	jsonOutput = opts.JSON
+	quietOutput = opts.Quiet
+	verboseOutput = opts.Verbose
+	colorMode = opts.Color
+	if colorMode == "" {
+		colorMode = ColorAuto
+	}
+	promptTimeout = opts.PromptTimeout
+}
+
+// Squasher squashes commits in one repository. Plan and Execute cover the squash itself;
+// Run also handles the backup maintenance modes selected by UserInput.
+type Squasher struct {
+	git GitRunner
+}
+
+// New returns a Squasher that runs git through g; NewGit provides the runner the locsquash
+// command uses
+func New(g GitRunner) *Squasher {
+	return &Squasher{git: g}
+}
+
+// NewGit returns a GitRunner for the repository containing dir, or the working directory if
+// dir is empty. Git runs from the repository root, and reads are cached until the first mutation.
+func NewGit(ctx context.Context, dir string) GitRunner {
+	return newCachingGit(newRepoGit(ctx, dir))
+}
+
+// Plan checks input against the repository and prints the planned operations, as -dry-run
+// does, without changing anything
+func (s *Squasher) Plan(ctx context.Context, input UserInput) error {
+	input.DryRun = true
+	return Run(ctx, s.git, &SquashInfo{UserInput: input})
+}
+
+// Execute squashes the commits selected by input, asking for confirmation unless input.Yes
+func (s *Squasher) Execute(ctx context.Context, input UserInput) error {
+	return Run(ctx, s.git, &SquashInfo{UserInput: input})
+}
+
+// UsageErrorf returns an error for invalid command-line input, which exits with the usage status
+func UsageErrorf(format string, args ...any) error {
+	return failCodef(exitUsage, format, args...)
+}
+
+// Run dispatches to the backup maintenance modes or the squash itself, the way the
+// locsquash command does for its flags
+func (s *Squasher) Run(ctx context.Context, input UserInput) error {
+	g := s.git
+	if err := checkGitVersion(ctx, g); err != nil {
+		return err
+	}
+
+	if input.ListBackups {
+		if err := ensureInsideGitRepo(ctx, g); err != nil {
+			return failCodef(exitUsage, "Error: %v", err)
+		}
+		refs, err := listBackupRefs(ctx, g, input.backupPrefix())
+		if err != nil {
+			return failCodef(exitGit, "Error listing backup branches: %v", err)
+		}
+		printBackupRefs(refs)
+		return nil
+	}
+
+	if input.PruneBackups != "" {
+		return pruneBackups(ctx, g, input)
+	}
+
+	if input.RecoverLast {
+		return recoverLast(ctx, g, input)
+	}
+
+	if input.RenameBackup != "" {
+		return renameBackup(ctx, g, input)
+	}
+
+	if input.History {
+		return showHistory(ctx, g, input)
+	}
+
+	if input.Plan != "" {
+		return runPlan(ctx, g, input)
+	}
+
+	if input.DryRunExitCode {
+		input.DryRun = true
+		// Any reason the squash could not go ahead is reported as a no-op; invalid flags and
+		// interrupts keep their own codes, so a script can tell them apart from a rejected squash
+		if err := Run(ctx, g, &SquashInfo{UserInput: input}); err != nil {
+			var e *exitError
+			if errors.As(err, &e) && (e.code == exitUsage || e.code == exitInterrupted) {
+				return err
+			}
+			return withExitCode(err, exitNoop)
+		}
+		return nil
+	}
+
+	return Run(ctx, g, &SquashInfo{UserInput: input})
+}
+
+// defaultProtectedBranches are never squashed without -force
+var defaultProtectedBranches = []string{"main", "master"}
+
+// protectedBranches returns the default protected branches plus those from the
+// LOCSQUASH_PROTECTED environment variable and the -protected flag (both comma-separated)
+func protectedBranches(flagValue string) []string {
+	branches := slices.Clone(defaultProtectedBranches)
+	for _, list := range []string{os.Getenv("LOCSQUASH_PROTECTED"), flagValue} {
+		for name := range strings.SplitSeq(list, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				branches = append(branches, name)
+			}
+		}
+	}
+	return branches
+}
+
+// pruneBackups deletes backup refs older than the age given by -prune-backups
+func pruneBackups(ctx context.Context, g GitRunner, input UserInput) error {
+	age, err := parseAge(input.PruneBackups)
+	if err != nil {
+		return failCodef(exitUsage, "Error: invalid -prune-backups value: %v", err)
+	}
+	if err = ensureInsideGitRepo(ctx, g); err != nil {
+		return failCodef(exitUsage, "Error: %v", err)
+	}
+	refs, err := listBackupRefs(ctx, g, input.backupPrefix())
+	if err != nil {
+		return failCodef(exitGit, "Error listing backup branches: %v", err)
+	}
+
+	cutoff := time.Now().Add(-age)
+	var stale []BackupRef
+	for _, b := range refs {
+		if !b.Created.IsZero() && b.Created.Before(cutoff) {
+			stale = append(stale, b)
+		}
+	}
+	if len(stale) == 0 {
+		statusf("No backups older than %s found.\n", input.PruneBackups)
+		return nil
+	}
+
+	statusf("The following %d backup %s will be deleted:\n\n", len(stale), backupNoun(stale))
+	for _, b := range stale {
+		statusf("  %s\n", formatBackupRef(b))
+	}
+	statusln()
+	if input.DryRun {
+		statusln("Dry run. No backups were deleted.")
+		return nil
+	}
+	if !input.Yes {
+		ok, pErr := confirm(ctx, "Delete these backups?")
+		if pErr != nil {
+			return pErr
+		}
+		if !ok {
+			statusln("Aborted.")
+			return nil
+		}
+	}
+
+	failed := 0
+	for _, b := range stale {
+		if dErr := deleteBackupRef(ctx, g, b); dErr != nil {
+			errorln(fmt.Sprintf("Failed to delete %s: %v", b.Name, dErr))
+			failed++
+			continue
+		}
+		statusf("Deleted %s\n", colorize(colorGreen, b.Name))
+	}
+	if failed > 0 {
+		return failCodef(exitGit, "Error: failed to delete %d backup(s).", failed)
+	}
+	return nil
+}
+
+// renameBackup renames the backup branch or tag given by -rename-backup. Both names must lie in
+// the backup namespace unless -force, and a recovery manifest naming the backup is updated.
+func renameBackup(ctx context.Context, g GitRunner, input UserInput) error {
+	if err := ensureInsideGitRepo(ctx, g); err != nil {
+		return failCodef(exitUsage, "Error: %v", err)
+	}
+	from, to := input.RenameBackup, input.RenameBackupTo
+	if namespace := input.backupNamespace(); !input.Force {
+		for _, name := range []string{from, to} {
+			if !strings.HasPrefix(name, namespace) || name == namespace {
+				return failf("Error: %q is outside the backup namespace %s; use -force to rename it anyway.", name, namespace)
+			}
+		}
+	}
+
+	b := BackupRef{Name: from}
+	switch {
+	case refExists(ctx, g, "refs/heads/"+from):
+	case refExists(ctx, g, "refs/tags/"+from):
+		b.Tag = true
+	default:
+		return failf("Error: backup %q does not exist; see -list-backups.", from)
+	}
+	namespace := "refs/heads/"
+	if b.Tag {
+		namespace = "refs/tags/"
+	}
+	if err := runGitCommand(ctx, g, "check-ref-format", namespace+to); err != nil {
+		return failCodef(exitUsage, "Error: %q is not a valid ref name.", to)
+	}
+	if refExists(ctx, g, namespace+to) {
+		return failf("Error: %s already exists.", to)
+	}
+
+	// Tags cannot be renamed in place, so the new one is created before the old one goes
+	if b.Tag {
+		if err := runGitCommand(ctx, g, "tag", to, from); err != nil {
+			return failCodef(exitGit, "Error renaming backup tag %s: %v", from, err)
+		}
+		if err := deleteBackupRef(ctx, g, b); err != nil {
+			return failCodef(exitGit, "Error: created tag %s but failed to delete %s: %v", to, from, err)
+		}
+	} else if err := runGitCommand(ctx, g, "branch", "-m", from, to); err != nil {
+		return failCodef(exitGit, "Error renaming backup branch %s: %v", from, err)
+	}
+
+	// Keep -recover-last working when it was this backup that recorded the last squash
+	if m, err := readManifest(ctx, g); err == nil && m.Backup == from && m.BackupTag == b.Tag {
+		m.Backup = to
+		if err = writeManifest(ctx, g, m); err != nil {
+			warnf("failed to update the recovery manifest: %v", err)
+		}
+	}
+	statusf("Renamed backup %s %s to %s\n", backupNoun([]BackupRef{b}), from, colorize(colorGreen, to))
+	return nil
+}
+
+// parseAge parses a duration such as 7d, 2w or any time.ParseDuration value (e.g. 36h)
+func parseAge(s string) (time.Duration, error) {
+	s = strings.TrimSpace(s)
+	units := map[string]time.Duration{"d": 24 * time.Hour, "w": 7 * 24 * time.Hour}
+	for suffix, unit := range units {
+		if n, ok := strings.CutSuffix(s, suffix); ok {
+			v, err := strconv.Atoi(n)
+			if err != nil || v < 0 {
+				return 0, fmt.Errorf("%q is not a valid age", s)
+			}
+			return time.Duration(v) * unit, nil
+		}
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("%q is not a valid age", s)
+	}
+	return d, nil
+}
+
+// Fatalf prints an error built from format and exits with the general failure status
+func Fatalf(format string, args ...any) {
+	Exit(failf(format, args...))
+}
+
+// Exit prints err in red, unless its message is empty, and exits with its exit code
+func Exit(err error) {
+	if msg := err.Error(); msg != "" {
+		fmt.Fprintln(os.Stderr, colorizeErr(colorRed, msg))
+	}
+	os.Exit(exitStatus(err))
+}
@@ -7,236 +7,204 @@ import (
 	"fmt"
 	"os"
 	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"strconv"
 	"strings"
+	"syscall"
 	"time"
+
+	"github.com/OutOfStack/locsquash/internal/squash"
 )
 
 func main() {
-	// Check git installed
-	if _, err := exec.LookPath("git"); err != nil {
-		fatalf("Error: git is not installed or not found in PATH.")
-	}
-
-	var input UserInput
+	var input squash.UserInput
 	var showVersion bool
+	var colorFlag string
+	var noColor bool
+	var promptTimeout time.Duration
+	var workdir string
 
 	flag.IntVar(&input.SquashCount, "n", 0, "Number of last commits to squash (must be at least 2)")
+	flag.StringVar(&input.ToRef, "to", "", "Squash commits from HEAD down to, but not including, the given ref (alternative to -n); with -from, the newest commit of the range")
+	flag.StringVar(&input.FromRef, "from", "", "Oldest commit of a range to squash; commits newer than -to are replayed on top")
+	flag.BoolVar(&input.Unpushed, "unpushed", false, "Squash exactly the commits not yet pushed to the upstream branch (alternative to -n)")
+	flag.StringVar(&input.SinceTag, "since-tag", "", "Squash all commits after the given tag")
+	flag.BoolVar(&input.SinceLatestTag, "since-latest-tag", false, "Squash all commits after the most recent tag")
+	flag.StringVar(&input.OntoRef, "onto", "", "Move the squashed commit (and any replayed commits) onto the given ref, like git rebase --onto")
+	flag.StringVar(&input.Branch, "branch", "", "Squash the given branch in place without checking it out (the current checkout is left untouched)")
+	flag.BoolVar(&input.Autofixup, "autofixup", false, "Fold unpushed fixup! and squash! commits into the commits they name, using git rebase -i --autosquash")
+	flag.BoolVar(&input.SquashMergesOnly, "squash-merges-only", false, "Fold the commits after the newest merge commit into that merge with git commit --amend, keeping both of its parents (alternative to -n)")
+	flag.BoolVar(&input.SquashWIP, "squash-wip", false, "Fold the consecutive WIP commits at HEAD (subjects matching -wip-pattern) into the first commit below them with git commit --amend (alternative to -n)")
+	flag.StringVar(&input.WIPPattern, "wip-pattern", squash.DefaultWIPPattern, "Regular expression matched against commit subjects by -squash-wip")
+	flag.BoolVar(&input.AmendBase, "amend-into-base", false, "Fold the selected commits into the commit below them with git commit --amend, keeping its message, author and dates, instead of creating a new commit")
+	flag.BoolVar(&input.Reword, "reword", false, "Only rewrite the latest commit's message (given with -m, -F or -edit), keeping its dates, instead of squashing")
 	flag.StringVar(&input.NewMessage, "m", "", "New commit message for the squashed commit")
+	flag.StringVar(&input.MessageFile, "F", "", "Read the commit message from the given file (\"-\" for stdin)")
+	flag.StringVar(&input.Author, "author", "", "Set the author of the squashed commit (\"Name <email>\")")
+	flag.StringVar(&input.Date, "date", "", "Override the author and committer date of the squashed commit (RFC 3339, e.g. 2024-06-01T12:00:00Z)")
+	flag.BoolVar(&input.KeepAuthor, "keep-author", true, "Preserve the author of the oldest squashed commit (set -keep-author=false to use your git config)")
+	flag.BoolVar(&input.KeepCommitter, "keep-committer", false, "Set the committer to the newest squashed commit's committer instead of your git config")
+	flag.BoolVar(&input.NoVerify, "no-verify", false, "Skip the pre-commit and commit-msg hooks when creating the squashed commit")
+	flag.BoolVar(&input.Sign, "sign", false, "GPG-sign the squashed commit")
+	flag.StringVar(&input.SignKey, "S", "", "GPG-sign the squashed commit with the given key id")
+	flag.StringVar(&input.MessageFrom, "message-from", "oldest", "Commit whose message is used when -m is not given: oldest or newest")
+	flag.BoolVar(&input.ConcatMessages, "concat-messages", false, "Combine all squashed commit messages (oldest first); -m becomes a header line")
+	flag.StringVar(&input.Cleanup, "cleanup", "default", "How the commit message is cleaned up, as in git commit --cleanup: default (strip after -edit, whitespace otherwise), strip, whitespace, verbatim or scissors")
+	flag.IntVar(&input.Wrap, "wrap", 0, "Word-wrap the body of the commit message at this many columns, leaving the subject alone (0 disables)")
+	flag.BoolVar(&input.StripComments, "strip-comments", false, "Remove lines starting with '#' and collapse blank lines in the commit message, like git commit --cleanup=strip")
+	flag.BoolVar(&input.Signoff, "signoff", false, "Add a Signed-off-by trailer for user.name/user.email to the squashed commit message")
+	flag.BoolVar(&input.Signoff, "s", false, "Add a Signed-off-by trailer (shorthand)")
+	flag.Func("trailer", `Append a "Key: value" trailer such as "Reviewed-by: Name <email>" to the squashed commit message (repeatable)`, func(v string) error {
+		input.Trailers = append(input.Trailers, v)
+		return nil
+	})
+	flag.BoolVar(&input.CollectCoauthors, "collect-coauthors", false, "Append the Co-authored-by trailers of all squashed commits to the message, deduplicated by email")
+	flag.BoolVar(&input.Conventional, "conventional", false, "Require the squashed commit subject to follow Conventional Commits (type(scope): subject)")
+	flag.StringVar(&input.CommitTypes, "conventional-types", strings.Join(squash.DefaultConventionalTypes, ","), "Comma-separated commit types accepted by -conventional")
+	flag.BoolVar(&input.CommitTemplate, "commit-template", false, "Without -m or -F, start from the file configured as commit.template instead of the oldest commit's message")
+	flag.BoolVar(&input.Edit, "edit", false, "Edit the squashed commit message in $EDITOR before committing")
 	flag.BoolVar(&input.AllowStash, "stash", false, "Auto-stash uncommitted changes (default requires clean state)")
-	flag.BoolVar(&input.AllowEmpty, "allow-empty", false, "Allow creating an empty commit if squashed changes cancel out")
-	flag.BoolVar(&input.DryRun, "dry-run", false, "Print the git commands that would run, without making changes")
+	flag.BoolVar(&input.StashUntracked, "stash-untracked", true, "Include untracked files in the auto-stash (-stash-untracked=false leaves them in place)")
+	flag.BoolVar(&input.StashAll, "stash-all", false, "Include untracked and ignored files in the auto-stash")
+	flag.BoolVar(&input.AllowEmpty, "allow-empty", false, "Allow creating an empty squashed commit if the selected changes cancel out (empty commits inside the range need no flag)")
+	flag.BoolVar(&input.AllowMerges, "allow-merges", false, "Allow squashing a range that contains merge commits (flattens their history)")
+	flag.BoolVar(&input.ForcePushed, "force-pushed", false, "Allow squashing commits that already exist on a remote branch (requires a force-push)")
+	flag.StringVar(&input.MaxAge, "max-age", "", "Refuse to squash if the oldest selected commit is older than the given age (e.g. 72h, 7d)")
+	flag.StringVar(&input.Protected, "protected", "", "Additional comma-separated branch names to protect (main and master are always protected; see also LOCSQUASH_PROTECTED)")
+	flag.BoolVar(&input.Force, "force", false, "Override safety guards such as the protected-branch check")
+	flag.BoolVar(&input.DryRun, "dry-run", false, "Print the git commands that would run and how to undo them, without making changes")
+	flag.StringVar(&input.Plan, "plan", "", "Execute the squash recorded by -dump-plan in the given file, refusing if the commits changed since")
+	flag.StringVar(&input.DumpPlan, "dump-plan", "", "Write the computed plan (commits, refs, backup, message) as JSON to the given file; implies -dry-run")
+	flag.BoolVar(&input.DryRunExitCode, "dry-run-exit-code", false, "Dry run that exits with 0 if the squash is viable, 5 if it would be rejected or change nothing, and 2 for invalid flags")
+	flag.BoolVar(&input.Stat, "stat", false, "Show insertions and deletions of each commit in the commit list")
+	flag.BoolVar(&input.ShowDiff, "show-diff", false, "With -dry-run, also print the combined diff that the squashed commit will contain")
+	flag.BoolVar(&input.JSON, "json", false, "Print the dry-run plan and result summary as JSON on stdout; status messages go to stderr")
+	flag.BoolVar(&input.Quiet, "quiet", false, "Suppress progress messages; errors are still printed")
+	flag.BoolVar(&input.Quiet, "q", false, "Suppress progress messages (shorthand)")
+	flag.BoolVar(&input.Verbose, "verbose", false, "Echo every git command to stderr before running it")
 	flag.BoolVar(&input.PrintRecovery, "print-recovery", false, "Print recovery commands and exit")
 	flag.BoolVar(&input.NoBackup, "no-backup", false, "Skip creating backup branch")
-	flag.BoolVar(&input.Yes, "yes", false, "Skip confirmation prompt")
+	flag.StringVar(&input.Exec, "exec", "", "Shell command to run from the repository root after a successful squash (a failure is reported but the squash is kept)")
+	flag.BoolVar(&input.NoHistory, "no-history", false, "Don't append this squash to the history log in .git/locsquash-history.log")
+	flag.BoolVar(&input.KeepBackup, "keep-backup-on-success", true, "Keep the backup after a successful squash (set -keep-backup-on-success=false to delete it)")
+	flag.BoolVar(&input.NoAutoRecover, "no-auto-recover", false, "On failure, only print recovery instructions instead of resetting to the backup automatically")
+	flag.StringVar(&input.BackupPrefix, "backup-prefix", squash.DefaultBackupPrefix, "Prefix of backup branch and tag names; a timestamp is appended")
+	flag.BoolVar(&input.TagBackup, "tag-backup", false, "Create the backup as a lightweight tag instead of a branch")
+	flag.StringVar(&input.PushBackup, "push-backup", "", "Push the backup to the given remote after creating it, so it survives the loss of the local repository (a failed push only warns)")
+	flag.BoolVar(&input.Preview, "preview", false, "Show the diffstat of the squash even when not on a terminal, then ask for confirmation unless -y")
+	flag.DurationVar(&promptTimeout, "prompt-timeout", 0, "Treat the confirmation prompt as declined if there is no answer within this duration (e.g. 30s); 0 waits forever")
+	flag.IntVar(&input.WarnThreshold, "warn-threshold", 20, "Ask for an extra confirmation before squashing more than this many commits, unless -yes is given (0 disables)")
+	flag.BoolVar(&input.YesIfClean, "yes-if-clean", false, "Skip the confirmation prompt only if the working tree has no uncommitted changes (-yes always skips it)")
+	flag.BoolVar(&input.Yes, "yes", false, "Skip confirmation prompt (LOCSQUASH_ASSUME_YES=1 does the same unless -yes is given)")
 	flag.BoolVar(&input.Yes, "y", false, "Skip confirmation prompt (shorthand)")
-	flag.BoolVar(&input.ListBackups, "list-backups", false, "List all backup branches and exit")
+	flag.BoolVar(&input.ListBackups, "list-backups", false, "List all backup branches and tags and exit")
+	flag.BoolVar(&input.RecoverLast, "recover-last", false, "Undo the most recent squash using its recovery manifest and exit")
+	flag.BoolVar(&input.History, "history", false, "Print the squashes recorded in .git/locsquash-history.log, most recent first, noting which backups still exist, and exit (with -json as JSON)")
+	flag.StringVar(&input.RenameBackup, "rename-backup", "", "Rename a backup branch or tag and exit: -rename-backup <old> <new> (both must stay under the backup prefix's namespace unless -force)")
+	flag.StringVar(&input.PruneBackups, "prune-backups", "", "Delete backups older than the given age (e.g. 7d, 12h) and exit")
+	flag.StringVar(&colorFlag, "color", squash.ColorAuto, "When to use colors: auto, always or never (NO_COLOR is honored in auto mode)")
+	flag.BoolVar(&noColor, "no-color", false, "Disable colored output")
+	flag.StringVar(&workdir, "C", "", "Run as if locsquash was started in the given directory, like git -C")
+	flag.StringVar(&workdir, "workdir", "", "Run as if locsquash was started in the given directory (long form of -C)")
 	flag.BoolVar(&showVersion, "version", false, "Print version and exit")
 	flag.BoolVar(&showVersion, "v", false, "Print version and exit (shorthand)")
 
 	flag.Parse()
 
-	if showVersion {
-		fmt.Println("locsquash", version)
+	// Handle -version and the bare `version` subcommand before anything needs git
+	if showVersion || (flag.NArg() == 1 && flag.Arg(0) == "version") {
+		fmt.Println(versionString())
 		os.Exit(0)
 	}
 
-	ctx := context.Background()
-
-	if input.ListBackups {
-		if err := ensureInsideGitRepo(ctx); err != nil {
-			fatalf("Error: %v", err)
+	// -rename-backup takes the new name as the next argument; more flags may follow it
+	if input.RenameBackup != "" {
+		if flag.NArg() == 0 {
+			squash.Exit(squash.UsageErrorf("Error: -rename-backup needs the new name: -rename-backup <old> <new>."))
 		}
-		branches, err := listBackupBranches(ctx)
-		if err != nil {
-			fatalf("Error listing backup branches: %v", err)
+		input.RenameBackupTo = flag.Arg(0)
+		_ = flag.CommandLine.Parse(flag.Args()[1:])
+		if flag.NArg() > 0 {
+			squash.Exit(squash.UsageErrorf("Error: unexpected argument %q after -rename-backup <old> <new>.", flag.Arg(0)))
 		}
-		printBackupBranches(branches)
-		os.Exit(0)
 	}
-
-	if input.SquashCount < 2 {
-		fatalf("Error: -n (Number of last commits to squash) must be at least 2.")
+	// flag stops at the first non-flag argument, so anything after it, -dry-run included,
+	// would be silently dropped
+	if flag.NArg() > 0 {
+		squash.Exit(squash.UsageErrorf("Error: unexpected argument %q; the flags after it were not read. Flag values must follow their flag, e.g. -since-tag v1.0.", flag.Arg(0)))
 	}
 
-	// Check if in git repo
-	if err := ensureInsideGitRepo(ctx); err != nil {
-		fatalf("Error: %v", err)
-	}
-
-	// Check if git has an operation in progress
-	if err := ensureNoInProgressOps(ctx); err != nil {
-		fatalf("Error: %v", err)
-	}
-
-	totalCommits, err := gitCommitCount(ctx)
-	if err != nil {
-		fatalf("Error retrieving commit count: %v", err)
-	}
-	if totalCommits < 2 {
-		fatalf("Error: repository only has %d commit; need at least 2 commits to squash.", totalCommits)
-	}
-	if input.SquashCount >= totalCommits {
-		fatalf("Error: repository has %d commits; -n must be at most %d (one commit must remain as the base).", totalCommits, totalCommits-1)
+	// Check git installed
+	if _, err := exec.LookPath("git"); err != nil {
+		squash.Fatalf("Error: git is not installed or not found in PATH.")
 	}
 
-	info := SquashInfo{UserInput: input}
-
-	// Check for uncommitted changes
-	info.Dirty, err = hasUncommittedChanges(ctx)
-	if err != nil {
-		fatalf("Error checking git status: %v", err)
-	}
-	if info.Dirty && !input.AllowStash {
-		if input.DryRun || input.PrintRecovery {
-			fmt.Fprintln(os.Stderr, colorizeErr(colorYellow, "Warning: uncommitted changes detected. Preview may not reflect a clean working tree; use -stash to simulate a clean state."))
-		} else {
-			fatalf("Error: uncommitted changes detected. Commit/stash them or rerun with -stash.")
+	// Like git -C, relative -F, -plan and -dump-plan paths are taken relative to -C; whether the
+	// directory is a work tree is checked by the squash, as it is for the current directory
+	if workdir != "" {
+		if fi, err := os.Stat(workdir); err != nil || !fi.IsDir() {
+			squash.Exit(squash.UsageErrorf("Error: -C %s is not a directory.", workdir))
 		}
-	}
-
-	// Compute result commit
-	oldestCommitRef := fmt.Sprintf("HEAD~%d", info.SquashCount-1)
-	oldestMessage, err := gitLogSingle(ctx, oldestCommitRef, "%B")
-	if err != nil {
-		fatalf("Failed to retrieve oldest commit message: %v", err)
-	}
-	oldestMessage = strings.TrimSpace(oldestMessage)
-
-	info.CommitMessage = strings.TrimSpace(info.NewMessage)
-	if info.CommitMessage == "" {
-		info.CommitMessage = oldestMessage
-	}
-
-	recentDate, err := gitLogSingle(ctx, "HEAD", "%cI")
-	if err != nil {
-		fatalf("Failed to retrieve HEAD commit date: %v", err)
-	}
-	info.RecentDate = strings.TrimSpace(recentDate)
-
-	info.BackupName = "locsquash/backup-" + time.Now().UTC().Format("20060102-150405")
-	info.ResetRef = fmt.Sprintf("HEAD~%d", info.SquashCount)
-
-	hasChanges, err := gitHasChangesBetween(ctx, info.ResetRef, "HEAD")
-	if err != nil {
-		fatalf("Error checking commit diff: %v", err)
-	}
-	if !hasChanges && !info.AllowEmpty {
-		fatalf("Error: selected commits result in no net changes. Use -allow-empty to create an empty commit.")
-	}
-
-	// Retrieve commit list for preview
-	info.Commits, err = gitLogCommits(ctx, info.SquashCount)
-	if err != nil {
-		fatalf("Error retrieving commit list: %v", err)
-	}
-
-	if info.DryRun {
-		info.printDryRun()
-	}
-
-	if info.PrintRecovery {
-		info.printRecovery()
-	}
-
-	if info.DryRun || info.PrintRecovery {
-		return
-	}
-
-	// Show commits and prompt for confirmation (unless -yes)
-	if !info.Yes {
-		info.printCommitList()
-		if !promptConfirm() {
-			fmt.Println("Aborted.")
-			os.Exit(0)
+		for _, path := range []*string{&input.MessageFile, &input.Plan, &input.DumpPlan} {
+			if *path != "" && *path != "-" && !filepath.IsAbs(*path) {
+				*path = filepath.Join(workdir, *path)
+			}
 		}
 	}
 
-	// Stash if needed
-	stashedRef := ""
-	if info.Dirty && info.AllowStash {
-		ref, sErr := stashPushAndGetRef(ctx)
-		if sErr != nil {
-			fatalf("Failed to stash changes: %v", sErr)
-		}
-		stashedRef = ref
-		fmt.Printf("Stashed working directory changes as %s\n", colorize(colorCyan, stashedRef))
-	}
+	// Ctrl-C cancels the context: running git commands are stopped and a squash in progress
+	// restores the backup. Once canceled, a second Ctrl-C kills locsquash as usual.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
+	git := squash.NewGit(ctx, workdir)
 
-	// Create recovery branch before rewriting history (unless -no-backup)
-	if !info.NoBackup {
-		createdName, cErr := createBackupBranch(ctx, info.BackupName)
-		if cErr != nil {
-			fatalf("Failed to create backup branch %q: %v", info.BackupName, cErr)
-		}
-		info.BackupName = createdName
-		fmt.Printf("Created backup branch: %s (recovery point)\n", colorize(colorGreen, info.BackupName))
-	} else {
-		info.BackupName = "" // Clear so recoveryHint knows no backup exists
+	// Fill in defaults from .locsquash.yml; explicit flags win
+	if err := squash.LoadConfig(ctx, git, flag.CommandLine); err != nil {
+		squash.Exit(squash.UsageErrorf("Error: %v", err))
 	}
-
-	// Soft reset to HEAD~N
-	fmt.Printf("Performing soft reset to %s...\n", info.ResetRef)
-	if err = runGitCommand(ctx, "reset", "--soft", info.ResetRef); err != nil {
-		fatalf("Failed to perform soft reset: %v%s", err, recoveryHint(info.BackupName))
+	if err := applyAssumeYes(flag.CommandLine, &input); err != nil {
+		squash.Exit(squash.UsageErrorf("Error: %v", err))
 	}
 
-	// Commit staged changes as one, with date = most recent commit date
-	fmt.Println("Creating squashed commit...")
-	if err = gitCommitWithDates(ctx, info.RecentDate, info.CommitMessage, info.AllowEmpty); err != nil {
-		fatalf("Failed to create squashed commit: %v%s", err, recoveryHint(info.BackupName))
+	opts := squash.Options{JSON: input.JSON, Quiet: input.Quiet, Verbose: input.Verbose, PromptTimeout: promptTimeout}
+	mode, cErr := squash.ResolveColorMode(colorFlag, noColor)
+	if cErr != nil {
+		opts.Color = squash.ColorNever
+		squash.Configure(opts)
+		squash.Exit(squash.UsageErrorf("Error: %v", cErr))
 	}
-
-	// Reapply stash if we created one: apply first, then drop only if success
-	if stashedRef != "" {
-		fmt.Printf("Reapplying stashed changes from %s...\n", stashedRef)
-		if err = runGitCommand(ctx, "stash", "apply", stashedRef); err != nil {
-			fatalf("Stash apply failed (stash preserved as %s): %v%s", stashedRef, err, recoveryHint(info.BackupName))
-		}
-		if err = runGitCommand(ctx, "stash", "drop", stashedRef); err != nil {
-			fatalf("Applied stash but failed to drop %s: %v\nYou can drop it manually later.%s", stashedRef, err, recoveryHint(info.BackupName))
-		}
+	opts.Color = mode
+	squash.Configure(opts)
+	if promptTimeout < 0 {
+		squash.Exit(squash.UsageErrorf("Error: -prompt-timeout must not be negative."))
 	}
 
-	fmt.Println(colorize(colorGreen, fmt.Sprintf("Successfully squashed the last %d commits.", info.SquashCount)))
-	if !info.NoBackup {
-		fmt.Printf("Backup branch: %s\n", colorize(colorCyan, info.BackupName))
+	if err := squash.New(git).Run(ctx, input); err != nil {
+		squash.Exit(err)
 	}
 }
 
-func fatalf(format string, args ...any) {
-	msg := fmt.Sprintf(format, args...)
-	fmt.Fprintln(os.Stderr, colorizeErr(colorRed, msg))
-	os.Exit(1)
-}
+// assumeYesEnv names the environment variable that answers confirmation prompts like -yes
+const assumeYesEnv = "LOCSQUASH_ASSUME_YES"
 
-// recoveryHint returns a recovery message based on whether backup branch exists
-func recoveryHint(backupName string) string {
-	if backupName == "" {
-		return "\nRecovery: use 'git reflog' to find the commit hash before the squash, then 'git reset --hard <hash>'"
+// applyAssumeYes sets input.Yes from LOCSQUASH_ASSUME_YES, unless -yes/-y was given on the
+// command line or in a config file, which takes precedence
+func applyAssumeYes(fs *flag.FlagSet, input *squash.UserInput) error {
+	explicit := false
+	fs.Visit(func(f *flag.Flag) { explicit = explicit || f.Name == "yes" || f.Name == "y" })
+	value := os.Getenv(assumeYesEnv)
+	if explicit || value == "" {
+		return nil
 	}
-	return "\nRecovery: git reset --hard " + backupName
-}
-
-// isTerminal checks if stdin is connected to a terminal
-func isTerminal() bool {
-	fi, err := os.Stdin.Stat()
+	yes, err := strconv.ParseBool(value)
 	if err != nil {
-		return false
-	}
-	return fi.Mode()&os.ModeCharDevice != 0
-}
-
-// promptConfirm asks the user for confirmation and returns true if they confirm.
-// If stdin is not a terminal (e.g., piped input), it aborts with an error
-func promptConfirm() bool {
-	if !isTerminal() {
-		fatalf("Error: stdin is not a terminal. Use -y to skip confirmation in non-interactive mode.")
-	}
-	fmt.Print("Proceed? [y/N] ")
-	var response string
-	if _, err := fmt.Scanln(&response); err != nil {
-		return false
+		return fmt.Errorf("invalid %s value %q: expected 1, true, 0 or false", assumeYesEnv, value)
 	}
-	response = strings.ToLower(strings.TrimSpace(response))
-	return response == "y" || response == "yes"
+	input.Yes = yes
+	return nil
 }
@@ -1,7 +1,9 @@
 package main_test
 
 import (
+	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"os"
 	"os/exec"
@@ -57,9 +59,16 @@ func buildTestBinary(t *testing.T) string {
 	return testBinaryPath
 }
 
-// newTestRepo creates a new temporary git repository for testing
+// newTestRepo creates a new temporary git repository for testing on a work branch,
+// since main/master are protected by default
 func newTestRepo(t *testing.T) *testRepo {
 	t.Helper()
+	return newTestRepoOnBranch(t, "work")
+}
+
+// newTestRepoOnBranch creates a new temporary git repository whose initial branch is branch
+func newTestRepoOnBranch(t *testing.T, branch string) *testRepo {
+	t.Helper()
 
 	dir := t.TempDir()
 
@@ -69,14 +78,23 @@ func newTestRepo(t *testing.T) *testRepo {
 		Binary: buildTestBinary(t),
 	}
 
-	// Initialize git repo
-	tr.git(t.Context(), "init")
+	tr.git(t.Context(), "init", "-b", branch)
 	tr.git(t.Context(), "config", "user.email", "test@test.local")
 	tr.git(t.Context(), "config", "user.name", "Test User")
 
 	return tr
 }
 
+// addRemote creates a bare repository, adds it as origin and pushes the current branch with upstream tracking
+func (tr *testRepo) addRemote() string {
+	tr.t.Helper()
+	remote := tr.t.TempDir()
+	tr.git(tr.t.Context(), "init", "-q", "--bare", remote)
+	tr.git(tr.t.Context(), "remote", "add", "origin", remote)
+	tr.git(tr.t.Context(), "push", "-q", "-u", "origin", "HEAD")
+	return remote
+}
+
 // git runs a git command in the test repository
 func (tr *testRepo) git(ctx context.Context, args ...string) string {
 	tr.t.Helper()
@@ -115,6 +133,25 @@ func (tr *testRepo) createCommit(message string) {
 	tr.git(tr.t.Context(), "commit", "-m", message)
 }
 
+// createCommitAs creates a commit with the given message attributed to author ("Name <email>")
+func (tr *testRepo) createCommitAs(author, message string) {
+	tr.t.Helper()
+	tr.writeFile(strings.ReplaceAll(message, " ", "-")+".txt", message+"\n")
+	tr.git(tr.t.Context(), "add", ".")
+	tr.git(tr.t.Context(), "commit", "--author", author, "-m", message)
+}
+
+// createMerge creates a side branch with one commit and merges it into the current branch with --no-ff
+func (tr *testRepo) createMerge(branch string) {
+	tr.t.Helper()
+	tr.git(tr.t.Context(), "checkout", "-q", "-b", branch)
+	tr.writeFile(branch+".txt", branch+"\n")
+	tr.git(tr.t.Context(), "add", ".")
+	tr.git(tr.t.Context(), "commit", "-m", branch+" work")
+	tr.git(tr.t.Context(), "checkout", "-q", "-")
+	tr.git(tr.t.Context(), "merge", "--no-ff", "-m", "merge "+branch, branch)
+}
+
 // createCommitsWithMessages creates commits with specific messages
 func (tr *testRepo) createCommitsWithMessages(messages ...string) {
 	tr.t.Helper()
@@ -142,13 +179,65 @@ func (tr *testRepo) lastCommitMessage() string {
 
 // runCLI runs the locsquash binary with the given arguments
 func (tr *testRepo) runCLI(args ...string) (string, error) {
+	tr.t.Helper()
+	return tr.runCLIWithEnv(nil, args...)
+}
+
+// runCLIWithEnv runs the locsquash binary with extra environment variables
+func (tr *testRepo) runCLIWithEnv(env []string, args ...string) (string, error) {
+	tr.t.Helper()
+	cmd := exec.CommandContext(tr.t.Context(), tr.Binary, args...) //nolint:gosec
+	cmd.Dir = tr.Dir
+	cmd.Env = append(os.Environ(), env...)
+	out, err := cmd.CombinedOutput()
+	return string(out), err
+}
+
+// runCLIFrom runs the locsquash binary from dir instead of the repository
+func (tr *testRepo) runCLIFrom(dir string, args ...string) (string, error) {
+	tr.t.Helper()
+	cmd := exec.CommandContext(tr.t.Context(), tr.Binary, args...) //nolint:gosec
+	cmd.Dir = dir
+	out, err := cmd.CombinedOutput()
+	return string(out), err
+}
+
+// runCLIWithStdin runs the locsquash binary with the given input on stdin
+func (tr *testRepo) runCLIWithStdin(stdin string, args ...string) (string, error) {
 	tr.t.Helper()
 	cmd := exec.CommandContext(tr.t.Context(), tr.Binary, args...) //nolint:gosec
 	cmd.Dir = tr.Dir
+	cmd.Stdin = strings.NewReader(stdin)
 	out, err := cmd.CombinedOutput()
 	return string(out), err
 }
 
+// runCLISplit runs the locsquash binary and returns stdout and stderr separately
+func (tr *testRepo) runCLISplit(args ...string) (string, string, error) {
+	tr.t.Helper()
+	cmd := exec.CommandContext(tr.t.Context(), tr.Binary, args...) //nolint:gosec
+	cmd.Dir = tr.Dir
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+	return stdout.String(), stderr.String(), err
+}
+
+// runCLIExitCode runs the CLI and returns its combined output and exit code
+func (tr *testRepo) runCLIExitCode(args ...string) (string, int) {
+	tr.t.Helper()
+	out, err := tr.runCLI(args...)
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return out, exitErr.ExitCode()
+	}
+	if err != nil {
+		tr.t.Fatalf("failed to run CLI: %v", err)
+	}
+	return out, 0
+}
+
 // runCLISuccess runs the CLI and fails the test if it doesn't succeed
 func (tr *testRepo) runCLISuccess(args ...string) string {
 	tr.t.Helper()
@@ -169,6 +258,25 @@ func (tr *testRepo) runCLIFailure(args ...string) string {
 	return out
 }
 
+// writeScript writes an executable shell script outside the repository and returns its path
+func (tr *testRepo) writeScript(name, body string) string {
+	tr.t.Helper()
+	path := filepath.Join(tr.t.TempDir(), name)
+	if err := os.WriteFile(path, []byte("#!/bin/sh\n"+body), 0700); err != nil { //nolint:gosec
+		tr.t.Fatalf("failed to write script %s: %v", name, err)
+	}
+	return path
+}
+
+// writeHook installs an executable git hook script in the test repository
+func (tr *testRepo) writeHook(name, body string) {
+	tr.t.Helper()
+	path := filepath.Join(tr.Dir, ".git", "hooks", name)
+	if err := os.WriteFile(path, []byte("#!/bin/sh\n"+body), 0700); err != nil { //nolint:gosec
+		tr.t.Fatalf("failed to write hook %s: %v", name, err)
+	}
+}
+
 // writeFile writes content to a file in the test repository
 func (tr *testRepo) writeFile(name, content string) {
 	tr.t.Helper()
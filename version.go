@@ -1,6 +1,10 @@
 package main
 
-import "runtime/debug"
+import (
+	"fmt"
+	"runtime"
+	"runtime/debug"
+)
 
 // version is set at build time via ldflags:
 //
@@ -23,5 +27,10 @@ func getVersion() string {
 	return "dev"
 }
 
+// versionString returns the version line printed by -version, including the Go toolchain and platform
+func versionString() string {
+	return fmt.Sprintf("locsquash %s (%s %s/%s)", version, runtime.Version(), runtime.GOOS, runtime.GOARCH)
+}
+
 // ldflagsVersion is set at build time via -ldflags "-X main.ldflagsVersion=v1.0.0"
 var ldflagsVersion string